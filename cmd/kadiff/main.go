@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/segmentio/cli"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
@@ -17,8 +18,18 @@ const (
 	diffDesc = `
 Generates a structured diff between Kubernetes manifests in two directories.
 
-This tool is used by the provider to generate structured diffs.
+This tool is used by the provider to generate structured diffs. It can also be used
+stand-alone by setting --output to one of:
+
+  json      structured results (default; used by the provider)
+  table     summary table of the changed resources, followed by the raw diffs
+  markdown  table plus a collapsible section per resource, suitable for PR comments
+  patch     raw diffs of all resources concatenated together
+  junit     JUnit XML report with a failed test case for each changed resource
 `
+
+	// Maximum length of each raw diff in markdown outputs
+	maxMarkdownDiffLength = 2000
 )
 
 type kaDiffConfig struct {
@@ -27,6 +38,15 @@ type kaDiffConfig struct {
 	ContextLines  int `flag:"--context-lines" help:"Number of context lines to show in diff outputs" default:"3"`
 	MaxLineLength int `flag:"--max-line-length" help:"Max length of lines from diff" default:"256"`
 	MaxSize       int `flag:"--max-size" help:"Total maximum size of diff after clipping long lines" default:"3000"`
+
+	Mode string `flag:"--mode" help:"How objects are compared; either line or structural" default:"line"`
+
+	SensitiveKinds string `flag:"--sensitive-kinds" help:"Comma-separated kinds, in addition to Secret, whose values are redacted" default:"-"`
+	SensitivePaths string `flag:"--sensitive-paths" help:"Comma-separated fields, in kind:dotted.path format, whose values are redacted" default:"-"`
+
+	Output string `flag:"--output" help:"Output format; one of json, table, markdown, patch, or junit" default:"json"`
+
+	IgnoreFields string `flag:"--ignore-fields" help:"JSON list of rules, each with optional kind and name selectors and a list of paths, for fields to ignore" default:"-"`
 }
 
 func init() {
@@ -64,6 +84,11 @@ func runKADiff(
 	new string,
 	config kaDiffConfig,
 ) error {
+	ignoreFields, err := diff.ParseIgnoreRules(config.IgnoreFields)
+	if err != nil {
+		return err
+	}
+
 	results, err := diff.DiffKube(
 		old,
 		new,
@@ -71,21 +96,64 @@ func runKADiff(
 			ContextLines:  config.ContextLines,
 			MaxLineLength: config.MaxLineLength,
 			MaxSize:       config.MaxSize,
+			Mode:          diff.DiffMode(config.Mode),
+
+			SensitiveKinds: splitList(config.SensitiveKinds),
+			SensitivePaths: splitList(config.SensitivePaths),
+			IgnoreFields:   ignoreFields,
 		},
 	)
 	if err != nil {
 		return err
 	}
 
-	wrappedResults := diff.Results{
-		Results: results,
-	}
+	switch config.Output {
+	case "json":
+		wrappedResults := diff.Results{
+			Results: results,
+		}
 
-	jsonBytes, err := json.MarshalIndent(wrappedResults, "", "  ")
-	if err != nil {
-		return err
+		jsonBytes, err := json.MarshalIndent(wrappedResults, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+	case "table":
+		if len(results) == 0 {
+			fmt.Println("No diffs found")
+			return nil
+		}
+
+		fmt.Println(diff.ResultsTable(results))
+		for _, result := range results {
+			result.PrintRaw()
+		}
+	case "markdown":
+		fmt.Print(diff.ResultsMarkdown(results, maxMarkdownDiffLength))
+	case "patch":
+		fmt.Print(diff.ResultsPatch(results))
+	case "junit":
+		xmlBytes, err := diff.ResultsJUnit(results, "kadiff")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(xmlBytes))
+	default:
+		return fmt.Errorf(
+			"Unknown output format %s; must be one of json, table, markdown, patch, junit",
+			config.Output,
+		)
 	}
-	fmt.Println(string(jsonBytes))
 
 	return nil
 }
+
+func splitList(value string) []string {
+	elements := []string{}
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
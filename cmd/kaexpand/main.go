@@ -15,6 +15,8 @@ import (
 	"github.com/segmentio/cli"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/kustomize"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 	log "github.com/sirupsen/logrus"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
@@ -230,6 +232,10 @@ func runExpand(
 		return err
 	}
 
+	if err := kustomize.BuildAll(outputDir); err != nil {
+		return err
+	}
+
 	log.Infof("Successfully wrote outputs to %s", outputDir)
 	return nil
 }
@@ -237,7 +243,12 @@ func runExpand(
 func runDiff(ctx context.Context, path string, client cluster.Client) error {
 	log.Infof("Running diff for configs in %s", path)
 
-	results, err := client.DiffStructured(ctx, []string{path}, false)
+	results, err := client.DiffStructured(
+		ctx,
+		[]string{path},
+		kube.ServerSideOptions{Enabled: client.Config().ServerSideApply},
+		nil,
+	)
 	if err != nil {
 		return err
 	}
@@ -263,7 +274,11 @@ func runApply(
 		return fmt.Errorf("Stopping because of user response")
 	}
 
-	results, err := client.Apply(ctx, []string{path}, false)
+	results, err := client.Apply(
+		ctx,
+		[]string{path},
+		kube.ServerSideOptions{Enabled: client.Config().ServerSideApply},
+	)
 	if err != nil {
 		return nil
 	}
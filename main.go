@@ -80,6 +80,11 @@ func runProvider(ctx context.Context, debugServer bool) error {
 		}
 	}
 
+	// Serve returns once Terraform shuts the provider down gracefully, so remove the cached
+	// profile sources then. The above still catches anything left by providers that were
+	// killed.
+	defer provider.CleanupSources()
+
 	if debugServer {
 		log.Info("Running server in debug mode")
 		return plugin.Debug(ctx, "segmentio/kubeapply", opts)
@@ -3,24 +3,64 @@ package cluster
 import (
 	"context"
 
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
 )
 
 // Client is an interface that interacts with the API of a single Kubernetes cluster.
 type Client interface {
-	// Apply applies all of the configs at the given path.
-	Apply(ctx context.Context, paths []string, serverSide bool) ([]byte, error)
+	// Apply applies all of the configs at the given path. Server-side apply conflicts are
+	// returned as a *kube.ApplyConflictsError.
+	Apply(
+		ctx context.Context,
+		paths []string,
+		serverSide kube.ServerSideOptions,
+	) ([]byte, error)
 
-	// Delete deletes the resources associated with one or more configs.
-	Delete(ctx context.Context, ids []string) ([]byte, error)
+	// ApplyStructured applies all of the configs at the given path and returns structured,
+	// per-resource results.
+	ApplyStructured(
+		ctx context.Context,
+		paths []string,
+		serverSide kube.ServerSideOptions,
+	) ([]apply.Result, error)
+
+	// Delete deletes the resources associated with one or more configs. If the resources
+	// aren't removed before the wait timeout in the argument options, then a
+	// *kube.DeleteTimeoutError is returned.
+	Delete(ctx context.Context, ids []string, options kube.DeleteOptions) ([]byte, error)
 
 	// Diff gets the diffs between the configs at the given path and the actual state of resources
 	// in the cluster. It returns the raw output.
-	Diff(ctx context.Context, paths []string, serverSide bool) ([]byte, error)
+	Diff(ctx context.Context, paths []string, serverSide kube.ServerSideOptions) ([]byte, error)
 
 	// DiffStructured gets the diffs between the configs at the given path and the actual state of
-	// resources in the cluster. It returns structured output.
-	DiffStructured(ctx context.Context, paths []string, serverSide bool) ([]diff.Result, error)
+	// resources in the cluster. The fields in the argument ignore rules are stripped from both
+	// versions of each object before they're compared. It returns structured output.
+	DiffStructured(
+		ctx context.Context,
+		paths []string,
+		serverSide kube.ServerSideOptions,
+		ignoreFields []diff.IgnoreRule,
+	) ([]diff.Result, error)
+
+	// Hashes returns hashes of the applied fields in the live versions of the resources
+	// associated with the argument ids. The fields in the argument ignore rules are left out
+	// of the hashes. Resources that aren't in the cluster have empty hashes.
+	Hashes(
+		ctx context.Context,
+		ids []string,
+		ignoreFields []diff.IgnoreRule,
+	) (map[string]string, error)
+
+	// Annotations returns the annotations of the live versions of the resources associated
+	// with the argument ids. Resources that aren't in the cluster are omitted.
+	Annotations(ctx context.Context, ids []string) (map[string]map[string]string, error)
+
+	// LabeledIDs returns the ids of all of the resources in the cluster that match the
+	// argument label selector.
+	LabeledIDs(ctx context.Context, labelSelector string) ([]string, error)
 
 	// Config returns the config for this cluster.
 	Config() *Config
@@ -47,4 +87,7 @@ type ClientConfig struct {
 
 	// Extra environment variables to add into kubectl calls.
 	ExtraEnv []string
+
+	// DiffConfig configures the diffs generated by clients that don't shell out to kadiff.
+	DiffConfig diff.DiffConfig
 }
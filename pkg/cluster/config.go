@@ -44,6 +44,12 @@ type Config struct {
 	// can be used to compare the configuration states of various clusters.
 	ConfigHash string `json:"configHash"`
 
+	// SourceVersion is the resolved version of the source that the configs were fetched from,
+	// e.g. a git commit SHA or the digest of an OCI artifact. It's empty for local sources.
+	//
+	// Optional.
+	SourceVersion string `json:"sourceVersion"`
+
 	// ExpandedPath is the path to the results of expanding out all of the configs for this cluster.
 	//
 	// Optional, defaults to "expanded/[env]/[region]" if not set.
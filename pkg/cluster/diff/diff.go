@@ -2,6 +2,7 @@ package diff
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
@@ -16,11 +17,64 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DiffMode determines how the two versions of each object are compared.
+type DiffMode string
+
+const (
+	// DiffModeLine generates unified diffs of the lines in the YAML for each object. This is
+	// the default.
+	DiffModeLine DiffMode = "line"
+
+	// DiffModeStructural compares the objects as trees and generates a list of the paths that
+	// were changed.
+	DiffModeStructural DiffMode = "structural"
+)
+
 // DiffConfig configures how Kubernetes diffs should be generated.
 type DiffConfig struct {
 	ContextLines  int
 	MaxLineLength int
 	MaxSize       int
+	Mode          DiffMode
+
+	// SensitiveKinds are the kinds, in addition to Secret, whose values are redacted.
+	SensitiveKinds []string
+
+	// SensitivePaths are the fields, in kind:dotted.path format, whose values are redacted.
+	SensitivePaths []string
+
+	// IgnoreFields are rules for fields that are stripped from both versions of each object
+	// before they're compared.
+	IgnoreFields []IgnoreRule
+}
+
+// Validate returns an error if the argument config has an unknown mode, an invalid sensitive
+// path, or an invalid ignore rule.
+func (c DiffConfig) Validate() error {
+	switch c.Mode {
+	case "", DiffModeLine, DiffModeStructural:
+	default:
+		return fmt.Errorf(
+			"Unknown diff mode %s; must be one of %s, %s",
+			c.Mode,
+			DiffModeLine,
+			DiffModeStructural,
+		)
+	}
+
+	for _, sensitivePath := range c.SensitivePaths {
+		if _, _, err := parseSensitivePath(sensitivePath); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range c.IgnoreFields {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // DiffKube processes the results of a kubectl diff call in place of the default 'diff'
@@ -30,6 +84,20 @@ func DiffKube(
 	newRoot string,
 	config DiffConfig,
 ) ([]Result, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	redactor, err := NewRedactor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	evalFunc := evalDiffs
+	if config.Mode == DiffModeStructural {
+		evalFunc = evalStructuralDiffs
+	}
+
 	oldNames, err := walkPaths(oldRoot)
 	if err != nil {
 		return nil, err
@@ -66,31 +134,34 @@ func DiffKube(
 		var diffResult *Result
 
 		if oldOk && newOk {
-			diffResult, err = evalDiffs(
+			diffResult, err = evalFunc(
 				name,
 				oldRoot,
 				name,
 				newRoot,
 				name,
 				config,
+				redactor,
 			)
 		} else if oldOk {
-			diffResult, err = evalDiffs(
+			diffResult, err = evalFunc(
 				name,
 				oldRoot,
 				name,
 				newRoot,
 				"",
 				config,
+				redactor,
 			)
 		} else {
-			diffResult, err = evalDiffs(
+			diffResult, err = evalFunc(
 				name,
 				oldRoot,
 				"",
 				newRoot,
 				name,
 				config,
+				redactor,
 			)
 		}
 
@@ -143,17 +214,16 @@ func evalDiffs(
 	newRoot string,
 	newName string,
 	config DiffConfig,
+	redactor *Redactor,
 ) (*Result, error) {
-	var oldLines []string
-	var newLines []string
-	var oldHash string
-	var newHash string
+	var oldContents []byte
+	var newContents []byte
 	var obj *apply.TypedKubeObj
 	var err error
 
 	if oldName != "" {
 		oldPath := filepath.Join(oldRoot, oldName)
-		oldLines, oldHash, err = getFileLines(oldPath, config.MaxLineLength)
+		oldContents, err = ioutil.ReadFile(oldPath)
 		if err != nil {
 			return nil, err
 		}
@@ -165,7 +235,7 @@ func evalDiffs(
 
 	if newName != "" {
 		newPath := filepath.Join(newRoot, newName)
-		newLines, newHash, err = getFileLines(newPath, config.MaxLineLength)
+		newContents, err = ioutil.ReadFile(newPath)
 		if err != nil {
 			return nil, err
 		}
@@ -181,6 +251,41 @@ func evalDiffs(
 		}
 	}
 
+	// Strip ignored fields and redact sensitive values before generating the lines so that
+	// they don't end up in the diff. Both sides are redacted together so that the placeholders
+	// for sensitive values that have changed are different from the ones that haven't.
+	oldContents, newContents, err = transformYAMLPair(
+		oldContents,
+		newContents,
+		func(oldObj map[string]interface{}, newObj map[string]interface{}) bool {
+			oldStripped := StripFields(oldObj, config.IgnoreFields)
+			newStripped := StripFields(newObj, config.IgnoreFields)
+			redacted := redactor.RedactChanges(oldObj, newObj)
+			return oldStripped || newStripped || redacted
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldLines []string
+	var newLines []string
+	var oldHash string
+	var newHash string
+
+	if oldName != "" {
+		oldLines, oldHash, err = getFileLines(oldContents, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if newName != "" {
+		newLines, newHash, err = getFileLines(newContents, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if oldHash == newHash {
 		return nil, nil
 	}
@@ -227,19 +332,13 @@ func evalDiffs(
 	}, nil
 }
 
-func getFileLines(path string, maxLineLength int) ([]string, string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, "", err
-	}
-	defer file.Close()
-
+func getFileLines(contents []byte, config DiffConfig) ([]string, string, error) {
 	lines := []string{}
 
 	// Hash the file contents so we can avoid diffing files with the same content.
 	h := sha1.New()
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, 1024*1024)
 
@@ -263,12 +362,12 @@ func getFileLines(path string, maxLineLength int) ([]string, string, error) {
 		}
 
 		if keep {
-			if len(line) > maxLineLength {
+			if len(line) > config.MaxLineLength {
 				// Trim very long lines
 				line = fmt.Sprintf(
 					"%s... (%d chars omitted)",
-					line[0:maxLineLength],
-					len(line)-maxLineLength,
+					line[0:config.MaxLineLength],
+					len(line)-config.MaxLineLength,
 				)
 			}
 
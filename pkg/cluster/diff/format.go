@@ -2,7 +2,9 @@ package diff
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/olekukonko/tablewriter"
 )
@@ -39,17 +41,7 @@ func ResultsTable(results []Result) string {
 	)
 
 	for _, result := range results {
-		var kind string
-		var name string
-		var namespace string
-
-		if result.Object != nil {
-			kind = result.Object.Kind
-			name = result.Object.KubeMetadata.Name
-			namespace = result.Object.KubeMetadata.Namespace
-		} else {
-			name = result.Name
-		}
+		namespace, kind, name := result.objectFields()
 
 		table.Append(
 			[]string{
@@ -64,3 +56,138 @@ func ResultsTable(results []Result) string {
 	table.Render()
 	return string(bytes.TrimRight(buf.Bytes(), "\n"))
 }
+
+// ResultsMarkdown returns a Markdown summary of a slice of result diffs, suitable for posting
+// in Github comments. It includes a table of the changed resources followed by a collapsible
+// section with the diff of each one, clipped to the argument length.
+func ResultsMarkdown(results []Result, maxDiffLength int) string {
+	if len(results) == 0 {
+		return "No diffs found\n"
+	}
+
+	lines := []string{
+		"| Namespace | Kind | Name | Operation | Changed Lines |",
+		"| --------- | ---- | ---- | --------- | ------------- |",
+	}
+
+	for _, result := range results {
+		namespace, kind, name := result.objectFields()
+		lines = append(
+			lines,
+			fmt.Sprintf(
+				"| %s | %s | %s | %s | %d |",
+				namespace,
+				kind,
+				name,
+				result.Operation,
+				result.NumChangedLines(),
+			),
+		)
+	}
+
+	for _, result := range results {
+		lines = append(
+			lines,
+			"",
+			"<details>",
+			fmt.Sprintf(
+				"<summary>%s (+%d/-%d)</summary>",
+				result.label(),
+				result.NumAdded,
+				result.NumRemoved,
+			),
+			"",
+			"```diff",
+			strings.TrimRight(result.ClippedRawDiff(maxDiffLength), "\n"),
+			"```",
+			"",
+			"</details>",
+		)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ResultsPatch returns the raw diffs of a slice of result diffs concatenated together. For
+// line diffs, this is a unified patch that covers all of the changed resources.
+func ResultsPatch(results []Result) string {
+	buf := &bytes.Buffer{}
+
+	for _, result := range results {
+		buf.WriteString(result.RawDiff)
+		if !strings.HasSuffix(result.RawDiff, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+
+	return buf.String()
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",cdata"`
+}
+
+// ResultsJUnit returns a JUnit XML report for a slice of result diffs, with a failed test case
+// for each changed resource. This allows the diffs to be shown in the test views of CI
+// systems.
+func ResultsJUnit(results []Result, suiteName string) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      suiteName,
+		Tests:     len(results),
+		Failures:  len(results),
+		TestCases: []junitTestCase{},
+	}
+
+	for _, result := range results {
+		_, kind, _ := result.objectFields()
+
+		suite.TestCases = append(
+			suite.TestCases,
+			junitTestCase{
+				Name:      result.label(),
+				ClassName: kind,
+				Failure: &junitFailure{
+					Message: fmt.Sprintf(
+						"Pending %s (%d lines added, %d lines removed)",
+						result.Operation,
+						result.NumAdded,
+						result.NumRemoved,
+					),
+					Type:     string(result.Operation),
+					Contents: result.RawDiff,
+				},
+			},
+		)
+	}
+
+	xmlBytes, err := xml.MarshalIndent(
+		junitTestSuites{Suites: []junitTestSuite{suite}},
+		"",
+		"  ",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), xmlBytes...), nil
+}
@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResults(t *testing.T) []Result {
+	results, err := DiffKube(
+		"testdata/old",
+		"testdata/new",
+		DiffConfig{
+			ContextLines:  2,
+			MaxLineLength: 100,
+			MaxSize:       3000,
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(results))
+	return results
+}
+
+func TestResultsMarkdown(t *testing.T) {
+	results := testResults(t)
+
+	markdown := ResultsMarkdown(results, 50)
+	lines := strings.Split(markdown, "\n")
+	assert.Equal(t, "| Namespace | Kind | Name | Operation | Changed Lines |", lines[0])
+	assert.Equal(t, "| apps | Deployment | echoserver | update | 2 |", lines[2])
+	assert.Contains(t, markdown, "<summary>Deployment apps/echoserver (+2/-2)</summary>")
+	assert.Contains(t, markdown, "```diff\n--- Server:file1.yaml\n")
+	assert.Contains(t, markdown, "chars omitted)\n```\n\n</details>")
+	assert.Equal(t, 3, strings.Count(markdown, "<details>"))
+
+	assert.Equal(t, "No diffs found\n", ResultsMarkdown(nil, 50))
+}
+
+func TestResultsPatch(t *testing.T) {
+	results := testResults(t)
+
+	patch := ResultsPatch(results)
+	for _, result := range results {
+		assert.Contains(t, patch, result.RawDiff)
+	}
+	assert.True(t, strings.HasPrefix(patch, "--- Server:file1.yaml\n+++ Local:file1.yaml\n"))
+	assert.Contains(t, patch, "\n--- Server:file2.yaml\n")
+	assert.Contains(t, patch, "\n+++ Local:file3.yaml\n")
+	assert.Equal(t, "", ResultsPatch(nil))
+}
+
+func TestResultsJUnit(t *testing.T) {
+	results := testResults(t)
+
+	xmlBytes, err := ResultsJUnit(results, "kadiff")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(xmlBytes), xml.Header))
+
+	suites := junitTestSuites{}
+	require.NoError(t, xml.Unmarshal(xmlBytes, &suites))
+	require.Equal(t, 1, len(suites.Suites))
+
+	suite := suites.Suites[0]
+	assert.Equal(t, "kadiff", suite.Name)
+	assert.Equal(t, 3, suite.Tests)
+	assert.Equal(t, 3, suite.Failures)
+	require.Equal(t, 3, len(suite.TestCases))
+
+	assert.Equal(t, "Deployment apps/echoserver", suite.TestCases[0].Name)
+	assert.Equal(t, "Deployment", suite.TestCases[0].ClassName)
+	require.NotNil(t, suite.TestCases[0].Failure)
+	assert.Equal(t, "update", suite.TestCases[0].Failure.Type)
+	assert.Equal(
+		t,
+		"Pending update (2 lines added, 2 lines removed)",
+		suite.TestCases[0].Failure.Message,
+	)
+	assert.Equal(t, results[0].RawDiff, suite.TestCases[0].Failure.Contents)
+
+	xmlBytes, err = ResultsJUnit(nil, "kadiff")
+	require.NoError(t, err)
+	assert.Contains(t, string(xmlBytes), `<testsuite name="kadiff" tests="0" failures="0"></testsuite>`)
+}
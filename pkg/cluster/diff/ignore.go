@@ -0,0 +1,244 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IgnoreRule is a set of fields that are stripped from both versions of the matching objects
+// before they're compared. This is used for fields that are mutated in the cluster by
+// controllers or webhooks, which would otherwise show up as perpetual diffs.
+type IgnoreRule struct {
+	// Kind is the kind of the objects that this rule applies to; if empty, the rule applies
+	// to all kinds.
+	Kind string `json:"kind,omitempty"`
+
+	// Name is the name of the objects that this rule applies to; if empty, the rule applies
+	// to all names.
+	Name string `json:"name,omitempty"`
+
+	// Paths are the fields to strip. These use the same format as the paths in structural
+	// diffs, e.g. spec.template.spec.containers[name=app].image, plus [*] to match all of the
+	// elements in a list.
+	Paths []string `json:"paths"`
+}
+
+// ParseIgnoreRules parses the JSON-encoded list of ignore rules that's passed to kadiff.
+func ParseIgnoreRules(value string) ([]IgnoreRule, error) {
+	rules := []IgnoreRule{}
+	if strings.TrimSpace(value) == "" {
+		return rules, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("Could not parse ignore rules: %+v", err)
+	}
+	return rules, nil
+}
+
+// Validate returns an error if any of the paths in the argument rule can't be parsed.
+func (r IgnoreRule) Validate() error {
+	if len(r.Paths) == 0 {
+		return fmt.Errorf("Ignore rule for kind %q, name %q has no paths", r.Kind, r.Name)
+	}
+
+	for _, path := range r.Paths {
+		if _, err := parseFieldPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns whether this rule applies to the argument object.
+func (r IgnoreRule) matches(obj map[string]interface{}) bool {
+	kind, _ := obj["kind"].(string)
+	if r.Kind != "" && r.Kind != kind {
+		return false
+	}
+
+	if r.Name != "" {
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		if r.Name != name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stepType is the type of a single step in a field path.
+type stepType int
+
+const (
+	stepTypeKey stepType = iota
+	stepTypeIndex
+	stepTypeAll
+	stepTypeSelector
+)
+
+// pathStep is a single step in a field path.
+type pathStep struct {
+	stepType stepType
+
+	// key is the map key for key steps and the field name for selector steps
+	key   string
+	value string
+	index int
+}
+
+// parseFieldPath parses a path like spec.containers[name=app].ports[0] or
+// metadata.annotations["example.com/key"] into its steps.
+func parseFieldPath(path string) ([]pathStep, error) {
+	steps := []pathStep{}
+	remaining := path
+
+	for remaining != "" {
+		if remaining[0] == '[' {
+			end := strings.Index(remaining, "]")
+			if strings.HasPrefix(remaining, "[\"") {
+				// Quoted keys can contain brackets, so look for the closing quote first
+				quoteEnd := strings.Index(remaining[2:], "\"]")
+				if quoteEnd >= 0 {
+					end = quoteEnd + 3
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated bracket in field path %s", path)
+			}
+
+			step, err := parseBracketStep(remaining[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid field path %s: %+v", path, err)
+			}
+			steps = append(steps, step)
+			remaining = strings.TrimPrefix(remaining[end+1:], ".")
+			continue
+		}
+
+		end := strings.IndexAny(remaining, ".[")
+		if end < 0 {
+			end = len(remaining)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("Empty key in field path %s", path)
+		}
+
+		steps = append(steps, pathStep{stepType: stepTypeKey, key: remaining[0:end]})
+		remaining = strings.TrimPrefix(remaining[end:], ".")
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("Field path is empty")
+	}
+	return steps, nil
+}
+
+func parseBracketStep(contents string) (pathStep, error) {
+	switch {
+	case contents == "*":
+		return pathStep{stepType: stepTypeAll}, nil
+	case strings.HasPrefix(contents, "\""):
+		key, err := strconv.Unquote(contents)
+		if err != nil {
+			return pathStep{}, err
+		}
+		return pathStep{stepType: stepTypeKey, key: key}, nil
+	case strings.Contains(contents, "="):
+		subs := strings.SplitN(contents, "=", 2)
+		return pathStep{stepType: stepTypeSelector, key: subs[0], value: subs[1]}, nil
+	default:
+		index, err := strconv.Atoi(contents)
+		if err != nil {
+			return pathStep{}, fmt.Errorf("Unrecognized list selector [%s]", contents)
+		}
+		return pathStep{stepType: stepTypeIndex, index: index}, nil
+	}
+}
+
+// StripFields removes the fields in the argument rules from the argument object in place. It
+// returns whether any of the rules applied to the object.
+func StripFields(obj map[string]interface{}, rules []IgnoreRule) bool {
+	if obj == nil {
+		return false
+	}
+
+	matched := false
+
+	for _, rule := range rules {
+		if !rule.matches(obj) {
+			continue
+		}
+		matched = true
+
+		for _, path := range rule.Paths {
+			steps, err := parseFieldPath(path)
+			if err != nil {
+				// Rules are validated up-front, so this shouldn't happen
+				continue
+			}
+			stripPath(obj, steps)
+		}
+	}
+
+	return matched
+}
+
+// stripPath removes the value at the argument path from the argument value. It returns the
+// updated value, since removing list elements requires replacing the list in its parent.
+func stripPath(value interface{}, steps []pathStep) interface{} {
+	step := steps[0]
+	last := len(steps) == 1
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if step.stepType != stepTypeKey {
+			return value
+		}
+
+		child, ok := typedValue[step.key]
+		if !ok {
+			return value
+		}
+		if last {
+			delete(typedValue, step.key)
+		} else {
+			typedValue[step.key] = stripPath(child, steps[1:])
+		}
+		return typedValue
+	case []interface{}:
+		updated := []interface{}{}
+
+		for e, element := range typedValue {
+			if !stepMatchesElement(step, e, element) {
+				updated = append(updated, element)
+			} else if !last {
+				updated = append(updated, stripPath(element, steps[1:]))
+			}
+		}
+		return updated
+	default:
+		return value
+	}
+}
+
+func stepMatchesElement(step pathStep, index int, element interface{}) bool {
+	switch step.stepType {
+	case stepTypeAll:
+		return true
+	case stepTypeIndex:
+		return step.index == index
+	case stepTypeSelector:
+		elementMap, ok := element.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value, ok := elementMap[step.key]
+		return ok && fmt.Sprintf("%v", value) == step.value
+	default:
+		return false
+	}
+}
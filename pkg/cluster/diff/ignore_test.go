@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKubeIgnoreFields(t *testing.T) {
+	for _, mode := range []DiffMode{DiffModeLine, DiffModeStructural} {
+		results, err := DiffKube(
+			"testdata/old",
+			"testdata/new",
+			DiffConfig{
+				ContextLines:  3,
+				MaxLineLength: 256,
+				MaxSize:       3000,
+				Mode:          mode,
+				IgnoreFields: []IgnoreRule{
+					{
+						Kind:  "Deployment",
+						Name:  "echoserver",
+						Paths: []string{"spec.replicas"},
+					},
+					{
+						Paths: []string{"spec.template.metadata.labels.version"},
+					},
+				},
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(results), "mode %s", mode)
+		assert.Equal(t, "file2.yaml", results[0].Name, "mode %s", mode)
+		assert.Equal(t, "file3.yaml", results[1].Name, "mode %s", mode)
+	}
+
+	_, err := DiffKube(
+		"testdata/old",
+		"testdata/new",
+		DiffConfig{
+			IgnoreFields: []IgnoreRule{
+				{
+					Paths: []string{"spec.containers[name=app"},
+				},
+			},
+		},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unterminated bracket")
+}
+
+func TestStripFields(t *testing.T) {
+	type testCase struct {
+		description string
+		rules       []IgnoreRule
+		expected    string
+		matched     bool
+	}
+
+	objYAML := `
+kind: Deployment
+metadata:
+  name: test
+  annotations:
+    example.com/key: value
+    other: value
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1
+      - name: istio-proxy
+        image: proxy:1
+status:
+  readyReplicas: 3
+`
+
+	testCases := []testCase{
+		{
+			description: "other kind",
+			rules: []IgnoreRule{
+				{Kind: "Service", Paths: []string{"spec"}},
+			},
+			expected: objYAML,
+			matched:  false,
+		},
+		{
+			description: "other name",
+			rules: []IgnoreRule{
+				{Kind: "Deployment", Name: "other", Paths: []string{"spec"}},
+			},
+			expected: objYAML,
+			matched:  false,
+		},
+		{
+			description: "keys and selectors",
+			rules: []IgnoreRule{
+				{
+					Kind: "Deployment",
+					Paths: []string{
+						"status",
+						"spec.replicas",
+						`metadata.annotations["example.com/key"]`,
+						"spec.template.spec.containers[name=istio-proxy]",
+						"spec.missing.field",
+					},
+				},
+			},
+			expected: `
+kind: Deployment
+metadata:
+  name: test
+  annotations:
+    other: value
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1
+`,
+			matched: true,
+		},
+		{
+			description: "wildcards and indices",
+			rules: []IgnoreRule{
+				{Paths: []string{"spec.template.spec.containers[*].image"}},
+				{Paths: []string{"spec.template.spec.containers[1]"}},
+			},
+			expected: `
+kind: Deployment
+metadata:
+  name: test
+  annotations:
+    example.com/key: value
+    other: value
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+status:
+  readyReplicas: 3
+`,
+			matched: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		obj := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(objYAML), &obj))
+		expected := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(testCase.expected), &expected))
+
+		matched := StripFields(obj, testCase.rules)
+		assert.Equal(t, testCase.matched, matched, testCase.description)
+		assert.Equal(t, expected, obj, testCase.description)
+	}
+}
+
+func TestParseIgnoreRules(t *testing.T) {
+	rules, err := ParseIgnoreRules(
+		`[{"kind": "Deployment", "paths": ["spec.replicas"]}, {"paths": ["status"]}]`,
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]IgnoreRule{
+			{Kind: "Deployment", Paths: []string{"spec.replicas"}},
+			{Paths: []string{"status"}},
+		},
+		rules,
+	)
+
+	rules, err = ParseIgnoreRules("")
+	require.NoError(t, err)
+	assert.Equal(t, []IgnoreRule{}, rules)
+
+	_, err = ParseIgnoreRules("not json")
+	require.Error(t, err)
+
+	for _, path := range []string{"", "spec..replicas", "spec.containers[x]", `a["b`} {
+		err := IgnoreRule{Paths: []string{path}}.Validate()
+		assert.Error(t, err, path)
+	}
+	assert.Error(t, IgnoreRule{Kind: "Deployment"}.Validate())
+}
@@ -0,0 +1,324 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Kinds whose values are always redacted, regardless of the diff config.
+var defaultSensitiveKinds = []string{"Secret"}
+
+// Top-level fields that are kept in sensitive kinds so that the objects can still be identified.
+var unredactedFields = map[string]struct{}{
+	"apiVersion": {},
+	"kind":       {},
+	"metadata":   {},
+}
+
+// Annotations that hold copies of the objects that they're set on, e.g. from client-side applies.
+// These are redacted in objects with sensitive values since they'd otherwise leak the values.
+var objectCopyAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"kapp.k14s.io/original",
+}
+
+var documentSeparator = regexp.MustCompile("(?:^|\\s*\n)---\\s*")
+
+const (
+	// Placeholder for sensitive values that are the same in both versions of an object, or
+	// that aren't being compared
+	sensitivePlaceholder = "(sensitive value)"
+
+	// Placeholder for sensitive values that differ from the ones in the old version of an
+	// object
+	changedSensitivePlaceholder = "(sensitive value changed)"
+)
+
+// Redactor replaces the values of sensitive fields in Kubernetes objects with placeholders.
+// When two versions of an object are diffed, the placeholders in the new version show which
+// values have changed without exposing the values themselves.
+type Redactor struct {
+	kinds map[string]struct{}
+	paths map[string][][]string
+}
+
+// NewRedactor returns a Redactor for the sensitive kinds and paths in the argument config.
+// Secrets are always treated as sensitive.
+func NewRedactor(config DiffConfig) (*Redactor, error) {
+	redactor := &Redactor{
+		kinds: map[string]struct{}{},
+		paths: map[string][][]string{},
+	}
+
+	for _, kind := range append(defaultSensitiveKinds, config.SensitiveKinds...) {
+		redactor.kinds[kind] = struct{}{}
+	}
+
+	for _, sensitivePath := range config.SensitivePaths {
+		kind, path, err := parseSensitivePath(sensitivePath)
+		if err != nil {
+			return nil, err
+		}
+		redactor.paths[kind] = append(redactor.paths[kind], path)
+	}
+
+	return redactor, nil
+}
+
+// parseSensitivePath parses a sensitive path in kind:dotted.path format.
+func parseSensitivePath(sensitivePath string) (string, []string, error) {
+	subs := strings.SplitN(sensitivePath, ":", 2)
+	if len(subs) != 2 || subs[0] == "" || subs[1] == "" {
+		return "", nil, fmt.Errorf(
+			"Sensitive path %s is not in kind:path format",
+			sensitivePath,
+		)
+	}
+
+	return subs[0], strings.Split(subs[1], "."), nil
+}
+
+// RedactObject replaces the sensitive values in the argument object in place. It returns
+// whether anything was redacted.
+func (r *Redactor) RedactObject(obj map[string]interface{}) bool {
+	return r.redactObject(obj, nil, false)
+}
+
+// RedactChanges replaces the sensitive values in the argument versions of an object in
+// place. Values in the new version that differ from the ones in the old version get a
+// different placeholder so that the changes show up in diffs. Either version can be nil. It
+// returns whether anything was redacted.
+func (r *Redactor) RedactChanges(oldObj map[string]interface{}, newObj map[string]interface{}) bool {
+	// The new version is compared against the original values, so it's redacted first
+	newRedacted := r.redactObject(newObj, oldObj, oldObj != nil)
+	oldRedacted := r.redactObject(oldObj, nil, false)
+	return newRedacted || oldRedacted
+}
+
+// redactObject replaces the sensitive values in the argument object. If compare is set, then
+// values that differ from the ones in the baseline object are marked as changed.
+func (r *Redactor) redactObject(
+	obj map[string]interface{},
+	baseline map[string]interface{},
+	compare bool,
+) bool {
+	if obj == nil {
+		return false
+	}
+
+	kind, _ := obj["kind"].(string)
+	redacted := false
+
+	if _, ok := r.kinds[kind]; ok {
+		for key, value := range obj {
+			if _, ok := unredactedFields[key]; !ok {
+				obj[key] = redactValue(value, baseline[key], compare)
+				redacted = true
+			}
+		}
+	}
+
+	for _, path := range r.paths[kind] {
+		if redactPath(obj, baseline, path, compare) {
+			redacted = true
+		}
+	}
+
+	if _, ok := r.kinds[kind]; ok || len(r.paths[kind]) > 0 {
+		if redactCopyAnnotations(obj, baseline, compare) {
+			redacted = true
+		}
+	}
+
+	return redacted
+}
+
+// RedactYAML replaces the sensitive values in each of the documents in the argument YAML.
+// Documents that don't have sensitive values, or can't be parsed, are returned as-is.
+func (r *Redactor) RedactYAML(contents []byte) ([]byte, error) {
+	return transformYAML(contents, r.RedactObject)
+}
+
+// transformYAML runs the argument function on each of the documents in the argument YAML.
+// Documents for which the function returns true are re-marshalled; others, along with ones
+// that can't be parsed, are returned as-is.
+func transformYAML(
+	contents []byte,
+	transform func(obj map[string]interface{}) bool,
+) ([]byte, error) {
+	transformed, _, err := transformYAMLPair(
+		contents,
+		nil,
+		func(obj map[string]interface{}, _ map[string]interface{}) bool {
+			return transform(obj)
+		},
+	)
+	return transformed, err
+}
+
+// transformYAMLPair runs the argument function on the documents at the same positions in the
+// argument old and new YAML; documents that are missing or can't be parsed are passed as nil.
+// Both documents in each pair for which the function returns true are re-marshalled so that
+// their formatting matches.
+func transformYAMLPair(
+	oldContents []byte,
+	newContents []byte,
+	transform func(oldObj map[string]interface{}, newObj map[string]interface{}) bool,
+) ([]byte, []byte, error) {
+	oldDocs, oldObjs := parseYAMLDocs(oldContents)
+	newDocs, newObjs := parseYAMLDocs(newContents)
+	oldTransformed := false
+	newTransformed := false
+
+	for d := 0; d < len(oldDocs) || d < len(newDocs); d++ {
+		var oldObj map[string]interface{}
+		var newObj map[string]interface{}
+		if d < len(oldObjs) {
+			oldObj = oldObjs[d]
+		}
+		if d < len(newObjs) {
+			newObj = newObjs[d]
+		}
+		if oldObj == nil && newObj == nil {
+			continue
+		}
+		if !transform(oldObj, newObj) {
+			continue
+		}
+
+		if oldObj != nil {
+			docBytes, err := yaml.Marshal(oldObj)
+			if err != nil {
+				return nil, nil, err
+			}
+			oldDocs[d] = string(docBytes)
+			oldTransformed = true
+		}
+		if newObj != nil {
+			docBytes, err := yaml.Marshal(newObj)
+			if err != nil {
+				return nil, nil, err
+			}
+			newDocs[d] = string(docBytes)
+			newTransformed = true
+		}
+	}
+
+	if oldTransformed {
+		oldContents = []byte(strings.Join(oldDocs, "\n---\n"))
+	}
+	if newTransformed {
+		newContents = []byte(strings.Join(newDocs, "\n---\n"))
+	}
+
+	return oldContents, newContents, nil
+}
+
+// parseYAMLDocs splits the argument YAML into documents and parses each one. The objects for
+// documents that are empty or can't be parsed are nil.
+func parseYAMLDocs(contents []byte) ([]string, []map[string]interface{}) {
+	if contents == nil {
+		return nil, nil
+	}
+
+	docs := documentSeparator.Split(string(contents), -1)
+	objs := make([]map[string]interface{}, len(docs))
+
+	for d, doc := range docs {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			continue
+		}
+		objs[d] = obj
+	}
+
+	return docs, objs
+}
+
+func redactPath(
+	obj map[string]interface{},
+	baseline map[string]interface{},
+	path []string,
+	compare bool,
+) bool {
+	for _, key := range path[:len(path)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		obj = child
+		baseline, _ = baseline[key].(map[string]interface{})
+	}
+
+	key := path[len(path)-1]
+	value, ok := obj[key]
+	if !ok {
+		return false
+	}
+	obj[key] = redactValue(value, baseline[key], compare)
+	return true
+}
+
+// redactCopyAnnotations replaces the values of the annotations in the argument object that
+// hold copies of it. It returns whether any were found.
+func redactCopyAnnotations(
+	obj map[string]interface{},
+	baseline map[string]interface{},
+	compare bool,
+) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	baselineMetadata, _ := baseline["metadata"].(map[string]interface{})
+	baselineAnnotations, _ := baselineMetadata["annotations"].(map[string]interface{})
+	redacted := false
+
+	for _, key := range objectCopyAnnotations {
+		if value, ok := annotations[key]; ok {
+			annotations[key] = redactValue(value, baselineAnnotations[key], compare)
+			redacted = true
+		}
+	}
+
+	return redacted
+}
+
+// redactValue replaces each of the leaf values in the argument value with a placeholder. If
+// compare is set, then leaves that differ from the ones at the same positions in the
+// baseline value are marked as changed.
+func redactValue(value interface{}, baseline interface{}, compare bool) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		baselineMap, _ := baseline.(map[string]interface{})
+		redactedMap := map[string]interface{}{}
+		for key, element := range typedValue {
+			redactedMap[key] = redactValue(element, baselineMap[key], compare)
+		}
+		return redactedMap
+	case []interface{}:
+		baselineList, _ := baseline.([]interface{})
+		redactedList := []interface{}{}
+		for e, element := range typedValue {
+			var baselineElement interface{}
+			if e < len(baselineList) {
+				baselineElement = baselineList[e]
+			}
+			redactedList = append(redactedList, redactValue(element, baselineElement, compare))
+		}
+		return redactedList
+	case nil:
+		return nil
+	default:
+		if compare && !reflect.DeepEqual(value, baseline) {
+			return changedSensitivePlaceholder
+		}
+		return sensitivePlaceholder
+	}
+}
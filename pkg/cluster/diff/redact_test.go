@@ -0,0 +1,246 @@
+package diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oldSecret = `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test-secret
+  namespace: test-namespace
+data:
+  password: aHVudGVyMg==
+  username: YWRtaW4=
+`
+
+const newSecret = `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test-secret
+  namespace: test-namespace
+data:
+  password: c3dvcmRmaXNo
+  username: YWRtaW4=
+`
+
+func TestDiffKubeRedacted(t *testing.T) {
+	oldRoot, err := ioutil.TempDir("", "redact_old")
+	require.NoError(t, err)
+	defer os.RemoveAll(oldRoot)
+
+	newRoot, err := ioutil.TempDir("", "redact_new")
+	require.NoError(t, err)
+	defer os.RemoveAll(newRoot)
+
+	require.NoError(
+		t,
+		ioutil.WriteFile(filepath.Join(oldRoot, "secret.yaml"), []byte(oldSecret), 0644),
+	)
+	require.NoError(
+		t,
+		ioutil.WriteFile(filepath.Join(newRoot, "secret.yaml"), []byte(newSecret), 0644),
+	)
+
+	for _, mode := range []DiffMode{DiffModeLine, DiffModeStructural} {
+		results, err := DiffKube(
+			oldRoot,
+			newRoot,
+			DiffConfig{
+				ContextLines:  3,
+				MaxLineLength: 256,
+				MaxSize:       3000,
+				Mode:          mode,
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results), "mode %s", mode)
+
+		assert.Equal(t, "Secret", results[0].Object.Kind)
+		assert.Equal(t, OperationUpdate, results[0].Operation)
+		assert.Equal(t, 1, results[0].NumAdded, "mode %s", mode)
+		assert.Equal(t, 1, results[0].NumRemoved, "mode %s", mode)
+		assert.Contains(t, results[0].RawDiff, "password", "mode %s", mode)
+		assert.Contains(t, results[0].RawDiff, "(sensitive value changed)", "mode %s", mode)
+		assert.NotContains(t, results[0].RawDiff, "sha256", "mode %s", mode)
+		assert.NotContains(t, results[0].RawDiff, "aHVudGVyMg==", "mode %s", mode)
+		assert.NotContains(t, results[0].RawDiff, "c3dvcmRmaXNo", "mode %s", mode)
+	}
+}
+
+func TestRedactYAML(t *testing.T) {
+	redactor, err := NewRedactor(
+		DiffConfig{
+			SensitiveKinds: []string{"SealedSecret"},
+			SensitivePaths: []string{"ConfigMap:data.password", "Deployment:spec.template.spec"},
+		},
+	)
+	require.NoError(t, err)
+
+	contents := []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+data:
+  password: hunter2
+  username: admin
+---
+apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: test-sealed
+spec:
+  encryptedData:
+    key: abcd
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: test-service
+spec:
+  type: ClusterIP
+`)
+
+	redacted, err := redactor.RedactYAML(contents)
+	require.NoError(t, err)
+	assert.NotContains(t, string(redacted), "hunter2")
+	assert.NotContains(t, string(redacted), "abcd")
+	assert.Contains(t, string(redacted), "username: admin")
+	assert.Contains(t, string(redacted), "name: test-sealed")
+	assert.Contains(t, string(redacted), "type: ClusterIP")
+
+	// Placeholders are stable so that unchanged values don't show up in diffs
+	redacted2, err := redactor.RedactYAML(contents)
+	require.NoError(t, err)
+	assert.Equal(t, string(redacted), string(redacted2))
+
+	// Objects without sensitive values are returned as-is
+	unchanged := []byte("kind: Service\nmetadata:\n  name: test-service\n")
+	redacted, err = redactor.RedactYAML(unchanged)
+	require.NoError(t, err)
+	assert.Equal(t, unchanged, redacted)
+
+	obj := map[string]interface{}{}
+	require.NoError(
+		t,
+		yaml.Unmarshal(
+			[]byte("kind: Deployment\nspec:\n  replicas: 2\n  template:\n    spec:\n      containers: [{name: app}]\n"),
+			&obj,
+		),
+	)
+	assert.True(t, redactor.RedactObject(obj))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"replicas": float64(2),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "(sensitive value)",
+						},
+					},
+				},
+			},
+		},
+		obj["spec"],
+	)
+
+	// Annotations that copy the object are redacted along with the sensitive values
+	annotatedContents := []byte(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test-secret
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","data":{"password":"aHVudGVyMg=="},"kind":"Secret"}
+    team: platform
+data:
+  password: aHVudGVyMg==
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","data":{"password":"hunter2"},"kind":"ConfigMap"}
+data:
+  password: hunter2
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: test-service
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","kind":"Service"}
+`)
+	redacted, err = redactor.RedactYAML(annotatedContents)
+	require.NoError(t, err)
+	assert.NotContains(t, string(redacted), "aHVudGVyMg==")
+	assert.NotContains(t, string(redacted), "hunter2")
+	assert.Contains(
+		t,
+		string(redacted),
+		"kubectl.kubernetes.io/last-applied-configuration: (sensitive value)",
+	)
+	assert.Contains(t, string(redacted), "team: platform")
+	assert.Contains(t, string(redacted), `{"apiVersion":"v1","kind":"Service"}`)
+
+	_, err = NewRedactor(DiffConfig{SensitivePaths: []string{"data.password"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in kind:path format")
+}
+
+func TestRedactChanges(t *testing.T) {
+	redactor, err := NewRedactor(DiffConfig{})
+	require.NoError(t, err)
+
+	oldObj := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(oldSecret), &oldObj))
+	newObj := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(newSecret), &newObj))
+
+	assert.True(t, redactor.RedactChanges(oldObj, newObj))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"password": "(sensitive value)",
+			"username": "(sensitive value)",
+		},
+		oldObj["data"],
+	)
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"password": "(sensitive value changed)",
+			"username": "(sensitive value)",
+		},
+		newObj["data"],
+	)
+
+	// Without an old version, nothing is marked as changed
+	newObj = map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(newSecret), &newObj))
+	assert.True(t, redactor.RedactChanges(nil, newObj))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"password": "(sensitive value)",
+			"username": "(sensitive value)",
+		},
+		newObj["data"],
+	)
+}
@@ -31,6 +31,9 @@ type Result struct {
 	NumAdded   int                 `json:"numAdded"`
 	NumRemoved int                 `json:"numRemoved"`
 	Operation  Operation           `json:"operation"`
+
+	// Changes are the field-level changes in the object; only set for structural diffs.
+	Changes []Change `json:"changes,omitempty"`
 }
 
 // PrintFull prints out a table and the raw diffs for a results slice.
@@ -100,3 +103,24 @@ func (r *Result) NumChangedLines() int {
 	}
 	return r.NumRemoved
 }
+
+// objectFields returns the namespace, kind, and name of the object for this result. If the
+// object couldn't be parsed, then the name of the diffed file is used instead.
+func (r *Result) objectFields() (string, string, string) {
+	if r.Object == nil {
+		return "", "", r.Name
+	}
+	return r.Object.KubeMetadata.Namespace, r.Object.Kind, r.Object.KubeMetadata.Name
+}
+
+// label returns a short, human-readable description of the object for this result.
+func (r *Result) label() string {
+	namespace, kind, name := r.objectFields()
+	if namespace != "" {
+		name = fmt.Sprintf("%s/%s", namespace, name)
+	}
+	if kind != "" {
+		return fmt.Sprintf("%s %s", kind, name)
+	}
+	return name
+}
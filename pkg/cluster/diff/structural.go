@@ -0,0 +1,428 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChangeType describes whether a field was added, removed, or changed.
+type ChangeType string
+
+const (
+	ChangeTypeAdd    ChangeType = "add"
+	ChangeTypeRemove ChangeType = "remove"
+	ChangeTypeChange ChangeType = "change"
+)
+
+// Keys that are tried, in order, for matching up the elements of lists of objects. The first
+// one that has a unique, scalar value in every element of both versions of the list is used.
+// These cover the merge keys of most of the lists in the core Kubernetes types.
+var mergeKeyCandidates = []string{
+	"name",
+	"containerPort",
+	"port",
+	"mountPath",
+	"devicePath",
+	"ip",
+	"topologyKey",
+	"type",
+}
+
+var plainKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Change is a single field-level difference between two versions of an object.
+type Change struct {
+	Path     string      `json:"path"`
+	Type     ChangeType  `json:"type"`
+	OldValue interface{} `json:"oldValue,omitempty"`
+	NewValue interface{} `json:"newValue,omitempty"`
+}
+
+// String returns a one-line summary of this change.
+func (c Change) String() string {
+	switch c.Type {
+	case ChangeTypeAdd:
+		return fmt.Sprintf("+ %s: %s", c.Path, formatValue(c.NewValue))
+	case ChangeTypeRemove:
+		return fmt.Sprintf("- %s: %s", c.Path, formatValue(c.OldValue))
+	default:
+		return fmt.Sprintf(
+			"~ %s: %s -> %s",
+			c.Path,
+			formatValue(c.OldValue),
+			formatValue(c.NewValue),
+		)
+	}
+}
+
+// evalStructuralDiffs is the structural equivalent of evalDiffs. Instead of diffing the
+// lines of the files, it parses both versions of the object and compares them field by
+// field. Files that can't be parsed are diffed line by line instead.
+func evalStructuralDiffs(
+	name string,
+	oldRoot string,
+	oldName string,
+	newRoot string,
+	newName string,
+	config DiffConfig,
+	redactor *Redactor,
+) (*Result, error) {
+	var oldContents map[string]interface{}
+	var newContents map[string]interface{}
+	var err error
+
+	if oldName != "" {
+		oldContents, err = getFileContents(filepath.Join(oldRoot, oldName))
+		if err != nil {
+			log.Warnf("Falling back to line diff for %s: %+v", name, err)
+			return evalDiffs(name, oldRoot, oldName, newRoot, newName, config, redactor)
+		}
+	}
+	if newName != "" {
+		newContents, err = getFileContents(filepath.Join(newRoot, newName))
+		if err != nil {
+			log.Warnf("Falling back to line diff for %s: %+v", name, err)
+			return evalDiffs(name, oldRoot, oldName, newRoot, newName, config, redactor)
+		}
+	}
+
+	StripFields(oldContents, config.IgnoreFields)
+	StripFields(newContents, config.IgnoreFields)
+	redactor.RedactChanges(oldContents, newContents)
+
+	changes := DiffObjects(oldContents, newContents)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var operation Operation
+	if oldContents == nil {
+		operation = OperationCreate
+	} else if newContents == nil {
+		operation = OperationDelete
+	} else {
+		operation = OperationUpdate
+	}
+
+	numAdded := 0
+	numRemoved := 0
+	lines := []string{}
+
+	for _, change := range changes {
+		if change.Type != ChangeTypeRemove {
+			numAdded++
+		}
+		if change.Type != ChangeTypeAdd {
+			numRemoved++
+		}
+
+		line := change.String()
+		if len(line) > config.MaxLineLength {
+			line = fmt.Sprintf(
+				"%s... (%d chars omitted)",
+				line[0:config.MaxLineLength],
+				len(line)-config.MaxLineLength,
+			)
+		}
+		lines = append(lines, line)
+	}
+
+	diffStr := strings.Join(lines, "\n")
+	if len(diffStr) > config.MaxSize {
+		diffStr = fmt.Sprintf(
+			"%s\n... (%d chars omitted)",
+			diffStr[0:config.MaxSize],
+			len(diffStr)-config.MaxSize,
+		)
+	}
+
+	objPath := filepath.Join(oldRoot, oldName)
+	if oldName == "" {
+		objPath = filepath.Join(newRoot, newName)
+	}
+	obj, err := getFileObj(objPath)
+	if err != nil {
+		log.Warnf("Error parsing path %s: %+v", objPath, err)
+	}
+
+	return &Result{
+		Object:     obj,
+		Name:       name,
+		RawDiff:    diffStr,
+		NumAdded:   numAdded,
+		NumRemoved: numRemoved,
+		Operation:  operation,
+		Changes:    changes,
+	}, nil
+}
+
+func getFileContents(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(contents, &obj); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %+v", path, err)
+	}
+
+	// Skip over the managed fields since they're constantly changing and causing spurious
+	// diffs
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "managedFields")
+	}
+
+	return obj, nil
+}
+
+// DiffObjects compares the argument objects as trees and returns the paths of the fields
+// that differ between them. Elements of lists of objects are matched up by merge key when
+// possible, so that reordering a list doesn't show up as a change.
+func DiffObjects(oldObj map[string]interface{}, newObj map[string]interface{}) []Change {
+	changes := []Change{}
+	diffValues("", toInterface(oldObj), toInterface(newObj), &changes)
+	return changes
+}
+
+func toInterface(obj map[string]interface{}) interface{} {
+	if obj == nil {
+		return nil
+	}
+	return obj
+}
+
+func diffValues(path string, oldValue interface{}, newValue interface{}, changes *[]Change) {
+	switch {
+	case oldValue == nil && newValue == nil:
+		return
+	case oldValue == nil:
+		addLeaves(path, newValue, ChangeTypeAdd, changes)
+		return
+	case newValue == nil:
+		addLeaves(path, oldValue, ChangeTypeRemove, changes)
+		return
+	}
+
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		diffMaps(path, oldMap, newMap, changes)
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList {
+		diffLists(path, oldList, newList, changes)
+		return
+	}
+
+	if !valuesEqual(oldValue, newValue) {
+		*changes = append(
+			*changes,
+			Change{
+				Path:     path,
+				Type:     ChangeTypeChange,
+				OldValue: oldValue,
+				NewValue: newValue,
+			},
+		)
+	}
+}
+
+func diffMaps(
+	path string,
+	oldMap map[string]interface{},
+	newMap map[string]interface{},
+	changes *[]Change,
+) {
+	keys := map[string]struct{}{}
+	for key := range oldMap {
+		keys[key] = struct{}{}
+	}
+	for key := range newMap {
+		keys[key] = struct{}{}
+	}
+
+	sortedKeys := []string{}
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		diffValues(mapPath(path, key), oldMap[key], newMap[key], changes)
+	}
+}
+
+func diffLists(
+	path string,
+	oldList []interface{},
+	newList []interface{},
+	changes *[]Change,
+) {
+	mergeKey := findMergeKey(oldList, newList)
+	if mergeKey == "" {
+		maxLen := len(oldList)
+		if len(newList) > maxLen {
+			maxLen = len(newList)
+		}
+
+		for i := 0; i < maxLen; i++ {
+			var oldElement interface{}
+			var newElement interface{}
+			if i < len(oldList) {
+				oldElement = oldList[i]
+			}
+			if i < len(newList) {
+				newElement = newList[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), oldElement, newElement, changes)
+		}
+		return
+	}
+
+	oldElements := map[string]interface{}{}
+	for _, element := range oldList {
+		oldElements[mergeKeyValue(element, mergeKey)] = element
+	}
+
+	newKeys := map[string]struct{}{}
+	for _, element := range newList {
+		keyValue := mergeKeyValue(element, mergeKey)
+		newKeys[keyValue] = struct{}{}
+
+		diffValues(
+			fmt.Sprintf("%s[%s=%s]", path, mergeKey, keyValue),
+			oldElements[keyValue],
+			element,
+			changes,
+		)
+	}
+
+	for _, element := range oldList {
+		keyValue := mergeKeyValue(element, mergeKey)
+		if _, ok := newKeys[keyValue]; !ok {
+			diffValues(
+				fmt.Sprintf("%s[%s=%s]", path, mergeKey, keyValue),
+				element,
+				nil,
+				changes,
+			)
+		}
+	}
+}
+
+// findMergeKey returns the first key in mergeKeyCandidates that uniquely identifies every
+// element in both of the argument lists, or an empty string if there isn't one.
+func findMergeKey(oldList []interface{}, newList []interface{}) string {
+	if len(oldList) == 0 && len(newList) == 0 {
+		return ""
+	}
+
+	for _, candidate := range mergeKeyCandidates {
+		if isMergeKey(oldList, candidate) && isMergeKey(newList, candidate) {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+func isMergeKey(list []interface{}, key string) bool {
+	values := map[string]struct{}{}
+
+	for _, element := range list {
+		elementMap, ok := element.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		switch elementMap[key].(type) {
+		case string, float64, int64, bool:
+		default:
+			return false
+		}
+
+		value := mergeKeyValue(element, key)
+		if _, ok := values[value]; ok {
+			return false
+		}
+		values[value] = struct{}{}
+	}
+
+	return true
+}
+
+func mergeKeyValue(element interface{}, key string) string {
+	return fmt.Sprintf("%v", element.(map[string]interface{})[key])
+}
+
+// addLeaves adds a change for each of the leaf fields in the argument value.
+func addLeaves(path string, value interface{}, changeType ChangeType, changes *[]Change) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if len(typedValue) > 0 {
+			oldMap := typedValue
+			newMap := map[string]interface{}{}
+			if changeType == ChangeTypeAdd {
+				oldMap, newMap = newMap, typedValue
+			}
+			diffMaps(path, oldMap, newMap, changes)
+			return
+		}
+	case []interface{}:
+		if len(typedValue) > 0 {
+			oldList := typedValue
+			newList := []interface{}{}
+			if changeType == ChangeTypeAdd {
+				oldList, newList = newList, typedValue
+			}
+			diffLists(path, oldList, newList, changes)
+			return
+		}
+	}
+
+	change := Change{
+		Path: path,
+		Type: changeType,
+	}
+	if changeType == ChangeTypeAdd {
+		change.NewValue = value
+	} else {
+		change.OldValue = value
+	}
+	*changes = append(*changes, change)
+}
+
+func mapPath(path string, key string) string {
+	if !plainKeyRegexp.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	} else if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", path, key)
+}
+
+func valuesEqual(value1 interface{}, value2 interface{}) bool {
+	bytes1, err1 := json.Marshal(value1)
+	bytes2, err2 := json.Marshal(value2)
+	return err1 == nil && err2 == nil && string(bytes1) == string(bytes2)
+}
+
+func formatValue(value interface{}) string {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(valueBytes)
+}
@@ -0,0 +1,176 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKubeStructural(t *testing.T) {
+	results, err := DiffKube(
+		"testdata/old",
+		"testdata/new",
+		DiffConfig{
+			MaxLineLength: 256,
+			MaxSize:       3000,
+			Mode:          DiffModeStructural,
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(results))
+
+	assert.Equal(t, "file1.yaml", results[0].Name)
+	assert.Equal(
+		t,
+		`~ spec.replicas: 1 -> 3
+~ spec.template.metadata.labels.version: "1.0" -> "1.2"`,
+		results[0].RawDiff,
+	)
+	assert.Equal(t, 2, results[0].NumAdded)
+	assert.Equal(t, 2, results[0].NumRemoved)
+	assert.Equal(t, OperationUpdate, results[0].Operation)
+	assert.Equal(t, "Deployment", results[0].Object.Kind)
+	assert.Equal(
+		t,
+		Change{
+			Path:     "spec.replicas",
+			Type:     ChangeTypeChange,
+			OldValue: float64(1),
+			NewValue: float64(3),
+		},
+		results[0].Changes[0],
+	)
+
+	assert.Equal(t, "file2.yaml", results[1].Name)
+	assert.Equal(t, OperationDelete, results[1].Operation)
+	assert.Equal(t, 0, results[1].NumAdded)
+	assert.Contains(t, results[1].RawDiff, `- spec.ports[port=80].targetPort: 8080`)
+
+	assert.Equal(t, "file3.yaml", results[2].Name)
+	assert.Equal(t, OperationCreate, results[2].Operation)
+	assert.Contains(t, results[2].RawDiff, `+ metadata.name: "echoserver2"`)
+
+	_, err = DiffKube("testdata/old", "testdata/new", DiffConfig{Mode: "unknown"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown diff mode")
+}
+
+func TestDiffObjects(t *testing.T) {
+	type testCase struct {
+		description string
+		oldYAML     string
+		newYAML     string
+		expected    []string
+	}
+
+	testCases := []testCase{
+		{
+			description: "reordered keys",
+			oldYAML: `
+metadata:
+  name: test
+  labels:
+    a: b
+spec:
+  replicas: 1
+`,
+			newYAML: `
+spec:
+  replicas: 1
+metadata:
+  labels:
+    a: b
+  name: test
+`,
+			expected: []string{},
+		},
+		{
+			description: "containers matched by name",
+			oldYAML: `
+spec:
+  containers:
+  - name: sidecar
+    image: sidecar:1
+  - name: app
+    image: app:1
+    args: [--a, --b]
+`,
+			newYAML: `
+spec:
+  containers:
+  - name: app
+    image: app:2
+    args: [--a, --c]
+  - name: sidecar
+    image: sidecar:1
+  - name: init
+    image: init:1
+`,
+			expected: []string{
+				`~ spec.containers[name=app].args[1]: "--b" -> "--c"`,
+				`~ spec.containers[name=app].image: "app:1" -> "app:2"`,
+				`+ spec.containers[name=init].image: "init:1"`,
+				`+ spec.containers[name=init].name: "init"`,
+			},
+		},
+		{
+			description: "removed list elements and non-plain keys",
+			oldYAML: `
+metadata:
+  annotations:
+    example.com/key: value
+spec:
+  ports:
+  - port: 80
+    protocol: TCP
+  - port: 443
+    protocol: TCP
+`,
+			newYAML: `
+metadata:
+  annotations: {}
+spec:
+  ports:
+  - port: 443
+    protocol: TCP
+`,
+			expected: []string{
+				`- metadata.annotations["example.com/key"]: "value"`,
+				`- spec.ports[port=80].port: 80`,
+				`- spec.ports[port=80].protocol: "TCP"`,
+			},
+		},
+		{
+			description: "type changes",
+			oldYAML: `
+data:
+  value: "1"
+  other: [a]
+`,
+			newYAML: `
+data:
+  value: 1
+  other: a
+`,
+			expected: []string{
+				`~ data.other: ["a"] -> "a"`,
+				`~ data.value: "1" -> 1`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		oldObj := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(testCase.oldYAML), &oldObj))
+		newObj := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(testCase.newYAML), &newObj))
+
+		lines := []string{}
+		for _, change := range DiffObjects(oldObj, newObj) {
+			lines = append(lines, change.String())
+		}
+		assert.Equal(t, testCase.expected, lines, testCase.description)
+	}
+}
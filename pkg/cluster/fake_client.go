@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
 )
 
 var _ Client = (*FakeClient)(nil)
@@ -16,8 +18,15 @@ type FakeClient struct {
 	subpathOverride string
 	kubectlErr      error
 
-	NoDiffs bool
-	Calls   []FakeClientCall
+	NoDiffs          bool
+	LiveHashes       map[string]string
+	LiveAnnotations  map[string]map[string]string
+	LabeledResources []string
+	StuckResources   []kube.StuckResource
+	Calls            []FakeClientCall
+
+	// IgnoreFields are the ignore rules that were passed to the last structured diff
+	IgnoreFields []diff.IgnoreRule
 }
 
 // FakeClientCall records a call that was made using the FakeClient.
@@ -52,7 +61,7 @@ func NewFakeClientError(
 func (cc *FakeClient) Apply(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
 ) ([]byte, error) {
 	cc.Calls = append(
 		cc.Calls,
@@ -71,10 +80,40 @@ func (cc *FakeClient) Apply(
 		cc.kubectlErr
 }
 
-// Delete deletes the resources associated with one or more configs.
+// ApplyStructured runs a fake structured apply using the configs in the argument path.
+func (cc *FakeClient) ApplyStructured(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+) ([]apply.Result, error) {
+	cc.Calls = append(
+		cc.Calls,
+		FakeClientCall{
+			CallType: "ApplyStructured",
+			Paths:    paths,
+		},
+	)
+	if cc.kubectlErr != nil {
+		return nil, cc.kubectlErr
+	}
+
+	return []apply.Result{
+		{
+			Name:       "result",
+			Namespace:  "default",
+			Kind:       "Deployment",
+			OldVersion: "1",
+			NewVersion: "2",
+		},
+	}, nil
+}
+
+// Delete deletes the resources associated with one or more configs. If StuckResources is set
+// and the options have a wait timeout, then it returns a *kube.DeleteTimeoutError.
 func (cc *FakeClient) Delete(
 	ctx context.Context,
 	ids []string,
+	options kube.DeleteOptions,
 ) ([]byte, error) {
 	cc.Calls = append(
 		cc.Calls,
@@ -83,6 +122,9 @@ func (cc *FakeClient) Delete(
 			Paths:    ids,
 		},
 	)
+	if options.WaitTimeout > 0 && len(cc.StuckResources) > 0 {
+		return nil, &kube.DeleteTimeoutError{Stuck: cc.StuckResources}
+	}
 	return []byte(
 			fmt.Sprintf(
 				"delete result for %s with ids %+v",
@@ -98,7 +140,7 @@ func (cc *FakeClient) Delete(
 func (cc *FakeClient) Diff(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
 ) ([]byte, error) {
 	cc.Calls = append(
 		cc.Calls,
@@ -126,7 +168,8 @@ func (cc *FakeClient) Diff(
 func (cc *FakeClient) DiffStructured(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
 ) ([]diff.Result, error) {
 	cc.Calls = append(
 		cc.Calls,
@@ -135,6 +178,7 @@ func (cc *FakeClient) DiffStructured(
 			Paths:    paths,
 		},
 	)
+	cc.IgnoreFields = ignoreFields
 	if cc.NoDiffs {
 		return []diff.Result{}, nil
 	}
@@ -153,6 +197,68 @@ func (cc *FakeClient) DiffStructured(
 		cc.kubectlErr
 }
 
+// Hashes returns the hashes in LiveHashes for the argument ids. Ids that aren't in
+// LiveHashes are omitted from the result.
+func (cc *FakeClient) Hashes(
+	ctx context.Context,
+	ids []string,
+	ignoreFields []diff.IgnoreRule,
+) (map[string]string, error) {
+	cc.Calls = append(
+		cc.Calls,
+		FakeClientCall{
+			CallType: "Hashes",
+			Paths:    ids,
+		},
+	)
+
+	hashes := map[string]string{}
+	for _, id := range ids {
+		if hash, ok := cc.LiveHashes[id]; ok {
+			hashes[id] = hash
+		}
+	}
+	return hashes, cc.kubectlErr
+}
+
+// Annotations returns the annotations in LiveAnnotations for the argument ids. Ids that
+// aren't in LiveAnnotations are omitted from the result.
+func (cc *FakeClient) Annotations(
+	ctx context.Context,
+	ids []string,
+) (map[string]map[string]string, error) {
+	cc.Calls = append(
+		cc.Calls,
+		FakeClientCall{
+			CallType: "Annotations",
+			Paths:    ids,
+		},
+	)
+
+	annotations := map[string]map[string]string{}
+	for _, id := range ids {
+		if idAnnotations, ok := cc.LiveAnnotations[id]; ok {
+			annotations[id] = idAnnotations
+		}
+	}
+	return annotations, cc.kubectlErr
+}
+
+// LabeledIDs returns the ids in LabeledResources, regardless of the argument label selector.
+func (cc *FakeClient) LabeledIDs(
+	ctx context.Context,
+	labelSelector string,
+) ([]string, error) {
+	cc.Calls = append(
+		cc.Calls,
+		FakeClientCall{
+			CallType: "LabeledIDs",
+			Paths:    []string{labelSelector},
+		},
+	)
+	return cc.LabeledResources, cc.kubectlErr
+}
+
 // Config returns this client's cluster config.
 func (cc *FakeClient) Config() *Config {
 	cc.Calls = append(
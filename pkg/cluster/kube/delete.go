@@ -0,0 +1,154 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+// Interval between checks for whether deleted resources have been removed; overridden in
+// tests.
+var deletePollInterval = 2 * time.Second
+
+// DeleteOptions configures deletes.
+type DeleteOptions struct {
+	// WaitTimeout is the maximum amount of time to wait for deleted resources to be removed
+	// from the cluster, e.g. after their finalizers have run. If zero, deletes don't wait.
+	WaitTimeout time.Duration
+}
+
+// StuckResource is a resource that's still in the cluster after being deleted.
+type StuckResource struct {
+	// ID is the manifest id of the resource.
+	ID string
+
+	// Finalizers are the finalizers that haven't been completed yet.
+	Finalizers []string
+}
+
+// DeleteTimeoutError is returned by clients when deleted resources aren't removed from the
+// cluster before the wait timeout.
+type DeleteTimeoutError struct {
+	Stuck []StuckResource
+}
+
+// Error returns a summary of all of the stuck resources in this error.
+func (e *DeleteTimeoutError) Error() string {
+	ids := []string{}
+	for _, stuck := range e.Stuck {
+		ids = append(ids, stuck.ID)
+	}
+
+	return fmt.Sprintf(
+		"Timed out waiting for %d deleted resource(s) to be removed: %s",
+		len(e.Stuck),
+		strings.Join(ids, ", "),
+	)
+}
+
+// SortIDsForDelete returns a copy of the argument manifest ids in the order in which the
+// associated resources should be deleted. This is the reverse of the apply order, so that,
+// e.g., workloads are deleted before the service accounts and namespaces that they depend on.
+// Kinds that aren't in KindOrder, including custom resources, are deleted first.
+func SortIDsForDelete(ids []string) []string {
+	orderMap := map[string]int{}
+	for k, kind := range KindOrder {
+		orderMap[kind] = k
+	}
+
+	kindOrder := func(kind string) int {
+		if order, ok := orderMap[kind]; ok {
+			return order
+		}
+		return len(orderMap)
+	}
+
+	sortedIDs := append([]string{}, ids...)
+
+	sort.SliceStable(
+		sortedIDs,
+		func(i, j int) bool {
+			components1 := manifestIDToComponents(sortedIDs[i])
+			components2 := manifestIDToComponents(sortedIDs[j])
+
+			kindOrder1 := kindOrder(components1.kind)
+			kindOrder2 := kindOrder(components2.kind)
+
+			if kindOrder1 != kindOrder2 {
+				return kindOrder1 > kindOrder2
+			} else if components1.namespace != components2.namespace {
+				return components1.namespace < components2.namespace
+			}
+			return components1.name < components2.name
+		},
+	)
+
+	return sortedIDs
+}
+
+// deletedResource is a resource that's been deleted, along with a client that can be used to
+// check whether it's been removed.
+type deletedResource struct {
+	id     string
+	name   string
+	client dynamic.ResourceInterface
+}
+
+// waitForDeletes waits until all of the argument resources have been removed from the
+// cluster. If any are still there after the argument timeout, then it returns a
+// *DeleteTimeoutError.
+func waitForDeletes(
+	ctx context.Context,
+	resources []deletedResource,
+	timeout time.Duration,
+) error {
+	log.Infof("Waiting up to %s for %d deleted resources to be removed", timeout, len(resources))
+
+	deadline := time.Now().Add(timeout)
+	remaining := resources
+
+	for {
+		stuck := []StuckResource{}
+		stillRemaining := []deletedResource{}
+
+		for _, resource := range remaining {
+			obj, err := resource.client.Get(ctx, resource.name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			stillRemaining = append(stillRemaining, resource)
+			stuck = append(
+				stuck,
+				StuckResource{
+					ID:         resource.id,
+					Finalizers: obj.GetFinalizers(),
+				},
+			)
+		}
+
+		if len(stillRemaining) == 0 {
+			return nil
+		}
+		remaining = stillRemaining
+
+		if !time.Now().Before(deadline) {
+			return &DeleteTimeoutError{Stuck: stuck}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(deletePollInterval):
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortIDsForDelete(t *testing.T) {
+	ids := []string{
+		"v1.Namespace..testNamespace",
+		"v1.Service.testNamespace.testName",
+		"rbac.authorization.k8s.io/v1.ClusterRole..testRole",
+		"apps/v1.Deployment.testNamespace.testName2",
+		"apps/v1.Deployment.testNamespace.testName1",
+		"example.com/v1.Widget.testNamespace.testName",
+		"apiextensions.k8s.io/v1.CustomResourceDefinition..widgets.example.com",
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			"example.com/v1.Widget.testNamespace.testName",
+			"apps/v1.Deployment.testNamespace.testName1",
+			"apps/v1.Deployment.testNamespace.testName2",
+			"v1.Service.testNamespace.testName",
+			"rbac.authorization.k8s.io/v1.ClusterRole..testRole",
+			"apiextensions.k8s.io/v1.CustomResourceDefinition..widgets.example.com",
+			"v1.Namespace..testNamespace",
+		},
+		SortIDsForDelete(ids),
+	)
+
+	// The argument slice isn't modified
+	assert.Equal(t, "v1.Namespace..testNamespace", ids[0])
+}
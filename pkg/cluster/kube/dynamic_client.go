@@ -0,0 +1,516 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Default field manager used for server-side applies made by the DynamicClient.
+	dynamicFieldManager = "kubeapply"
+)
+
+// DynamicClient is an in-process alternative to OrderedClient. Instead of wrapping kubectl
+// and kadiff, it talks to the Kubernetes API directly via client-go's dynamic client and
+// uses server-side applies for both applies and (dry-run) diffs.
+type DynamicClient struct {
+	dynamicClient dynamic.Interface
+	mapper        meta.RESTMapper
+	keepConfigs   bool
+	diffConfig    diff.DiffConfig
+}
+
+// NewDynamicClient returns a new DynamicClient instance for the cluster referenced by the
+// argument kubeconfig.
+func NewDynamicClient(
+	kubeConfigPath string,
+	keepConfigs bool,
+	diffConfig diff.DiffConfig,
+) (*DynamicClient, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	mapper, err := NewRESTMapper(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDynamicClient(dynamicClient, mapper, keepConfigs, diffConfig), nil
+}
+
+func newDynamicClient(
+	dynamicClient dynamic.Interface,
+	mapper meta.RESTMapper,
+	keepConfigs bool,
+	diffConfig diff.DiffConfig,
+) *DynamicClient {
+	return &DynamicClient{
+		dynamicClient: dynamicClient,
+		mapper:        mapper,
+		keepConfigs:   keepConfigs,
+		diffConfig:    diffConfig,
+	}
+}
+
+// appliedObject stores the versions of a single object before and after an apply.
+type appliedObject struct {
+	obj    *unstructured.Unstructured
+	oldObj *unstructured.Unstructured
+	newObj *unstructured.Unstructured
+}
+
+// Apply applies the manifests in the argument paths. The apply is done in the optimal order
+// based on resource type. The output has one kubectl-style line per resource.
+//
+// Applies are always done server-side, so only the field manager and conflict settings in the
+// argument options are used. Resources with field conflicts are skipped and returned together
+// in an *ApplyConflictsError after all of the other resources are applied.
+func (d *DynamicClient) Apply(
+	ctx context.Context,
+	applyPaths []string,
+	dryRun bool,
+	serverSide ServerSideOptions,
+) ([]byte, error) {
+	appliedObjs, err := d.applyAll(ctx, applyPaths, dryRun, serverSide)
+
+	lines := []string{}
+
+	for _, appliedObj := range appliedObjs {
+		var status string
+		if appliedObj.oldObj == nil {
+			status = "created"
+		} else if appliedObj.oldObj.GetResourceVersion() ==
+			appliedObj.newObj.GetResourceVersion() {
+			status = "unchanged"
+		} else {
+			status = "configured"
+		}
+		if dryRun {
+			status = fmt.Sprintf("%s (server dry run)", status)
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %s", objDisplayName(appliedObj.obj), status))
+	}
+
+	return []byte(strings.Join(lines, "\n")), err
+}
+
+// ApplyStructured applies the manifests in the argument paths and returns structured,
+// per-resource results. See Apply for more details.
+func (d *DynamicClient) ApplyStructured(
+	ctx context.Context,
+	applyPaths []string,
+	serverSide ServerSideOptions,
+) ([]apply.Result, error) {
+	appliedObjs, err := d.applyAll(ctx, applyPaths, false, serverSide)
+	if err != nil {
+		return nil, err
+	}
+
+	oldObjs := []apply.TypedKubeObj{}
+	newObjs := []apply.TypedKubeObj{}
+
+	for _, appliedObj := range appliedObjs {
+		if appliedObj.oldObj != nil {
+			oldObjs = append(oldObjs, objToTyped(appliedObj.oldObj))
+		} else {
+			// Use the manifest, which has no resource version, so that the result is
+			// treated as a creation
+			oldObjs = append(oldObjs, objToTyped(appliedObj.obj))
+		}
+		newObjs = append(newObjs, objToTyped(appliedObj.newObj))
+	}
+
+	return apply.ObjsToResults(oldObjs, newObjs)
+}
+
+// applyAll applies all of the manifests in the argument paths, stopping at the first error
+// other than a field conflict.
+func (d *DynamicClient) applyAll(
+	ctx context.Context,
+	applyPaths []string,
+	dryRun bool,
+	serverSide ServerSideOptions,
+) ([]appliedObject, error) {
+	objs, err := getOrderedObjects(applyPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedObjs := []appliedObject{}
+	conflicts := []ApplyConflict{}
+
+	for _, obj := range objs {
+		oldObj, newObj, err := d.applyObject(ctx, obj, dryRun, serverSide)
+		if errors.IsConflict(err) {
+			conflicts = append(
+				conflicts,
+				ApplyConflict{
+					Resource: objDisplayName(obj),
+					Message:  err.Error(),
+				},
+			)
+			continue
+		} else if err != nil {
+			return appliedObjs, fmt.Errorf(
+				"Error applying %s: %+v",
+				objDisplayName(obj),
+				err,
+			)
+		}
+
+		appliedObjs = append(
+			appliedObjs,
+			appliedObject{
+				obj:    obj,
+				oldObj: oldObj,
+				newObj: newObj,
+			},
+		)
+	}
+
+	if len(conflicts) > 0 {
+		return appliedObjs, &ApplyConflictsError{Conflicts: conflicts}
+	}
+
+	return appliedObjs, nil
+}
+
+// Diff generates structured diffs between the manifests in the argument paths and the
+// current state of the resources in the cluster. The local versions are evaluated via
+// dry-run applies so that defaulting and admission changes are reflected in the results. The
+// argument ignore rules are added to the ones in the client's diff config.
+func (d *DynamicClient) Diff(
+	ctx context.Context,
+	configPaths []string,
+	serverSide ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
+) ([]diff.Result, error) {
+	tempDir, err := ioutil.TempDir("", "kubeapply_diff_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if d.keepConfigs {
+			log.Infof("Keeping temporary configs in %s", tempDir)
+		} else {
+			os.RemoveAll(tempDir)
+		}
+	}()
+
+	// Use the same layout that kubectl diff passes to its external diff command
+	liveDir := filepath.Join(tempDir, "LIVE")
+	mergedDir := filepath.Join(tempDir, "MERGED")
+
+	for _, dir := range []string{liveDir, mergedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	objs, err := getOrderedObjects(configPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range objs {
+		oldObj, newObj, err := d.applyObject(ctx, obj, true, serverSide)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Error running dry-run apply for %s: %+v",
+				objDisplayName(obj),
+				err,
+			)
+		}
+
+		fileName := objDiffFileName(obj)
+
+		if oldObj != nil {
+			if err := writeObj(filepath.Join(liveDir, fileName), oldObj); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeObj(filepath.Join(mergedDir, fileName), newObj); err != nil {
+			return nil, err
+		}
+	}
+
+	diffConfig := d.diffConfig
+	diffConfig.IgnoreFields = append(
+		append([]diff.IgnoreRule{}, d.diffConfig.IgnoreFields...),
+		ignoreFields...,
+	)
+
+	return diff.DiffKube(liveDir, mergedDir, diffConfig)
+}
+
+// Delete deletes the resources associated with the argument manifest ids. Resources that
+// can't be found in the cluster are skipped. The deletes are done in the reverse of the apply
+// order. If the argument options have a wait timeout, then it waits for the resources to be
+// removed and returns a *DeleteTimeoutError if any are still there after the timeout.
+func (d *DynamicClient) Delete(
+	ctx context.Context,
+	ids []string,
+	options DeleteOptions,
+) ([]byte, error) {
+	lines := []string{}
+	deleted := []deletedResource{}
+
+	for _, id := range SortIDsForDelete(ids) {
+		idComponents := manifestIDToComponents(id)
+		if idComponents.name == "" {
+			log.Warnf("Could not parse id %s; skipping delete", id)
+			continue
+		}
+
+		groupVersion, err := schema.ParseGroupVersion(idComponents.api)
+		if err != nil {
+			return []byte(strings.Join(lines, "\n")), err
+		}
+		gvk := groupVersion.WithKind(idComponents.kind)
+
+		resourceClient, err := d.resourceClient(gvk, idComponents.namespace)
+		if meta.IsNoMatchError(err) {
+			log.Warnf(
+				"Could not find resource name for kind %s; skipping delete",
+				idComponents.kind,
+			)
+			continue
+		} else if err != nil {
+			return []byte(strings.Join(lines, "\n")), err
+		}
+
+		propagationPolicy := metav1.DeletePropagationBackground
+		err = resourceClient.Delete(
+			ctx,
+			idComponents.name,
+			metav1.DeleteOptions{
+				PropagationPolicy: &propagationPolicy,
+			},
+		)
+		if errors.IsNotFound(err) {
+			log.Infof("Resource %s not found; skipping delete", id)
+			continue
+		} else if err != nil {
+			return []byte(strings.Join(lines, "\n")), err
+		}
+
+		lines = append(
+			lines,
+			fmt.Sprintf(
+				"%s \"%s\" deleted",
+				kindDisplayName(gvk),
+				idComponents.name,
+			),
+		)
+		deleted = append(
+			deleted,
+			deletedResource{
+				id:     id,
+				name:   idComponents.name,
+				client: resourceClient,
+			},
+		)
+	}
+
+	if options.WaitTimeout > 0 && len(deleted) > 0 {
+		if err := waitForDeletes(ctx, deleted, options.WaitTimeout); err != nil {
+			return []byte(strings.Join(lines, "\n")), err
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// applyObject does a server-side apply of the argument object and returns the versions of
+// it before (nil if the object didn't previously exist) and after the apply.
+func (d *DynamicClient) applyObject(
+	ctx context.Context,
+	obj *unstructured.Unstructured,
+	dryRun bool,
+	serverSide ServerSideOptions,
+) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	resourceClient, err := d.resourceClient(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldObj, err := resourceClient.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		oldObj = nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	contents, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fieldManager := serverSide.FieldManager
+	if fieldManager == "" {
+		fieldManager = dynamicFieldManager
+	}
+	patchOptions := metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        &serverSide.ForceConflicts,
+	}
+	if dryRun {
+		patchOptions.DryRun = []string{metav1.DryRunAll}
+	}
+
+	newObj, err := resourceClient.Patch(
+		ctx,
+		obj.GetName(),
+		types.ApplyPatchType,
+		contents,
+		patchOptions,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return oldObj, newObj, nil
+}
+
+func (d *DynamicClient) resourceClient(
+	gvk schema.GroupVersionKind,
+	namespace string,
+) (dynamic.ResourceInterface, error) {
+	return ResourceClient(d.dynamicClient, d.mapper, gvk, namespace)
+}
+
+// getOrderedObjects parses all of the manifests in the argument paths into unstructured
+// objects, sorted in the order in which they should be applied. List kinds are flattened
+// into their component items.
+func getOrderedObjects(paths []string) ([]*unstructured.Unstructured, error) {
+	manifests, err := GetManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+	SortManifests(manifests)
+
+	objs := []*unstructured.Unstructured{}
+
+	for _, manifest := range manifests {
+		obj, err := manifestToObj(manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < len(list.Items); i++ {
+				objs = append(objs, &list.Items[i])
+			}
+		} else {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs, nil
+}
+
+func manifestToObj(manifest Manifest) (*unstructured.Unstructured, error) {
+	jsonContents, err := yaml.YAMLToJSON([]byte(manifest.Contents))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse manifest in %s: %+v", manifest.Path, err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(jsonContents); err != nil {
+		return nil, fmt.Errorf("Could not parse manifest in %s: %+v", manifest.Path, err)
+	}
+
+	return obj, nil
+}
+
+// objToTyped converts an unstructured object to the format used for apply results.
+func objToTyped(obj *unstructured.Unstructured) apply.TypedKubeObj {
+	var creationTimestamp string
+	if timestamp := obj.GetCreationTimestamp(); !timestamp.IsZero() {
+		creationTimestamp = timestamp.UTC().Format(time.RFC3339)
+	}
+
+	return apply.TypedKubeObj{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		KubeMetadata: apply.KubeMetadata{
+			Name:              obj.GetName(),
+			Namespace:         obj.GetNamespace(),
+			ResourceVersion:   obj.GetResourceVersion(),
+			CreationTimestamp: creationTimestamp,
+		},
+	}
+}
+
+func writeObj(path string, obj *unstructured.Unstructured) error {
+	contents, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// kindDisplayName returns the kubectl-style name for a kind, e.g. "deployment.apps".
+func kindDisplayName(gvk schema.GroupVersionKind) string {
+	if gvk.Group == "" {
+		return strings.ToLower(gvk.Kind)
+	}
+	return fmt.Sprintf("%s.%s", strings.ToLower(gvk.Kind), gvk.Group)
+}
+
+// objDisplayName returns the kubectl-style name for an object, e.g.
+// "deployment.apps/my-deployment".
+func objDisplayName(obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s", kindDisplayName(obj.GroupVersionKind()), obj.GetName())
+}
+
+// objDiffFileName returns the name of the file used for diffing the argument object. This
+// matches the names used by kubectl diff so that results look the same regardless of which
+// client generated them.
+func objDiffFileName(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+
+	var group string
+	if gvk.Group != "" {
+		group = fmt.Sprintf("%s.", gvk.Group)
+	}
+
+	return fmt.Sprintf(
+		"%s%s.%s.%s.%s",
+		group,
+		gvk.Version,
+		gvk.Kind,
+		obj.GetNamespace(),
+		obj.GetName(),
+	)
+}
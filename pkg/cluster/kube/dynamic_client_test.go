@@ -0,0 +1,572 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testDynamicDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: testName
+  namespace: testNamespace
+spec:
+  replicas: 3
+`
+
+	testDynamicService = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: testName
+  namespace: testNamespace
+spec:
+  type: ClusterIP
+`
+)
+
+var (
+	testDeploymentsGVR = schema.GroupVersionResource{
+		Group:    "apps",
+		Version:  "v1",
+		Resource: "deployments",
+	}
+	testServicesGVR = schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "services",
+	}
+)
+
+func TestDynamicClientApply(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(false, testExistingDeployment())
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	manifestsDir := writeTestDynamicManifests(t)
+	defer os.RemoveAll(manifestsDir)
+
+	results, err := client.Apply(ctx, []string{manifestsDir}, false, ServerSideOptions{})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"service/testName created\ndeployment.apps/testName configured",
+		string(results),
+	)
+
+	deployment := store.get(testDeploymentsGVR, "testNamespace", "testName")
+	require.NotNil(t, deployment)
+	replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+
+	results, err = client.Apply(ctx, []string{manifestsDir}, false, ServerSideOptions{})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"service/testName unchanged\ndeployment.apps/testName unchanged",
+		string(results),
+	)
+
+	patchTypes := []types.PatchType{}
+	for _, action := range store.actions {
+		if patchAction, ok := action.(k8stesting.PatchAction); ok {
+			patchTypes = append(patchTypes, patchAction.GetPatchType())
+			assert.Equal(
+				t,
+				dynamicFieldManager,
+				store.patchOptions[len(patchTypes)-1].FieldManager,
+			)
+		}
+	}
+	assert.Equal(
+		t,
+		[]types.PatchType{
+			types.ApplyPatchType,
+			types.ApplyPatchType,
+			types.ApplyPatchType,
+			types.ApplyPatchType,
+		},
+		patchTypes,
+	)
+}
+
+func TestDynamicClientApplyStructured(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(false, testExistingDeployment())
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	manifestsDir := writeTestDynamicManifests(t)
+	defer os.RemoveAll(manifestsDir)
+
+	results, err := client.ApplyStructured(ctx, []string{manifestsDir}, ServerSideOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+
+	assert.Equal(t, "Service", results[0].Kind)
+	assert.Equal(t, "testName", results[0].Name)
+	assert.Equal(t, "testNamespace", results[0].Namespace)
+	assert.True(t, results[0].IsCreated())
+
+	assert.Equal(t, "Deployment", results[1].Kind)
+	assert.Equal(t, "1", results[1].OldVersion)
+	assert.True(t, results[1].IsUpdated())
+
+	results, err = client.ApplyStructured(ctx, []string{manifestsDir}, ServerSideOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	for _, result := range results {
+		assert.False(t, result.IsCreated())
+		assert.False(t, result.IsUpdated())
+	}
+}
+
+func TestDynamicClientApplyConflicts(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(false, testExistingDeployment())
+	store.conflicts = map[string]struct{}{
+		fakeObjectKey(testDeploymentsGVR, "testNamespace", "testName"): {},
+	}
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	manifestsDir := writeTestDynamicManifests(t)
+	defer os.RemoveAll(manifestsDir)
+
+	results, err := client.Apply(ctx, []string{manifestsDir}, false, ServerSideOptions{})
+	assert.Equal(t, "service/testName created", string(results))
+
+	conflictsErr, ok := err.(*ApplyConflictsError)
+	require.True(t, ok)
+	require.Equal(t, 1, len(conflictsErr.Conflicts))
+	assert.Equal(t, "deployment.apps/testName", conflictsErr.Conflicts[0].Resource)
+
+	results, err = client.Apply(
+		ctx,
+		[]string{manifestsDir},
+		false,
+		ServerSideOptions{
+			FieldManager:   "testManager",
+			ForceConflicts: true,
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"service/testName unchanged\ndeployment.apps/testName configured",
+		string(results),
+	)
+	assert.Equal(
+		t,
+		"testManager",
+		store.patchOptions[len(store.patchOptions)-1].FieldManager,
+	)
+}
+
+func TestDynamicClientServerSideOptions(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(false, testExistingDeployment())
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	manifestsDir := writeTestDynamicManifests(t)
+	defer os.RemoveAll(manifestsDir)
+
+	serverSide := ServerSideOptions{
+		FieldManager:   "testManager",
+		ForceConflicts: true,
+	}
+
+	_, err := client.Diff(ctx, []string{manifestsDir}, serverSide, nil)
+	require.NoError(t, err)
+	_, err = client.ApplyStructured(ctx, []string{manifestsDir}, serverSide)
+	require.NoError(t, err)
+
+	require.Equal(t, 4, len(store.patchOptions))
+	for p, patchOptions := range store.patchOptions {
+		assert.Equal(t, "testManager", patchOptions.FieldManager)
+		require.NotNil(t, patchOptions.Force)
+		assert.True(t, *patchOptions.Force)
+		assert.Equal(t, p < 2, len(patchOptions.DryRun) > 0)
+	}
+
+	// The default manager is used if one isn't set, and conflicts aren't forced
+	store.patchOptions = nil
+	_, err = client.Diff(ctx, []string{manifestsDir}, ServerSideOptions{}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(store.patchOptions))
+	for _, patchOptions := range store.patchOptions {
+		assert.Equal(t, dynamicFieldManager, patchOptions.FieldManager)
+		require.NotNil(t, patchOptions.Force)
+		assert.False(t, *patchOptions.Force)
+	}
+}
+
+func TestDynamicClientDiff(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(true, testExistingDeployment())
+	client := newDynamicClient(
+		store.dynamicClient(),
+		testRESTMapper(),
+		false,
+		diff.DiffConfig{
+			ContextLines:  2,
+			MaxLineLength: 256,
+			MaxSize:       3000,
+		},
+	)
+
+	manifestsDir := writeTestDynamicManifests(t)
+	defer os.RemoveAll(manifestsDir)
+
+	results, err := client.Diff(ctx, []string{manifestsDir}, ServerSideOptions{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+
+	assert.Equal(t, "apps.v1.Deployment.testNamespace.testName", results[0].Name)
+	assert.Equal(t, diff.OperationUpdate, results[0].Operation)
+	assert.Equal(t, 1, results[0].NumAdded)
+	assert.Equal(t, 1, results[0].NumRemoved)
+	assert.Contains(t, results[0].RawDiff, "-  replicas: 1\n+  replicas: 3")
+
+	assert.Equal(t, "v1.Service.testNamespace.testName", results[1].Name)
+	assert.Equal(t, diff.OperationCreate, results[1].Operation)
+
+	// Diffs should not change anything in the cluster
+	deployment := store.get(testDeploymentsGVR, "testNamespace", "testName")
+	require.NotNil(t, deployment)
+	replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	assert.Equal(t, int64(1), replicas)
+	assert.Nil(t, store.get(testServicesGVR, "testNamespace", "testName"))
+}
+
+func TestDynamicClientDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeObjectStore(false, testExistingDeployment())
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	results, err := client.Delete(
+		ctx,
+		[]string{
+			"apps/v1.Deployment.testNamespace.testName",
+			// Doesn't exist in cluster
+			"v1.Service.testNamespace.testName",
+			// Unknown kind
+			"v1.UnknownKind.testNamespace.testName",
+			"bad id",
+		},
+		DeleteOptions{},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "deployment.apps \"testName\" deleted", string(results))
+	assert.Nil(t, store.get(testDeploymentsGVR, "testNamespace", "testName"))
+}
+
+func TestDynamicClientDeleteWait(t *testing.T) {
+	ctx := context.Background()
+
+	deletePollInterval = 10 * time.Millisecond
+	defer func() {
+		deletePollInterval = 2 * time.Second
+	}()
+
+	service := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      "testName",
+				"namespace": "testNamespace",
+			},
+		},
+	}
+	service.SetFinalizers([]string{"service.kubernetes.io/load-balancer-cleanup"})
+
+	store := newFakeObjectStore(false, testExistingDeployment(), service)
+	client := newDynamicClient(store.dynamicClient(), testRESTMapper(), false, diff.DiffConfig{})
+
+	results, err := client.Delete(
+		ctx,
+		[]string{
+			"v1.Service.testNamespace.testName",
+			"apps/v1.Deployment.testNamespace.testName",
+		},
+		DeleteOptions{WaitTimeout: 50 * time.Millisecond},
+	)
+	require.Error(t, err)
+
+	// The deployment is deleted before the service that it depends on
+	assert.Equal(
+		t,
+		"deployment.apps \"testName\" deleted\nservice \"testName\" deleted",
+		string(results),
+	)
+
+	timeoutErr, ok := err.(*DeleteTimeoutError)
+	require.True(t, ok)
+	assert.Equal(
+		t,
+		[]StuckResource{
+			{
+				ID:         "v1.Service.testNamespace.testName",
+				Finalizers: []string{"service.kubernetes.io/load-balancer-cleanup"},
+			},
+		},
+		timeoutErr.Stuck,
+	)
+	assert.Nil(t, store.get(testDeploymentsGVR, "testNamespace", "testName"))
+	assert.NotNil(t, store.get(testServicesGVR, "testNamespace", "testName"))
+}
+
+func testExistingDeployment() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":            "testName",
+				"namespace":       "testNamespace",
+				"resourceVersion": "1",
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(1),
+			},
+		},
+	}
+}
+
+func testRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		schema.GroupVersionKind{Version: "v1", Kind: "Service"},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		schema.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+		meta.RESTScopeRoot,
+	)
+	return mapper
+}
+
+func writeTestDynamicManifests(t *testing.T) string {
+	manifestsDir, err := ioutil.TempDir("", "kubeapply_test_dynamic_")
+	require.NoError(t, err)
+
+	util.WriteFiles(
+		t,
+		manifestsDir,
+		map[string]string{
+			"deployment.yaml": testDynamicDeployment,
+			"service.yaml":    testDynamicService,
+		},
+	)
+	return manifestsDir
+}
+
+// fakeObjectStore is a minimal stand-in for the Kubernetes API that supports the calls made
+// by the DynamicClient. It's needed because the object tracker used by the client-go fakes
+// doesn't support server-side apply patches.
+type fakeObjectStore struct {
+	objs     map[string]*unstructured.Unstructured
+	readOnly bool
+	version  int
+	actions  []k8stesting.Action
+
+	// Keys of objects that have field conflicts unless applies are forced
+	conflicts map[string]struct{}
+
+	// Options for all patch calls, in order
+	patchOptions []metav1.PatchOptions
+}
+
+func newFakeObjectStore(
+	readOnly bool,
+	objs ...*unstructured.Unstructured,
+) *fakeObjectStore {
+	store := &fakeObjectStore{
+		objs:     map[string]*unstructured.Unstructured{},
+		readOnly: readOnly,
+		version:  len(objs),
+	}
+
+	for _, obj := range objs {
+		gvr, _ := meta.UnsafeGuessKindToResource(obj.GroupVersionKind())
+		store.objs[fakeObjectKey(gvr, obj.GetNamespace(), obj.GetName())] = obj
+	}
+
+	return store
+}
+
+func (f *fakeObjectStore) dynamicClient() dynamic.Interface {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("*", "*", f.react)
+	return fakeRecordingClient{Interface: client, store: f}
+}
+
+func (f *fakeObjectStore) get(
+	gvr schema.GroupVersionResource,
+	namespace string,
+	name string,
+) *unstructured.Unstructured {
+	return f.objs[fakeObjectKey(gvr, namespace, name)]
+}
+
+func (f *fakeObjectStore) react(
+	action k8stesting.Action,
+) (bool, runtime.Object, error) {
+	f.actions = append(f.actions, action)
+
+	gvr := action.GetResource()
+
+	// Switch on the verb since the action interfaces overlap (e.g., patch actions also
+	// implement GetAction).
+	switch action.GetVerb() {
+	case "get":
+		name := action.(k8stesting.GetAction).GetName()
+		obj, ok := f.objs[fakeObjectKey(gvr, action.GetNamespace(), name)]
+		if !ok {
+			return true, nil, errors.NewNotFound(gvr.GroupResource(), name)
+		}
+		return true, obj.DeepCopy(), nil
+	case "patch":
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		// The options aren't available in the action, so get them from the recording
+		// wrapper.
+		patchOptions := f.patchOptions[len(f.patchOptions)-1]
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patchAction.GetPatch()); err != nil {
+			return true, nil, err
+		}
+
+		key := fakeObjectKey(gvr, action.GetNamespace(), patchAction.GetName())
+		if _, ok := f.conflicts[key]; ok &&
+			(patchOptions.Force == nil || !*patchOptions.Force) {
+			return true, nil, errors.NewConflict(
+				gvr.GroupResource(),
+				patchAction.GetName(),
+				fmt.Errorf("conflict with \"otherManager\": .spec.replicas"),
+			)
+		}
+		existingObj, ok := f.objs[key]
+		if ok {
+			obj.SetResourceVersion(existingObj.GetResourceVersion())
+		}
+		// Like dry-run requests in a real cluster, read-only stores don't bump resource
+		// versions.
+		if !f.readOnly && (!ok || !reflect.DeepEqual(existingObj.Object, obj.Object)) {
+			f.version++
+			obj.SetResourceVersion(fmt.Sprintf("%d", f.version))
+			f.objs[key] = obj
+		}
+		return true, obj.DeepCopy(), nil
+	case "delete":
+		name := action.(k8stesting.DeleteAction).GetName()
+		key := fakeObjectKey(gvr, action.GetNamespace(), name)
+		if _, ok := f.objs[key]; !ok {
+			return true, nil, errors.NewNotFound(gvr.GroupResource(), name)
+		}
+		if !f.readOnly {
+			// Like in a real cluster, objects with finalizers are only marked for deletion
+			if len(f.objs[key].GetFinalizers()) > 0 {
+				now := metav1.Now()
+				f.objs[key].SetDeletionTimestamp(&now)
+			} else {
+				delete(f.objs, key)
+			}
+		}
+		return true, nil, nil
+	default:
+		return false, nil, nil
+	}
+}
+
+func fakeObjectKey(gvr schema.GroupVersionResource, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gvr.String(), namespace, name)
+}
+
+// fakeRecordingClient wraps a fake dynamic client so that the options passed to patch calls,
+// which aren't included in the actions passed to reactors, are recorded in the store.
+type fakeRecordingClient struct {
+	dynamic.Interface
+	store *fakeObjectStore
+}
+
+func (c fakeRecordingClient) Resource(
+	gvr schema.GroupVersionResource,
+) dynamic.NamespaceableResourceInterface {
+	return fakeRecordingNamespaceableResource{
+		NamespaceableResourceInterface: c.Interface.Resource(gvr),
+		store:                          c.store,
+	}
+}
+
+type fakeRecordingNamespaceableResource struct {
+	dynamic.NamespaceableResourceInterface
+	store *fakeObjectStore
+}
+
+func (r fakeRecordingNamespaceableResource) Namespace(
+	namespace string,
+) dynamic.ResourceInterface {
+	return fakeRecordingResource{
+		ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace),
+		store:             r.store,
+	}
+}
+
+func (r fakeRecordingNamespaceableResource) Patch(
+	ctx context.Context,
+	name string,
+	pt types.PatchType,
+	data []byte,
+	options metav1.PatchOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	r.store.patchOptions = append(r.store.patchOptions, options)
+	return r.NamespaceableResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+type fakeRecordingResource struct {
+	dynamic.ResourceInterface
+	store *fakeObjectStore
+}
+
+func (r fakeRecordingResource) Patch(
+	ctx context.Context,
+	name string,
+	pt types.PatchType,
+	data []byte,
+	options metav1.PatchOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	r.store.patchOptions = append(r.store.patchOptions, options)
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
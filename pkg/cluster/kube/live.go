@@ -0,0 +1,577 @@
+package kube
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Field managers whose fields are considered to be owned by kubeapply. Fields set via
+// server-side applies are also included regardless of the manager name.
+var applyFieldManagers = []string{
+	"kubectl-client-side-apply",
+	dynamicFieldManager,
+}
+
+// Metadata fields that are set by the API server rather than applied.
+var serverMetadataFields = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// LiveHasher computes hashes of the live versions of resources in a cluster. These can be
+// compared over time to detect changes made outside of kubeapply.
+type LiveHasher struct {
+	kubeConfigPath string
+	dynamicClient  dynamic.Interface
+}
+
+// NewLiveHasher returns a new LiveHasher for the cluster referenced by the argument
+// kubeconfig.
+func NewLiveHasher(kubeConfigPath string) (*LiveHasher, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLiveHasher(kubeConfigPath, dynamicClient), nil
+}
+
+func newLiveHasher(kubeConfigPath string, dynamicClient dynamic.Interface) *LiveHasher {
+	return &LiveHasher{
+		kubeConfigPath: kubeConfigPath,
+		dynamicClient:  dynamicClient,
+	}
+}
+
+// Hashes fetches the live versions of the resources associated with the argument manifest
+// ids and returns a hash of the applied fields in each one. The fields in the argument ignore
+// rules are left out so that changes to them aren't treated as drift. Resources that don't
+// exist in the cluster have empty hashes. Ids that can't be parsed or that have kinds that
+// aren't supported by the cluster are omitted from the result.
+func (h *LiveHasher) Hashes(
+	ctx context.Context,
+	ids []string,
+	ignoreFields []diff.IgnoreRule,
+) (map[string]string, error) {
+	objs, err := h.getObjects(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+
+	for id, obj := range objs {
+		if obj == nil {
+			hashes[id] = ""
+			continue
+		}
+
+		hashes[id], err = ObjectHash(obj, ignoreFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+// Annotations fetches the live versions of the resources associated with the argument
+// manifest ids and returns the annotations of each one. Resources that don't exist in the
+// cluster, ids that can't be parsed, and ids with unsupported kinds are omitted from the
+// result.
+func (h *LiveHasher) Annotations(
+	ctx context.Context,
+	ids []string,
+) (map[string]map[string]string, error) {
+	objs, err := h.getObjects(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]map[string]string{}
+
+	for id, obj := range objs {
+		if obj == nil {
+			continue
+		}
+
+		annotations[id] = obj.GetAnnotations()
+		if annotations[id] == nil {
+			annotations[id] = map[string]string{}
+		}
+	}
+
+	return annotations, nil
+}
+
+// getObjects fetches the live versions of the resources associated with the argument
+// manifest ids. Resources that don't exist in the cluster have nil values in the result, and
+// ids that can't be parsed or that have unsupported kinds are omitted.
+func (h *LiveHasher) getObjects(
+	ctx context.Context,
+	ids []string,
+) (map[string]*unstructured.Unstructured, error) {
+	apiResources, err := getApiResources(h.kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	resourcesByKind := map[string]apiResource{}
+
+	for _, apiResource := range apiResources {
+		if strings.Contains(apiResource.name, "/") {
+			// Skip subresources like deployments/status
+			continue
+		}
+		resourcesByKind[fmt.Sprintf("%s.%s", apiResource.apiVersion, apiResource.kind)] =
+			apiResource
+	}
+
+	objs := map[string]*unstructured.Unstructured{}
+
+	for _, id := range ids {
+		idComponents := manifestIDToComponents(id)
+		if idComponents.name == "" {
+			log.Warnf("Could not parse id %s", id)
+			continue
+		}
+
+		apiResource, ok := resourcesByKind[fmt.Sprintf(
+			"%s.%s",
+			idComponents.api,
+			idComponents.kind,
+		)]
+		if !ok {
+			log.Warnf(
+				"Could not find resource name for kind %s; skipping",
+				idComponents.kind,
+			)
+			continue
+		}
+
+		groupVersion, err := schema.ParseGroupVersion(apiResource.apiVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		var resourceClient dynamic.ResourceInterface
+		namespaceableClient := h.dynamicClient.Resource(
+			groupVersion.WithResource(apiResource.name),
+		)
+		if apiResource.namespaced {
+			namespace := idComponents.namespace
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			resourceClient = namespaceableClient.Namespace(namespace)
+		} else {
+			resourceClient = namespaceableClient
+		}
+
+		obj, err := resourceClient.Get(ctx, idComponents.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			objs[id] = nil
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		objs[id] = obj
+	}
+
+	return objs, nil
+}
+
+// LabeledIDs returns the manifest ids of all of the resources in the cluster that match the
+// argument label selector. Resources that are served under multiple API versions are only
+// included once. Resource types that can't be listed are skipped.
+func (h *LiveHasher) LabeledIDs(
+	ctx context.Context,
+	labelSelector string,
+) ([]string, error) {
+	apiResources, err := getApiResources(h.kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	keys := map[string]struct{}{}
+
+	for _, apiResource := range apiResources {
+		if strings.Contains(apiResource.name, "/") || !contains(apiResource.verbs, "list") {
+			continue
+		}
+
+		groupVersion, err := schema.ParseGroupVersion(apiResource.apiVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		objs, err := h.dynamicClient.Resource(
+			groupVersion.WithResource(apiResource.name),
+		).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		if err != nil {
+			log.Warnf("Could not list %s; skipping: %+v", apiResource.name, err)
+			continue
+		}
+
+		for _, obj := range objs.Items {
+			id := fmt.Sprintf(
+				"%s.%s.%s.%s",
+				apiResource.apiVersion,
+				apiResource.kind,
+				obj.GetNamespace(),
+				obj.GetName(),
+			)
+			key := ObjectKey(id)
+			if _, ok := keys[key]; ok {
+				continue
+			}
+
+			keys[key] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ObjectHash returns a stable hash of the fields in the argument object that were set by
+// applies. These are determined from the object's managed fields; if there are none for any
+// of the apply managers, then the hash covers everything except the status and the
+// server-generated metadata. The fields in the argument ignore rules are stripped from the
+// object first, the same as in diffs.
+func ObjectHash(
+	obj *unstructured.Unstructured,
+	ignoreFields []diff.IgnoreRule,
+) (string, error) {
+	if len(ignoreFields) > 0 {
+		obj = obj.DeepCopy()
+		diff.StripFields(obj.Object, ignoreFields)
+	}
+
+	managedFields, err := appliedFieldSet(obj)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+
+	if len(managedFields) > 0 {
+		if err := getFieldLines(obj.Object, managedFields, "", &lines); err != nil {
+			return "", err
+		}
+	} else {
+		contents := obj.DeepCopy().Object
+		delete(contents, "status")
+		contents["metadata"] = map[string]interface{}{
+			"labels":      obj.GetLabels(),
+			"annotations": obj.GetAnnotations(),
+		}
+
+		value, err := json.Marshal(contents)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, string(value))
+	}
+
+	sort.Strings(lines)
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(lines, "\n")))), nil
+}
+
+// AppliedObject returns a copy of the argument live object that only contains the fields
+// owned by the apply field managers, along with the fields that identify the object. Objects
+// without managed fields are returned without their status and server-generated metadata.
+func AppliedObject(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	managedFields, err := appliedFieldSet(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(managedFields) == 0 {
+		return WithoutServerFields(obj), nil
+	}
+
+	contents, _ := filterFields(obj.Object, managedFields).(map[string]interface{})
+	if contents == nil {
+		contents = map[string]interface{}{}
+	}
+	appliedObj := &unstructured.Unstructured{Object: contents}
+	appliedObj.SetAPIVersion(obj.GetAPIVersion())
+	appliedObj.SetKind(obj.GetKind())
+	appliedObj.SetName(obj.GetName())
+	appliedObj.SetNamespace(obj.GetNamespace())
+
+	return appliedObj, nil
+}
+
+// WithoutServerFields returns a copy of the argument live object without the status and
+// server-generated metadata, so that it can be re-applied.
+func WithoutServerFields(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	result := obj.DeepCopy()
+	unstructured.RemoveNestedField(result.Object, "status")
+
+	for _, field := range serverMetadataFields {
+		unstructured.RemoveNestedField(result.Object, "metadata", field)
+	}
+
+	return result
+}
+
+// appliedFieldSet returns the union of the fields, in the FieldsV1 format, that are owned by
+// the apply field managers in the argument object.
+func appliedFieldSet(obj *unstructured.Unstructured) (map[string]interface{}, error) {
+	managedFields := map[string]interface{}{}
+
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Operation != metav1.ManagedFieldsOperationApply &&
+			!contains(applyFieldManagers, entry.Manager) {
+			continue
+		}
+		if entry.FieldsV1 == nil {
+			continue
+		}
+
+		fieldSet := map[string]interface{}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fieldSet); err != nil {
+			return nil, fmt.Errorf(
+				"Could not parse managed fields for %s: %+v",
+				obj.GetName(),
+				err,
+			)
+		}
+		mergeFieldSets(managedFields, fieldSet)
+	}
+
+	return managedFields, nil
+}
+
+// filterFields returns a copy of the argument value that only contains the fields in the
+// argument set, which is in the FieldsV1 format. Leaf fields are copied in full.
+func filterFields(value interface{}, fieldSet map[string]interface{}) interface{} {
+	hasChildren := false
+	for key := range fieldSet {
+		if key != "." {
+			hasChildren = true
+			break
+		}
+	}
+	if !hasChildren {
+		return runtime.DeepCopyJSONValue(value)
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, childSet := range fieldSet {
+			if !strings.HasPrefix(key, "f:") {
+				continue
+			}
+			childValue, ok := typedValue[key[2:]]
+			if !ok {
+				continue
+			}
+			childFields, _ := childSet.(map[string]interface{})
+			result[key[2:]] = filterFields(childValue, childFields)
+		}
+		return result
+	case []interface{}:
+		// Keep the items in their original order
+		result := []interface{}{}
+		for index, item := range typedValue {
+			itemFields, ok := listItemFields(fieldSet, index, item)
+			if !ok {
+				continue
+			}
+			result = append(result, filterFields(item, itemFields))
+		}
+		return result
+	default:
+		return runtime.DeepCopyJSONValue(value)
+	}
+}
+
+// listItemFields returns the fields in the argument set for the argument list item, and
+// whether the item is in the set at all.
+func listItemFields(
+	fieldSet map[string]interface{},
+	index int,
+	item interface{},
+) (map[string]interface{}, bool) {
+	for key, childSet := range fieldSet {
+		childFields, _ := childSet.(map[string]interface{})
+
+		switch {
+		case strings.HasPrefix(key, "k:"):
+			keyFields := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(key[2:]), &keyFields); err != nil {
+				continue
+			}
+			if findListItem([]interface{}{item}, keyFields) != nil {
+				return childFields, true
+			}
+		case strings.HasPrefix(key, "i:"):
+			if key[2:] == strconv.Itoa(index) {
+				return childFields, true
+			}
+		case strings.HasPrefix(key, "v:"):
+			itemJSON, err := json.Marshal(item)
+			if err == nil && string(itemJSON) == key[2:] {
+				return childFields, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func mergeFieldSets(target map[string]interface{}, source map[string]interface{}) {
+	for key, value := range source {
+		sourceChild, _ := value.(map[string]interface{})
+		targetChild, ok := target[key].(map[string]interface{})
+		if !ok {
+			targetChild = map[string]interface{}{}
+			target[key] = targetChild
+		}
+		mergeFieldSets(targetChild, sourceChild)
+	}
+}
+
+// getFieldLines walks the argument value in parallel with a set of fields in the FieldsV1
+// format and adds a "path=value" line for each leaf field.
+func getFieldLines(
+	value interface{},
+	fieldSet map[string]interface{},
+	path string,
+	lines *[]string,
+) error {
+	hasChildren := false
+
+	for key, childSet := range fieldSet {
+		if key == "." {
+			continue
+		}
+		hasChildren = true
+
+		childFields, _ := childSet.(map[string]interface{})
+		childPath := fmt.Sprintf("%s/%s", path, key)
+
+		switch {
+		case strings.HasPrefix(key, "f:"):
+			valueMap, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			childValue, ok := valueMap[key[2:]]
+			if !ok {
+				*lines = append(*lines, fmt.Sprintf("%s=<missing>", childPath))
+				continue
+			}
+			if err := getFieldLines(childValue, childFields, childPath, lines); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "k:"):
+			keyFields := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(key[2:]), &keyFields); err != nil {
+				return err
+			}
+			childValue := findListItem(value, keyFields)
+			if childValue == nil {
+				*lines = append(*lines, fmt.Sprintf("%s=<missing>", childPath))
+				continue
+			}
+			if err := getFieldLines(childValue, childFields, childPath, lines); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "i:"):
+			index, err := strconv.Atoi(key[2:])
+			if err != nil {
+				return err
+			}
+			valueList, _ := value.([]interface{})
+			if index >= len(valueList) {
+				*lines = append(*lines, fmt.Sprintf("%s=<missing>", childPath))
+				continue
+			}
+			if err := getFieldLines(valueList[index], childFields, childPath, lines); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "v:"):
+			// Set members are encoded in the key itself, so check that they're still present
+			valueList, _ := value.([]interface{})
+			present := false
+			for _, item := range valueList {
+				itemJSON, err := json.Marshal(item)
+				if err != nil {
+					return err
+				}
+				if string(itemJSON) == key[2:] {
+					present = true
+					break
+				}
+			}
+			*lines = append(*lines, fmt.Sprintf("%s=%t", childPath, present))
+		}
+	}
+
+	if !hasChildren {
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		*lines = append(*lines, fmt.Sprintf("%s=%s", path, string(valueJSON)))
+	}
+
+	return nil
+}
+
+// findListItem returns the item in the argument list whose fields match the argument keys,
+// or nil if there's no match.
+func findListItem(value interface{}, keyFields map[string]interface{}) interface{} {
+	valueList, _ := value.([]interface{})
+
+	for _, item := range valueList {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		matches := true
+		for key, keyValue := range keyFields {
+			if fmt.Sprintf("%v", itemMap[key]) != fmt.Sprintf("%v", keyValue) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return item
+		}
+	}
+
+	return nil
+}
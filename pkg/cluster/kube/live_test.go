@@ -0,0 +1,381 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestObjectHash(t *testing.T) {
+	obj := testManagedDeployment()
+	hash, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+
+	// Changes to unmanaged fields don't affect the hash
+	obj = testManagedDeployment()
+	unstructured.SetNestedField(obj.Object, int64(5), "spec", "revisionHistoryLimit")
+	unstructured.SetNestedField(obj.Object, int64(2), "status", "replicas")
+	unmanagedHash, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+	assert.Equal(t, hash, unmanagedHash)
+
+	// Changes to managed fields do
+	obj = testManagedDeployment()
+	unstructured.SetNestedField(obj.Object, int64(3), "spec", "replicas")
+	managedHash, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, managedHash)
+
+	obj = testManagedDeployment()
+	containers, _, _ := unstructured.NestedSlice(
+		obj.Object,
+		"spec",
+		"template",
+		"spec",
+		"containers",
+	)
+	containers[0].(map[string]interface{})["image"] = "nginx:2"
+	unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers")
+	containerHash, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, containerHash)
+
+	// Objects without managed fields are hashed in full
+	obj = testManagedDeployment()
+	obj.SetManagedFields(nil)
+	fullHash, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+
+	unstructured.SetNestedField(obj.Object, int64(5), "spec", "revisionHistoryLimit")
+	fullHash2, err := ObjectHash(obj, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, fullHash, fullHash2)
+}
+
+func TestObjectHashIgnoreFields(t *testing.T) {
+	ignoreFields := []diff.IgnoreRule{
+		{
+			Kind:  "Deployment",
+			Paths: []string{"spec.replicas"},
+		},
+	}
+
+	obj := testManagedDeployment()
+	hash, err := ObjectHash(obj, ignoreFields)
+	require.NoError(t, err)
+
+	// Changes to ignored fields, e.g. by an autoscaler, don't affect the hash
+	obj = testManagedDeployment()
+	unstructured.SetNestedField(obj.Object, int64(10), "spec", "replicas")
+	ignoredHash, err := ObjectHash(obj, ignoreFields)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ignoredHash)
+
+	// The argument object isn't modified
+	replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	assert.Equal(t, int64(10), replicas)
+
+	// Changes to other fields still do
+	obj = testManagedDeployment()
+	unstructured.SetNestedField(obj.Object, int64(10), "spec", "replicas")
+	containers, _, _ := unstructured.NestedSlice(
+		obj.Object,
+		"spec",
+		"template",
+		"spec",
+		"containers",
+	)
+	containers[0].(map[string]interface{})["image"] = "nginx:2"
+	unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers")
+	containerHash, err := ObjectHash(obj, ignoreFields)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, containerHash)
+
+	// The same applies to objects without managed fields
+	obj = testManagedDeployment()
+	obj.SetManagedFields(nil)
+	fullHash, err := ObjectHash(obj, ignoreFields)
+	require.NoError(t, err)
+
+	unstructured.SetNestedField(obj.Object, int64(10), "spec", "replicas")
+	fullHash2, err := ObjectHash(obj, ignoreFields)
+	require.NoError(t, err)
+	assert.Equal(t, fullHash, fullHash2)
+}
+
+func TestAppliedObject(t *testing.T) {
+	obj := testManagedDeployment()
+	containers, _, _ := unstructured.NestedSlice(
+		obj.Object,
+		"spec",
+		"template",
+		"spec",
+		"containers",
+	)
+	containers = append(
+		containers,
+		map[string]interface{}{
+			"name":  "injected-sidecar",
+			"image": "sidecar:1",
+		},
+	)
+	unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers")
+	obj.SetResourceVersion("10")
+	unstructured.SetNestedField(obj.Object, int64(1), "status", "replicas")
+
+	appliedObj, err := AppliedObject(obj)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "testName",
+				"namespace": "testNamespace",
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(1),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name":  "nginx",
+								"image": "nginx:1",
+							},
+						},
+					},
+				},
+			},
+		},
+		appliedObj.Object,
+	)
+
+	// Objects without managed fields are kept in full
+	obj.SetManagedFields(nil)
+	appliedObj, err = AppliedObject(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "", appliedObj.GetResourceVersion())
+	assert.Nil(t, appliedObj.Object["status"])
+	limit, _, _ := unstructured.NestedInt64(appliedObj.Object, "spec", "revisionHistoryLimit")
+	assert.Equal(t, int64(10), limit)
+}
+
+func TestLiveHasherHashes(t *testing.T) {
+	ctx := context.Background()
+
+	apiResourceLoader = func(kubeConfigPath string) ([]*v1.APIResourceList, error) {
+		return []*v1.APIResourceList{
+			{
+				GroupVersion: "apps/v1",
+				APIResources: []v1.APIResource{
+					{Name: "deployments", Namespaced: true, Kind: "Deployment"},
+					{Name: "deployments/status", Namespaced: true, Kind: "Deployment"},
+				},
+			},
+		}, nil
+	}
+	defer func() {
+		apiResourceLoader = loadApiResourcesFromCluster
+	}()
+
+	hasher := newLiveHasher(
+		"/path/to/fake/kubeconfig.yaml",
+		dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), testManagedDeployment()),
+	)
+	hashes, err := hasher.Hashes(
+		ctx,
+		[]string{
+			"apps/v1.Deployment.testNamespace.testName",
+			"apps/v1.Deployment.testNamespace.otherName",
+			"v1.UnknownKind.testNamespace.testName",
+			"bad id",
+		},
+		nil,
+	)
+	require.NoError(t, err)
+
+	expectedHash, err := ObjectHash(testManagedDeployment(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		map[string]string{
+			"apps/v1.Deployment.testNamespace.testName":  expectedHash,
+			"apps/v1.Deployment.testNamespace.otherName": "",
+		},
+		hashes,
+	)
+}
+
+func TestLiveHasherAnnotations(t *testing.T) {
+	ctx := context.Background()
+
+	apiResourceLoader = func(kubeConfigPath string) ([]*v1.APIResourceList, error) {
+		return []*v1.APIResourceList{
+			{
+				GroupVersion: "apps/v1",
+				APIResources: []v1.APIResource{
+					{Name: "deployments", Namespaced: true, Kind: "Deployment"},
+				},
+			},
+		}, nil
+	}
+	defer func() {
+		apiResourceLoader = loadApiResourcesFromCluster
+	}()
+
+	annotatedObj := testManagedDeployment()
+	annotatedObj.SetAnnotations(map[string]string{"key": "value"})
+	otherObj := testManagedDeployment()
+	otherObj.SetName("otherName")
+
+	hasher := newLiveHasher(
+		"/path/to/fake/kubeconfig.yaml",
+		dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), annotatedObj, otherObj),
+	)
+	annotations, err := hasher.Annotations(
+		ctx,
+		[]string{
+			"apps/v1.Deployment.testNamespace.testName",
+			"apps/v1.Deployment.testNamespace.otherName",
+			"apps/v1.Deployment.testNamespace.missingName",
+			"v1.UnknownKind.testNamespace.testName",
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]map[string]string{
+			"apps/v1.Deployment.testNamespace.testName":  {"key": "value"},
+			"apps/v1.Deployment.testNamespace.otherName": {},
+		},
+		annotations,
+	)
+}
+
+func TestLiveHasherLabeledIDs(t *testing.T) {
+	ctx := context.Background()
+
+	apiResourceLoader = func(kubeConfigPath string) ([]*v1.APIResourceList, error) {
+		return []*v1.APIResourceList{
+			{
+				GroupVersion: "apps/v1",
+				APIResources: []v1.APIResource{
+					{
+						Name:       "deployments",
+						Namespaced: true,
+						Kind:       "Deployment",
+						Verbs:      []string{"get", "list"},
+					},
+					{
+						Name:       "deployments/status",
+						Namespaced: true,
+						Kind:       "Deployment",
+						Verbs:      []string{"get", "list"},
+					},
+				},
+			},
+			{
+				GroupVersion: "apps/v1beta1",
+				APIResources: []v1.APIResource{
+					{
+						Name:       "deployments",
+						Namespaced: true,
+						Kind:       "Deployment",
+						Verbs:      []string{"get", "list"},
+					},
+				},
+			},
+			{
+				GroupVersion: "v1",
+				APIResources: []v1.APIResource{
+					{
+						Name:       "bindings",
+						Namespaced: true,
+						Kind:       "Binding",
+						Verbs:      []string{"create"},
+					},
+				},
+			},
+		}, nil
+	}
+	defer func() {
+		apiResourceLoader = loadApiResourcesFromCluster
+	}()
+
+	labeledObj := testManagedDeployment()
+	labeledObj.SetLabels(map[string]string{"owner": "test"})
+	otherObj := testManagedDeployment()
+	otherObj.SetName("otherName")
+
+	hasher := newLiveHasher(
+		"/path/to/fake/kubeconfig.yaml",
+		dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+			runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{
+				{Group: "apps", Version: "v1", Resource: "deployments"}:      "DeploymentList",
+				{Group: "apps", Version: "v1beta1", Resource: "deployments"}: "DeploymentList",
+			},
+			labeledObj,
+			otherObj,
+		),
+	)
+	ids, err := hasher.LabeledIDs(ctx, "owner=test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apps/v1.Deployment.testNamespace.testName"}, ids)
+}
+
+func testManagedDeployment() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "testName",
+				"namespace": "testNamespace",
+			},
+			"spec": map[string]interface{}{
+				"replicas":             int64(1),
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name":  "nginx",
+								"image": "nginx:1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	obj.SetManagedFields(
+		[]v1.ManagedFieldsEntry{
+			{
+				Manager:   "kubectl-client-side-apply",
+				Operation: v1.ManagedFieldsOperationUpdate,
+				FieldsV1: &v1.FieldsV1{
+					Raw: []byte(`{"f:spec":{"f:replicas":{},"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"nginx\"}":{".":{},"f:image":{},"f:name":{}}}}}}}`),
+				},
+			},
+			{
+				Manager:   "kube-controller-manager",
+				Operation: v1.ManagedFieldsOperationUpdate,
+				FieldsV1: &v1.FieldsV1{
+					Raw: []byte(`{"f:spec":{"f:revisionHistoryLimit":{}}}`),
+				},
+			},
+		},
+	)
+	return obj
+}
@@ -268,3 +268,37 @@ func manifestIDToComponents(id string) idComponents {
 		name:      components[2],
 	}
 }
+
+// ParseManifestID splits the argument manifest id into its api version, kind, namespace, and
+// name. The namespace is empty for cluster-scoped resources.
+func ParseManifestID(id string) (string, string, string, string, error) {
+	components := manifestIDToComponents(id)
+	if components.name == "" {
+		return "", "", "", "", fmt.Errorf("Could not parse manifest id %s", id)
+	}
+
+	return components.api, components.kind, components.namespace, components.name, nil
+}
+
+// ObjectKey returns a key for the object referenced by the argument manifest id that doesn't
+// depend on the version in its api, since the same object can be served under multiple
+// versions. Ids that can't be parsed are returned as-is.
+func ObjectKey(id string) string {
+	components := manifestIDToComponents(id)
+	if components.name == "" {
+		return id
+	}
+
+	group := ""
+	if slashIndex := strings.Index(components.api, "/"); slashIndex > 0 {
+		group = components.api[:slashIndex]
+	}
+
+	return fmt.Sprintf(
+		"%s.%s.%s.%s",
+		group,
+		components.kind,
+		components.namespace,
+		components.name,
+	)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -156,3 +157,36 @@ func TestManifestIDToComponents(t *testing.T) {
 		manifestIDToComponents("bad id"),
 	)
 }
+
+func TestParseManifestID(t *testing.T) {
+	apiVersion, kind, namespace, name, err := ParseManifestID(
+		"apps/v1.Deployment.argo-rollouts.argo-rollouts",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "apps/v1", apiVersion)
+	assert.Equal(t, "Deployment", kind)
+	assert.Equal(t, "argo-rollouts", namespace)
+	assert.Equal(t, "argo-rollouts", name)
+
+	_, _, _, _, err = ParseManifestID("v1.Service.argo-rollouts")
+	assert.Error(t, err)
+}
+
+func TestObjectKey(t *testing.T) {
+	assert.Equal(
+		t,
+		ObjectKey("apps/v1.Deployment.argo-rollouts.argo-rollouts"),
+		ObjectKey("apps/v1beta1.Deployment.argo-rollouts.argo-rollouts"),
+	)
+	assert.NotEqual(
+		t,
+		ObjectKey("apps/v1.Deployment.argo-rollouts.argo-rollouts"),
+		ObjectKey("extensions/v1beta1.Deployment.argo-rollouts.argo-rollouts"),
+	)
+	assert.Equal(
+		t,
+		".Service.argo-rollouts.argo-rollouts-metrics",
+		ObjectKey("v1.Service.argo-rollouts.argo-rollouts-metrics"),
+	)
+	assert.Equal(t, "bad id", ObjectKey("bad id"))
+}
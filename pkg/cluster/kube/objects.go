@@ -0,0 +1,79 @@
+package kube
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// NewRESTMapper returns a mapper from kinds to API resources that's backed by the discovery
+// API of the cluster referenced in the argument config.
+func NewRESTMapper(restConfig *rest.Config) (meta.RESTMapper, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The deferred mapper resets its cache whenever it can't find a kind, so custom
+	// resources are picked up even if their CRDs are applied after the mapper is created.
+	return restmapper.NewDeferredDiscoveryRESTMapper(
+		memory.NewMemCacheClient(discoveryClient),
+	), nil
+}
+
+// ResourceClient returns a dynamic client for the API resource associated with the argument
+// kind. The namespace is ignored for cluster-scoped resources.
+func ResourceClient(
+	dynamicClient dynamic.Interface,
+	mapper meta.RESTMapper,
+	gvk schema.GroupVersionKind,
+	namespace string,
+) (dynamic.ResourceInterface, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		return dynamicClient.Resource(mapping.Resource).Namespace(namespace), nil
+	}
+
+	return dynamicClient.Resource(mapping.Resource), nil
+}
+
+// GetObject gets the live version of an object from the cluster. It returns nil if the
+// object does not exist.
+func GetObject(
+	ctx context.Context,
+	dynamicClient dynamic.Interface,
+	mapper meta.RESTMapper,
+	gvk schema.GroupVersionKind,
+	namespace string,
+	name string,
+) (*unstructured.Unstructured, error) {
+	resourceClient, err := ResourceClient(dynamicClient, mapper, gvk, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := resourceClient.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
@@ -4,15 +4,22 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
@@ -30,7 +37,6 @@ type OrderedClient struct {
 	keepConfigs    bool
 	extraEnv       []string
 	debug          bool
-	serverSide     bool
 }
 
 // NewOrderedClient returns a new OrderedClient instance.
@@ -39,25 +45,25 @@ func NewOrderedClient(
 	keepConfigs bool,
 	extraEnv []string,
 	debug bool,
-	serverSide bool,
 ) *OrderedClient {
 	return &OrderedClient{
 		kubeConfigPath: kubeConfigPath,
 		keepConfigs:    keepConfigs,
 		extraEnv:       extraEnv,
 		debug:          debug,
-		serverSide:     serverSide,
 	}
 }
 
 // Apply runs kubectl apply on the manifests in the argument path. The apply is done
-// in the optimal order based on resource type.
+// in the optimal order based on resource type. If the apply fails because of server-side
+// apply conflicts, then the returned error is an *ApplyConflictsError.
 func (k *OrderedClient) Apply(
 	ctx context.Context,
 	applyPaths []string,
 	output bool,
 	format string,
 	dryRun bool,
+	serverSide ServerSideOptions,
 ) ([]byte, error) {
 	tempDir, err := ioutil.TempDir("", "kubeapply_manifests_")
 	if err != nil {
@@ -114,9 +120,7 @@ func (k *OrderedClient) Apply(
 		"-f",
 		tempDir,
 	}
-	if k.serverSide {
-		args = append(args, "--server-side", "true")
-	}
+	args = append(args, serverSide.kubectlArgs()...)
 	if k.debug {
 		args = append(args, "-v", "8")
 	}
@@ -124,15 +128,26 @@ func (k *OrderedClient) Apply(
 		args = append(args, "-o", format)
 	}
 	if dryRun {
-		args = append(args, "--dry-run")
+		// Client-side dry runs aren't supported for server-side applies
+		if serverSide.Enabled {
+			args = append(args, "--dry-run=server")
+		} else {
+			args = append(args, "--dry-run=client")
+		}
 	}
 
 	if output {
-		return runKubectlOutput(
+		results, err := runKubectlOutput(
 			ctx,
 			args,
 			k.extraEnv,
 		)
+		if err != nil && serverSide.Enabled {
+			if conflictsErr := parseKubectlConflicts(results); conflictsErr != nil {
+				return results, conflictsErr
+			}
+		}
+		return results, err
 	}
 	return nil, runKubectl(
 		ctx,
@@ -141,11 +156,14 @@ func (k *OrderedClient) Apply(
 	)
 }
 
-// Diff runs kubectl diff for the configs at the argument path.
+// Diff runs kubectl diff for the configs at the argument path. For structured diffs, the
+// argument ignore rules are passed through to kadiff.
 func (k *OrderedClient) Diff(
 	ctx context.Context,
 	configPaths []string,
 	structured bool,
+	serverSide ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
 ) ([]byte, error) {
 	var diffCmd string
 
@@ -172,9 +190,7 @@ func (k *OrderedClient) Diff(
 		args = append(args, "-f", configPath)
 	}
 
-	if k.serverSide {
-		args = append(args, "--server-side", "true")
-	}
+	args = append(args, serverSide.kubectlArgs()...)
 	if k.debug {
 		args = append(args, "-v", "8")
 	}
@@ -208,6 +224,17 @@ func (k *OrderedClient) Diff(
 		envVars = append(envVars, extraEnv)
 	}
 
+	if structured && len(ignoreFields) > 0 {
+		ignoreFieldsBytes, err := json.Marshal(ignoreFields)
+		if err != nil {
+			return nil, err
+		}
+		envVars = append(
+			envVars,
+			fmt.Sprintf("KADIFF_IGNORE_FIELDS=%s", string(ignoreFieldsBytes)),
+		)
+	}
+
 	return runKubectlOutput(
 		ctx,
 		args,
@@ -222,14 +249,18 @@ type deleteResource struct {
 }
 
 // Delete runs kubectl delete for the configs at the argument path for
-// manifests that match the provided ids.
+// manifests that match the provided ids. The deletes are done in the reverse of the apply
+// order. If the argument options have a wait timeout, then it waits for the resources to be
+// removed and returns a *DeleteTimeoutError if any are still there after the timeout.
 func (k *OrderedClient) Delete(
 	ctx context.Context,
 	ids []string,
+	options DeleteOptions,
 ) ([]byte, error) {
 	toDelete := []idComponents{}
+	sortedIDs := SortIDsForDelete(ids)
 
-	for _, id := range ids {
+	for _, id := range sortedIDs {
 		idComponents := manifestIDToComponents(id)
 		if idComponents.name == "" {
 			log.Warnf("Could not parse id %s", id)
@@ -241,16 +272,33 @@ func (k *OrderedClient) Delete(
 	if err != nil {
 		return nil, err
 	}
-	resourceNames := map[string]string{}
+	resourcesByKind := map[string]apiResource{}
 
 	for _, apiResource := range apiResources {
-		resourceNames[apiResource.kind] = apiResource.name
+		if strings.Contains(apiResource.name, "/") {
+			// Skip subresources like deployments/status
+			continue
+		}
+		resourcesByKind[apiResource.kind] = apiResource
 	}
 
 	allResults := [][]byte{}
+	deleted := []deletedResource{}
+
+	var dynamicClient dynamic.Interface
+	if options.WaitTimeout > 0 {
+		restConfig, err := clientcmd.BuildConfigFromFlags("", k.kubeConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for _, idComponents := range toDelete {
-		resourceName, ok := resourceNames[idComponents.kind]
+	for i, idComponents := range toDelete {
+		apiResource, ok := resourcesByKind[idComponents.kind]
 		if !ok {
 			log.Warnf(
 				"Could not find resource name for kind %s; skipping delete",
@@ -265,7 +313,7 @@ func (k *OrderedClient) Delete(
 			"--ignore-not-found=true",
 			"--wait=false",
 			"delete",
-			resourceName,
+			apiResource.name,
 			idComponents.name,
 		}
 		if idComponents.namespace != "" {
@@ -285,6 +333,42 @@ func (k *OrderedClient) Delete(
 			return results, err
 		}
 		allResults = append(allResults, bytes.TrimSpace(results))
+
+		if dynamicClient != nil {
+			groupVersion, err := schema.ParseGroupVersion(idComponents.api)
+			if err != nil {
+				return bytes.Join(allResults, []byte("\n")), err
+			}
+
+			var resourceClient dynamic.ResourceInterface
+			namespaceableClient := dynamicClient.Resource(
+				groupVersion.WithResource(apiResource.name),
+			)
+			if apiResource.namespaced {
+				namespace := idComponents.namespace
+				if namespace == "" {
+					namespace = metav1.NamespaceDefault
+				}
+				resourceClient = namespaceableClient.Namespace(namespace)
+			} else {
+				resourceClient = namespaceableClient
+			}
+
+			deleted = append(
+				deleted,
+				deletedResource{
+					id:     sortedIDs[i],
+					name:   idComponents.name,
+					client: resourceClient,
+				},
+			)
+		}
+	}
+
+	if len(deleted) > 0 {
+		if err := waitForDeletes(ctx, deleted, options.WaitTimeout); err != nil {
+			return bytes.Join(allResults, []byte("\n")), err
+		}
 	}
 
 	return bytes.Join(allResults, []byte("\n")), nil
@@ -1,6 +1,10 @@
 package kube
 
 import (
+	"fmt"
+	"path"
+	"strings"
+
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -12,6 +16,7 @@ type apiResource struct {
 	apiVersion string
 	namespaced bool
 	kind       string
+	verbs      []string
 }
 
 var apiResourceLoader = loadApiResourcesFromCluster
@@ -38,13 +43,14 @@ func getApiResources(kubeConfigPath string) ([]apiResource, error) {
 	outputResources := []apiResource{}
 	for _, l := range resourceLists {
 		for _, r := range l.APIResources {
-			if r.Name != "" && l.APIVersion != "" && r.Kind != "" {
+			if r.Name != "" && l.GroupVersion != "" && r.Kind != "" {
 				outputResources = append(outputResources, apiResource{
 					name:       r.Name,
 					shortNames: r.ShortNames,
-					apiVersion: l.APIVersion,
+					apiVersion: l.GroupVersion,
 					namespaced: r.Namespaced,
 					kind:       r.Kind,
+					verbs:      r.Verbs,
 				})
 			}
 
@@ -52,3 +58,54 @@ func getApiResources(kubeConfigPath string) ([]apiResource, error) {
 	}
 	return outputResources, nil
 }
+
+// ResourcePath returns the API path of the resource with the argument apiVersion, kind,
+// namespace, and name. The resource type is looked up via the discovery API of the cluster in
+// the argument kubeconfig. The namespace is ignored for cluster-scoped kinds and defaults to
+// "default" for namespaced ones.
+func ResourcePath(
+	kubeConfigPath string,
+	apiVersion string,
+	kind string,
+	namespace string,
+	name string,
+) (string, error) {
+	apiResources, err := getApiResources(kubeConfigPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, apiResource := range apiResources {
+		if strings.Contains(apiResource.name, "/") {
+			// Skip subresources like deployments/status
+			continue
+		}
+		if apiResource.apiVersion != apiVersion || apiResource.kind != kind {
+			continue
+		}
+
+		apiPath := "/apis"
+		if !strings.Contains(apiVersion, "/") {
+			// Resources in the core group don't have a group in their paths
+			apiPath = "/api"
+		}
+
+		if !apiResource.namespaced {
+			return path.Join(apiPath, apiVersion, apiResource.name, name), nil
+		}
+
+		if namespace == "" {
+			namespace = v1.NamespaceDefault
+		}
+		return path.Join(
+			apiPath,
+			apiVersion,
+			"namespaces",
+			namespace,
+			apiResource.name,
+			name,
+		), nil
+	}
+
+	return "", fmt.Errorf("Could not find resource type for %s %s in cluster", apiVersion, kind)
+}
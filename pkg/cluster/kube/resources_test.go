@@ -16,7 +16,6 @@ configmaps          cm,cmx       v1           true         ConfigMap
 `
 
 func TestGetApiResources(t *testing.T) {
-
 	apiResourceLoader = func(kubeConfigPath string) ([]*v1.APIResourceList, error) {
 		return []*v1.APIResourceList{
 			{
@@ -42,11 +41,88 @@ func TestGetApiResources(t *testing.T) {
 			},
 		}, nil
 	}
+	defer func() {
+		apiResourceLoader = loadApiResourcesFromCluster
+	}()
+
 	resources, err := getApiResources("/path/to/fake/kubeconfig.yaml")
 	require.NoError(t, err)
 	assert.Equal(t, []apiResource{
-		{name: "deployments", shortNames: []string{"deploy"}, apiVersion: "apps/v1", namespaced: true, kind: "Deployment"},
-		{name: "daemonsets", shortNames: []string{"ds"}, apiVersion: "apps/v1", namespaced: true, kind: "DaemonSet"},
-		{name: "jobs", apiVersion: "batch/v1", shortNames: nil, namespaced: true, kind: "Job"},
+		{name: "deployments", shortNames: []string{"deploy"}, apiVersion: "apps/v1", namespaced: true, kind: "Deployment", verbs: []string{"create", "list", "get"}},
+		{name: "daemonsets", shortNames: []string{"ds"}, apiVersion: "apps/v1", namespaced: true, kind: "DaemonSet", verbs: []string{"create", "list", "get"}},
+		{name: "jobs", apiVersion: "batch/v1", shortNames: nil, namespaced: true, kind: "Job", verbs: []string{"create", "list", "get"}},
 	}, resources)
 }
+
+func TestResourcePath(t *testing.T) {
+	apiResourceLoader = func(kubeConfigPath string) ([]*v1.APIResourceList, error) {
+		return []*v1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []v1.APIResource{
+					{Name: "namespaces", Namespaced: false, Kind: "Namespace"},
+					{Name: "services", Namespaced: true, Kind: "Service"},
+					{Name: "services/status", Namespaced: true, Kind: "Service"},
+				},
+			},
+			{
+				GroupVersion: "apps/v1",
+				APIResources: []v1.APIResource{
+					{Name: "deployments", Namespaced: true, Kind: "Deployment"},
+				},
+			},
+		}, nil
+	}
+	defer func() {
+		apiResourceLoader = loadApiResourcesFromCluster
+	}()
+
+	type testCase struct {
+		apiVersion    string
+		kind          string
+		namespace     string
+		expectedPath  string
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{
+			apiVersion:   "v1",
+			kind:         "Service",
+			namespace:    "test-namespace",
+			expectedPath: "/api/v1/namespaces/test-namespace/services/test-name",
+		},
+		{
+			apiVersion:   "v1",
+			kind:         "Namespace",
+			namespace:    "test-namespace",
+			expectedPath: "/api/v1/namespaces/test-name",
+		},
+		{
+			apiVersion:   "apps/v1",
+			kind:         "Deployment",
+			expectedPath: "/apis/apps/v1/namespaces/default/deployments/test-name",
+		},
+		{
+			apiVersion:    "apps/v1beta1",
+			kind:          "Deployment",
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		path, err := ResourcePath(
+			"/path/to/fake/kubeconfig.yaml",
+			testCase.apiVersion,
+			testCase.kind,
+			testCase.namespace,
+			"test-name",
+		)
+		if testCase.expectedError {
+			assert.Error(t, err, testCase.kind)
+		} else {
+			require.NoError(t, err, testCase.kind)
+			assert.Equal(t, testCase.expectedPath, path)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ServerSideOptions configures server-side applies and diffs.
+type ServerSideOptions struct {
+	// Enabled indicates whether applies and diffs should be done server-side.
+	Enabled bool
+
+	// FieldManager is the name of the manager used to track field ownership. If empty, the
+	// client's default is used.
+	FieldManager string
+
+	// ForceConflicts indicates whether fields owned by other managers should be taken over
+	// instead of failing the apply.
+	ForceConflicts bool
+}
+
+// kubectlArgs returns the kubectl apply and diff arguments for these options.
+func (s ServerSideOptions) kubectlArgs() []string {
+	args := []string{}
+
+	if s.Enabled {
+		args = append(args, "--server-side")
+		if s.ForceConflicts {
+			args = append(args, "--force-conflicts")
+		}
+	}
+	if s.FieldManager != "" {
+		args = append(args, fmt.Sprintf("--field-manager=%s", s.FieldManager))
+	}
+
+	return args
+}
+
+// ApplyConflict is a field ownership conflict for a single resource in a server-side apply.
+type ApplyConflict struct {
+	// Resource is a human-readable identifier for the resource, e.g.
+	// "deployment.apps/my-deployment".
+	Resource string
+
+	// Message describes the conflicting fields and their managers.
+	Message string
+}
+
+// ApplyConflictsError is returned by clients when a server-side apply fails because of
+// conflicts with other field managers.
+type ApplyConflictsError struct {
+	Conflicts []ApplyConflict
+}
+
+// Error returns a summary of all of the conflicts in this error.
+func (e *ApplyConflictsError) Error() string {
+	resources := []string{}
+	for _, conflict := range e.Conflicts {
+		resources = append(resources, conflict.Resource)
+	}
+
+	return fmt.Sprintf(
+		"Server-side apply failed with conflicts in %d resource(s): %s",
+		len(e.Conflicts),
+		strings.Join(resources, ", "),
+	)
+}
+
+var (
+	// Matches conflict errors in kubectl output, with the source file if available, e.g.
+	// `error when applying patch: ... for: "/tmp/path.yaml": Apply failed with 1 conflict: ...`
+	kubectlConflictRegexp = regexp.MustCompile(
+		`(?:for: "([^"]+)": )?(Apply failed with [0-9]+ conflicts?: .*)`,
+	)
+
+	// Matches the names of the temporary manifest files written by the OrderedClient
+	orderedFileRegexp = regexp.MustCompile(`^[0-9]+_([^_]*)_([^_]*)_([^_]+)\.yaml$`)
+)
+
+// parseKubectlConflicts extracts the apply conflicts from the output of kubectl apply. It
+// returns nil if there are none.
+func parseKubectlConflicts(output []byte) *ApplyConflictsError {
+	conflicts := []ApplyConflict{}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		matches := kubectlConflictRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		resource := "unknown resource"
+		if matches[1] != "" {
+			resource = matches[1]
+
+			fileMatches := orderedFileRegexp.FindStringSubmatch(filepath.Base(matches[1]))
+			if fileMatches != nil {
+				resource = fmt.Sprintf(
+					"%s/%s",
+					strings.ToLower(fileMatches[3]),
+					fileMatches[1],
+				)
+				if fileMatches[2] != "" {
+					resource = fmt.Sprintf("%s in namespace %s", resource, fileMatches[2])
+				}
+			}
+		}
+
+		conflicts = append(
+			conflicts,
+			ApplyConflict{
+				Resource: resource,
+				Message:  strings.TrimSpace(matches[2]),
+			},
+		)
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return &ApplyConflictsError{Conflicts: conflicts}
+}
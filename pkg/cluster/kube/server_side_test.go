@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerSideOptionsKubectlArgs(t *testing.T) {
+	assert.Equal(t, []string{}, ServerSideOptions{}.kubectlArgs())
+	assert.Equal(
+		t,
+		[]string{"--server-side", "--force-conflicts", "--field-manager=testManager"},
+		ServerSideOptions{
+			Enabled:        true,
+			FieldManager:   "testManager",
+			ForceConflicts: true,
+		}.kubectlArgs(),
+	)
+	assert.Equal(
+		t,
+		[]string{"--field-manager=testManager"},
+		ServerSideOptions{
+			FieldManager:   "testManager",
+			ForceConflicts: true,
+		}.kubectlArgs(),
+	)
+}
+
+func TestParseKubectlConflicts(t *testing.T) {
+	assert.Nil(t, parseKubectlConflicts([]byte("error: the server could not find the resource")))
+
+	conflictsErr := parseKubectlConflicts(
+		[]byte(`service/testName serverside-applied
+error when applying patch: for: "/tmp/kubeapply_manifests_123/000002_testName_testNamespace_Deployment.yaml": Apply failed with 1 conflict: conflict with "kubectl-edit" using apps/v1: .spec.replicas
+Please review the fields above--they currently have other managers.
+error: Apply failed with 2 conflicts: conflicts with "helm":
+`),
+	)
+	require.NotNil(t, conflictsErr)
+	assert.Equal(
+		t,
+		[]ApplyConflict{
+			{
+				Resource: "deployment/testName in namespace testNamespace",
+				Message:  `Apply failed with 1 conflict: conflict with "kubectl-edit" using apps/v1: .spec.replicas`,
+			},
+			{
+				Resource: "unknown resource",
+				Message:  `Apply failed with 2 conflicts: conflicts with "helm":`,
+			},
+		},
+		conflictsErr.Conflicts,
+	)
+}
@@ -0,0 +1,291 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// WaitKinds are the kinds that have explicit rollout checks in GetObjectStatus. Objects of
+// other kinds are only checked via their "Ready" status condition.
+var WaitKinds []string = []string{
+	"CustomResourceDefinition",
+	"DaemonSet",
+	"Deployment",
+	"Job",
+	"StatefulSet",
+}
+
+// observedGenerationKinds are the kinds whose controllers always set status.observedGeneration.
+var observedGenerationKinds = map[string]bool{
+	"DaemonSet":   true,
+	"Deployment":  true,
+	"StatefulSet": true,
+}
+
+// ObjectStatus summarizes the rollout status of a single object in the cluster.
+type ObjectStatus struct {
+	// Ready is set if the object has been fully rolled out.
+	Ready bool
+
+	// Failed is set if the object will not become ready without further intervention,
+	// e.g. because a job has failed or a deployment has exceeded its progress deadline.
+	Failed bool
+
+	// Message is a human-readable explanation of the status.
+	Message string
+
+	// Conditions are the conditions in the status of the object, if any.
+	Conditions []StatusCondition
+}
+
+// StatusCondition is a single condition from the status of an object.
+type StatusCondition struct {
+	Type    string
+	Status  string
+	Reason  string
+	Message string
+}
+
+// String returns a compact, human-readable representation of this condition.
+func (c StatusCondition) String() string {
+	str := fmt.Sprintf("%s=%s", c.Type, c.Status)
+	if c.Reason != "" {
+		str = fmt.Sprintf("%s (%s)", str, c.Reason)
+	}
+	if c.Message != "" {
+		str = fmt.Sprintf("%s: %s", str, c.Message)
+	}
+	return str
+}
+
+// GetObjectStatus evaluates the rollout status of the argument object. The checks for
+// workloads are adapted from the ones done by "kubectl rollout status".
+func GetObjectStatus(obj *unstructured.Unstructured) ObjectStatus {
+	status := ObjectStatus{
+		Conditions: getConditions(obj),
+	}
+
+	// The workload controllers always set an observed generation, so a missing one means that
+	// the controller hasn't seen the object yet; otherwise, e.g. a deployment scaled to zero
+	// would look ready before its new spec was rolled out.
+	generation := obj.GetGeneration()
+	observedGeneration, found, _ := unstructured.NestedInt64(
+		obj.Object,
+		"status",
+		"observedGeneration",
+	)
+	if !found && generation > 0 && observedGenerationKinds[obj.GetKind()] {
+		status.Message = "Waiting for the latest generation to be observed"
+		return status
+	}
+	if found && observedGeneration < generation {
+		status.Message = "Waiting for the latest generation to be observed"
+		return status
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		setDeploymentStatus(obj, &status)
+	case "StatefulSet":
+		setStatefulSetStatus(obj, &status)
+	case "DaemonSet":
+		setDaemonSetStatus(obj, &status)
+	case "Job":
+		setJobStatus(obj, &status)
+	case "CustomResourceDefinition":
+		setConditionStatus("Established", &status)
+	default:
+		setConditionStatus("Ready", &status)
+	}
+
+	return status
+}
+
+func setDeploymentStatus(obj *unstructured.Unstructured, status *ObjectStatus) {
+	for _, condition := range status.Conditions {
+		if condition.Type == "Progressing" && condition.Reason == "ProgressDeadlineExceeded" {
+			status.Failed = true
+			status.Message = "Deployment exceeded its progress deadline"
+			return
+		}
+	}
+
+	replicas := nestedInt64WithDefault(obj, 1, "spec", "replicas")
+	updatedReplicas := nestedInt64WithDefault(obj, 0, "status", "updatedReplicas")
+	statusReplicas := nestedInt64WithDefault(obj, 0, "status", "replicas")
+	availableReplicas := nestedInt64WithDefault(obj, 0, "status", "availableReplicas")
+
+	if updatedReplicas < replicas {
+		status.Message = fmt.Sprintf(
+			"%d out of %d new replicas have been updated",
+			updatedReplicas,
+			replicas,
+		)
+	} else if statusReplicas > updatedReplicas {
+		status.Message = fmt.Sprintf(
+			"%d old replicas are pending termination",
+			statusReplicas-updatedReplicas,
+		)
+	} else if availableReplicas < updatedReplicas {
+		status.Message = fmt.Sprintf(
+			"%d of %d updated replicas are available",
+			availableReplicas,
+			updatedReplicas,
+		)
+	} else {
+		status.Ready = true
+		status.Message = "Deployment successfully rolled out"
+	}
+}
+
+func setStatefulSetStatus(obj *unstructured.Unstructured, status *ObjectStatus) {
+	strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+	if strategy == "OnDelete" {
+		status.Ready = true
+		status.Message = "StatefulSet uses the OnDelete update strategy"
+		return
+	}
+
+	replicas := nestedInt64WithDefault(obj, 1, "spec", "replicas")
+	readyReplicas := nestedInt64WithDefault(obj, 0, "status", "readyReplicas")
+	updatedReplicas := nestedInt64WithDefault(obj, 0, "status", "updatedReplicas")
+
+	partition, hasPartition, _ := unstructured.NestedInt64(
+		obj.Object,
+		"spec",
+		"updateStrategy",
+		"rollingUpdate",
+		"partition",
+	)
+
+	currentRevision, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+	updateRevision, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+
+	if readyReplicas < replicas {
+		status.Message = fmt.Sprintf(
+			"%d of %d replicas are ready",
+			readyReplicas,
+			replicas,
+		)
+	} else if hasPartition && partition > 0 {
+		if updatedReplicas < replicas-partition {
+			status.Message = fmt.Sprintf(
+				"%d of %d partitioned replicas have been updated",
+				updatedReplicas,
+				replicas-partition,
+			)
+		} else {
+			status.Ready = true
+			status.Message = "Partitioned rollout complete"
+		}
+	} else if updateRevision != currentRevision {
+		status.Message = fmt.Sprintf(
+			"%d of %d replicas have been updated to revision %s",
+			updatedReplicas,
+			replicas,
+			updateRevision,
+		)
+	} else {
+		status.Ready = true
+		status.Message = "StatefulSet successfully rolled out"
+	}
+}
+
+func setDaemonSetStatus(obj *unstructured.Unstructured, status *ObjectStatus) {
+	strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+	if strategy == "OnDelete" {
+		status.Ready = true
+		status.Message = "DaemonSet uses the OnDelete update strategy"
+		return
+	}
+
+	desired := nestedInt64WithDefault(obj, 0, "status", "desiredNumberScheduled")
+	updated := nestedInt64WithDefault(obj, 0, "status", "updatedNumberScheduled")
+	available := nestedInt64WithDefault(obj, 0, "status", "numberAvailable")
+
+	if updated < desired {
+		status.Message = fmt.Sprintf(
+			"%d out of %d new pods have been updated",
+			updated,
+			desired,
+		)
+	} else if available < desired {
+		status.Message = fmt.Sprintf(
+			"%d of %d updated pods are available",
+			available,
+			desired,
+		)
+	} else {
+		status.Ready = true
+		status.Message = "DaemonSet successfully rolled out"
+	}
+}
+
+func setJobStatus(obj *unstructured.Unstructured, status *ObjectStatus) {
+	for _, condition := range status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+
+		switch condition.Type {
+		case "Complete":
+			status.Ready = true
+			status.Message = "Job completed"
+			return
+		case "Failed":
+			status.Failed = true
+			status.Message = "Job failed"
+			return
+		}
+	}
+
+	status.Message = "Waiting for job to complete"
+}
+
+// setConditionStatus sets the status based on a single condition type. Objects without the
+// condition are treated as not ready since their controllers may not have processed them yet.
+func setConditionStatus(conditionType string, status *ObjectStatus) {
+	for _, condition := range status.Conditions {
+		if condition.Type == conditionType {
+			status.Ready = condition.Status == "True"
+			status.Message = fmt.Sprintf("%s condition is %s", conditionType, condition.Status)
+			return
+		}
+	}
+
+	status.Message = fmt.Sprintf("Waiting for %s condition", conditionType)
+}
+
+func getConditions(obj *unstructured.Unstructured) []StatusCondition {
+	rawConditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	conditions := []StatusCondition{}
+
+	for _, rawCondition := range rawConditions {
+		conditionMap, ok := rawCondition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		condition := StatusCondition{}
+		condition.Type, _, _ = unstructured.NestedString(conditionMap, "type")
+		condition.Status, _, _ = unstructured.NestedString(conditionMap, "status")
+		condition.Reason, _, _ = unstructured.NestedString(conditionMap, "reason")
+		condition.Message, _, _ = unstructured.NestedString(conditionMap, "message")
+		conditions = append(conditions, condition)
+	}
+
+	return conditions
+}
+
+func nestedInt64WithDefault(
+	obj *unstructured.Unstructured,
+	defaultValue int64,
+	fields ...string,
+) int64 {
+	value, found, err := unstructured.NestedInt64(obj.Object, fields...)
+	if !found || err != nil {
+		return defaultValue
+	}
+	return value
+}
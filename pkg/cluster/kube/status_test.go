@@ -0,0 +1,245 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGetObjectStatus(t *testing.T) {
+	type testCase struct {
+		description     string
+		contents        string
+		expectedReady   bool
+		expectedFailed  bool
+		expectedMessage string
+	}
+
+	testCases := []testCase{
+		{
+			description: "deployment rolled out",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+  generation: 2
+spec:
+  replicas: 2
+status:
+  observedGeneration: 2
+  replicas: 2
+  updatedReplicas: 2
+  availableReplicas: 2
+`,
+			expectedReady:   true,
+			expectedMessage: "Deployment successfully rolled out",
+		},
+		{
+			description: "deployment generation not observed",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+  generation: 3
+spec:
+  replicas: 2
+status:
+  observedGeneration: 2
+  replicas: 2
+  updatedReplicas: 2
+  availableReplicas: 2
+`,
+			expectedMessage: "Waiting for the latest generation to be observed",
+		},
+		{
+			description: "scaled down deployment without observed generation",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+  generation: 1
+spec:
+  replicas: 0
+status: {}
+`,
+			expectedMessage: "Waiting for the latest generation to be observed",
+		},
+		{
+			description: "scaled down deployment rolled out",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+  generation: 2
+spec:
+  replicas: 0
+status:
+  observedGeneration: 2
+`,
+			expectedReady:   true,
+			expectedMessage: "Deployment successfully rolled out",
+		},
+		{
+			description: "deployment replicas unavailable",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  replicas: 3
+status:
+  replicas: 3
+  updatedReplicas: 3
+  availableReplicas: 1
+`,
+			expectedMessage: "1 of 3 updated replicas are available",
+		},
+		{
+			description: "deployment past progress deadline",
+			contents: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+spec:
+  replicas: 3
+status:
+  replicas: 3
+  updatedReplicas: 1
+  conditions:
+  - type: Progressing
+    status: "False"
+    reason: ProgressDeadlineExceeded
+`,
+			expectedFailed:  true,
+			expectedMessage: "Deployment exceeded its progress deadline",
+		},
+		{
+			description: "statefulset updating",
+			contents: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: test
+spec:
+  replicas: 2
+status:
+  readyReplicas: 2
+  updatedReplicas: 1
+  currentRevision: rev1
+  updateRevision: rev2
+`,
+			expectedMessage: "1 of 2 replicas have been updated to revision rev2",
+		},
+		{
+			description: "daemonset rolled out",
+			contents: `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: test
+status:
+  desiredNumberScheduled: 4
+  updatedNumberScheduled: 4
+  numberAvailable: 4
+`,
+			expectedReady:   true,
+			expectedMessage: "DaemonSet successfully rolled out",
+		},
+		{
+			description: "job failed",
+			contents: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: test
+status:
+  conditions:
+  - type: Failed
+    status: "True"
+    reason: BackoffLimitExceeded
+`,
+			expectedFailed:  true,
+			expectedMessage: "Job failed",
+		},
+		{
+			description: "crd not established",
+			contents: `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: test
+status:
+  conditions:
+  - type: Established
+    status: "False"
+`,
+			expectedMessage: "Established condition is False",
+		},
+		{
+			description: "custom resource without status",
+			contents: `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: test
+  generation: 1
+`,
+			expectedMessage: "Waiting for Ready condition",
+		},
+		{
+			description: "custom resource with lagging status",
+			contents: `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: test
+  generation: 2
+status:
+  observedGeneration: 1
+  conditions:
+  - type: Ready
+    status: "True"
+`,
+			expectedMessage: "Waiting for the latest generation to be observed",
+		},
+		{
+			description: "custom resource ready",
+			contents: `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: test
+  generation: 2
+status:
+  observedGeneration: 2
+  conditions:
+  - type: Ready
+    status: "True"
+`,
+			expectedReady:   true,
+			expectedMessage: "Ready condition is True",
+		},
+	}
+
+	for _, testCase := range testCases {
+		jsonContents, err := yaml.YAMLToJSON([]byte(testCase.contents))
+		require.NoError(t, err, testCase.description)
+
+		obj := &unstructured.Unstructured{}
+		require.NoError(t, obj.UnmarshalJSON(jsonContents), testCase.description)
+
+		status := GetObjectStatus(obj)
+		assert.Equal(t, testCase.expectedReady, status.Ready, testCase.description)
+		assert.Equal(t, testCase.expectedFailed, status.Failed, testCase.description)
+		assert.Equal(t, testCase.expectedMessage, status.Message, testCase.description)
+	}
+}
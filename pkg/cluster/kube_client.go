@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
 	log "github.com/sirupsen/logrus"
@@ -21,6 +22,7 @@ type KubeClient struct {
 	clusterConfig  *Config
 	kubeConfigPath string
 	kubeClient     *kube.OrderedClient
+	liveHasher     *kube.LiveHasher
 }
 
 // kubeapplyDiffEvent is used for storing the last successful diff in the kubeStore.
@@ -51,9 +53,13 @@ func NewKubeClient(
 		config.KeepConfigs,
 		config.ExtraEnv,
 		config.Debug,
-		config.Config.ServerSideApply,
 	)
 
+	liveHasher, err := kube.NewLiveHasher(kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
 	hostName, err := os.Hostname()
 	if err != nil {
 		log.Warnf("Error getting hostname, using generic string: %s", hostName)
@@ -64,6 +70,7 @@ func NewKubeClient(
 		clusterConfig:  config.Config,
 		kubeConfigPath: kubeConfigPath,
 		kubeClient:     kubeClient,
+		liveHasher:     liveHasher,
 	}, nil
 }
 
@@ -71,17 +78,47 @@ func NewKubeClient(
 func (cc *KubeClient) Apply(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
 ) ([]byte, error) {
-	return cc.execApply(ctx, paths, "", false)
+	return cc.execApply(ctx, paths, "", false, serverSide)
+}
+
+// ApplyStructured does a kubectl apply for the resources at the argument path and returns
+// structured results. The results are generated by comparing the outputs of a dry-run apply
+// and a real one.
+func (cc *KubeClient) ApplyStructured(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+) ([]apply.Result, error) {
+	oldContents, err := cc.execApply(ctx, paths, "json", true, serverSide)
+	if err != nil {
+		return nil, structuredApplyErr(err, oldContents)
+	}
+	oldObjs, err := apply.KubeJSONToObjects(oldContents)
+	if err != nil {
+		return nil, err
+	}
+
+	newContents, err := cc.execApply(ctx, paths, "json", false, serverSide)
+	if err != nil {
+		return nil, structuredApplyErr(err, newContents)
+	}
+	newObjs, err := apply.KubeJSONToObjects(newContents)
+	if err != nil {
+		return nil, err
+	}
+
+	return apply.ObjsToResults(oldObjs, newObjs)
 }
 
 // Delete deletes one or more resources associated with the argument paths.
 func (cc *KubeClient) Delete(
 	ctx context.Context,
 	ids []string,
+	options kube.DeleteOptions,
 ) ([]byte, error) {
-	return cc.kubeClient.Delete(ctx, ids)
+	return cc.kubeClient.Delete(ctx, ids, options)
 }
 
 // Diff gets the diffs between the configs at the given path and the actual state of resources
@@ -89,9 +126,9 @@ func (cc *KubeClient) Delete(
 func (cc *KubeClient) Diff(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
 ) ([]byte, error) {
-	rawResults, err := cc.execDiff(ctx, paths, false)
+	rawResults, err := cc.execDiff(ctx, paths, false, serverSide, nil)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"Error running diff: %+v (output: %s)",
@@ -104,13 +141,15 @@ func (cc *KubeClient) Diff(
 }
 
 // DiffStructured gets the diffs between the configs at the given path and the actual state of
-// resources in the cluster. It returns structured output.
+// resources in the cluster. The fields in the argument ignore rules are stripped from both
+// versions of each object before they're compared. It returns structured output.
 func (cc *KubeClient) DiffStructured(
 	ctx context.Context,
 	paths []string,
-	serverSide bool,
+	serverSide kube.ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
 ) ([]diff.Result, error) {
-	rawResults, err := cc.execDiff(ctx, paths, true)
+	rawResults, err := cc.execDiff(ctx, paths, true, serverSide, ignoreFields)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"Error running diff: %+v (output: %s)",
@@ -133,6 +172,34 @@ func (cc *KubeClient) DiffStructured(
 	return sortedDiffResults(results.Results), nil
 }
 
+// Hashes returns hashes of the applied fields in the live versions of the resources
+// associated with the argument ids, leaving out the fields in the argument ignore rules.
+func (cc *KubeClient) Hashes(
+	ctx context.Context,
+	ids []string,
+	ignoreFields []diff.IgnoreRule,
+) (map[string]string, error) {
+	return cc.liveHasher.Hashes(ctx, ids, ignoreFields)
+}
+
+// Annotations returns the annotations of the live versions of the resources associated with
+// the argument ids.
+func (cc *KubeClient) Annotations(
+	ctx context.Context,
+	ids []string,
+) (map[string]map[string]string, error) {
+	return cc.liveHasher.Annotations(ctx, ids)
+}
+
+// LabeledIDs returns the ids of all of the resources in the cluster that match the argument
+// label selector.
+func (cc *KubeClient) LabeledIDs(
+	ctx context.Context,
+	labelSelector string,
+) ([]string, error) {
+	return cc.liveHasher.LabeledIDs(ctx, labelSelector)
+}
+
 // Config returns this client's cluster config.
 func (cc *KubeClient) Config() *Config {
 	return cc.clusterConfig
@@ -148,6 +215,7 @@ func (cc *KubeClient) execApply(
 	paths []string,
 	format string,
 	dryRun bool,
+	serverSide kube.ServerSideOptions,
 ) ([]byte, error) {
 	return cc.kubeClient.Apply(
 		ctx,
@@ -155,6 +223,7 @@ func (cc *KubeClient) execApply(
 		true,
 		format,
 		dryRun,
+		serverSide,
 	)
 }
 
@@ -162,10 +231,23 @@ func (cc *KubeClient) execDiff(
 	ctx context.Context,
 	paths []string,
 	structured bool,
+	serverSide kube.ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
 ) ([]byte, error) {
 	return cc.kubeClient.Diff(
 		ctx,
 		paths,
 		structured,
+		serverSide,
+		ignoreFields,
 	)
 }
+
+// structuredApplyErr adds the kubectl output to the argument error since it isn't otherwise
+// returned from structured applies. Conflict errors are passed through as-is.
+func structuredApplyErr(err error, output []byte) error {
+	if _, ok := err.(*kube.ApplyConflictsError); ok {
+		return err
+	}
+	return fmt.Errorf("Error running apply: %+v (output: %s)", err, string(output))
+}
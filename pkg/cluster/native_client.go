@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+)
+
+var _ Client = (*NativeClient)(nil)
+
+// NativeClient is an implementation of a Client that hits an actual Kubernetes API without
+// requiring kubectl or kadiff to be installed. It's backed by a kube.DynamicClient, which
+// always uses server-side applies.
+type NativeClient struct {
+	clusterConfig *Config
+	kubeClient    *kube.DynamicClient
+	liveHasher    *kube.LiveHasher
+}
+
+// NewNativeClient creates a new NativeClient instance for a real Kubernetes cluster.
+func NewNativeClient(
+	ctx context.Context,
+	config *ClientConfig,
+) (Client, error) {
+	if config.Config.KubeConfigPath == "" {
+		return nil, fmt.Errorf("Must provide a kubeconfig")
+	}
+
+	kubeClient, err := kube.NewDynamicClient(
+		config.Config.KubeConfigPath,
+		config.KeepConfigs,
+		config.DiffConfig,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	liveHasher, err := kube.NewLiveHasher(config.Config.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NativeClient{
+		clusterConfig: config.Config,
+		kubeClient:    kubeClient,
+		liveHasher:    liveHasher,
+	}, nil
+}
+
+// Apply applies the resources at the argument paths. Applies are always done server-side,
+// regardless of whether serverSide.Enabled is set, but the field manager and conflict
+// settings in the argument options are passed through to the underlying client.
+func (nc *NativeClient) Apply(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+) ([]byte, error) {
+	return nc.kubeClient.Apply(ctx, paths, false, serverSide)
+}
+
+// ApplyStructured applies the resources at the argument paths and returns structured,
+// per-resource results.
+func (nc *NativeClient) ApplyStructured(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+) ([]apply.Result, error) {
+	return nc.kubeClient.ApplyStructured(ctx, paths, serverSide)
+}
+
+// Delete deletes one or more resources associated with the argument ids.
+func (nc *NativeClient) Delete(
+	ctx context.Context,
+	ids []string,
+	options kube.DeleteOptions,
+) ([]byte, error) {
+	return nc.kubeClient.Delete(ctx, ids, options)
+}
+
+// Diff gets the diffs between the configs at the given path and the actual state of resources
+// in the cluster. It returns the raw output.
+func (nc *NativeClient) Diff(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+) ([]byte, error) {
+	results, err := nc.DiffStructured(ctx, paths, serverSide, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rawDiffs := []string{}
+	for _, result := range results {
+		rawDiffs = append(rawDiffs, result.RawDiff)
+	}
+
+	return []byte(strings.Join(rawDiffs, "\n")), nil
+}
+
+// DiffStructured gets the diffs between the configs at the given path and the actual state of
+// resources in the cluster. The fields in the argument ignore rules are stripped from both
+// versions of each object before they're compared. It returns structured output.
+func (nc *NativeClient) DiffStructured(
+	ctx context.Context,
+	paths []string,
+	serverSide kube.ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
+) ([]diff.Result, error) {
+	results, err := nc.kubeClient.Diff(ctx, paths, serverSide, ignoreFields)
+	if err != nil {
+		return nil, fmt.Errorf("Error running diff: %+v", err)
+	}
+
+	return sortedDiffResults(results), nil
+}
+
+// Hashes returns hashes of the applied fields in the live versions of the resources
+// associated with the argument ids, leaving out the fields in the argument ignore rules.
+func (nc *NativeClient) Hashes(
+	ctx context.Context,
+	ids []string,
+	ignoreFields []diff.IgnoreRule,
+) (map[string]string, error) {
+	return nc.liveHasher.Hashes(ctx, ids, ignoreFields)
+}
+
+// Annotations returns the annotations of the live versions of the resources associated with
+// the argument ids.
+func (nc *NativeClient) Annotations(
+	ctx context.Context,
+	ids []string,
+) (map[string]map[string]string, error) {
+	return nc.liveHasher.Annotations(ctx, ids)
+}
+
+// LabeledIDs returns the ids of all of the resources in the cluster that match the argument
+// label selector.
+func (nc *NativeClient) LabeledIDs(
+	ctx context.Context,
+	labelSelector string,
+) ([]string, error) {
+	return nc.liveHasher.LabeledIDs(ctx, labelSelector)
+}
+
+// Config returns this client's cluster config.
+func (nc *NativeClient) Config() *Config {
+	return nc.clusterConfig
+}
+
+// Close closes the client and cleans up all of the associated resources.
+func (nc *NativeClient) Close() error {
+	return nil
+}
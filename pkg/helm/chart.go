@@ -0,0 +1,59 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// LoadChart loads the chart at the argument path, which can be either a chart directory or a
+// packaged (.tgz) chart. If a directory doesn't contain a Chart.yaml but has a single
+// subdirectory that does, as in extracted chart packages, then that subdirectory is loaded.
+func LoadChart(chartPath string) (*chart.Chart, error) {
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		chartPath, err = findChartDir(chartPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return loader.Load(chartPath)
+}
+
+// ParseValues parses the argument values YAML. Empty values are treated as an empty map.
+func ParseValues(contents string) (map[string]interface{}, error) {
+	values, err := chartutil.ReadValues([]byte(contents))
+	if err != nil {
+		return nil, err
+	}
+	return values.AsMap(), nil
+}
+
+func findChartDir(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "Chart.yaml")); err == nil {
+		return dir, nil
+	}
+
+	dirInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(dirInfos) == 1 && dirInfos[0].IsDir() {
+		subDir := filepath.Join(dir, dirInfos[0].Name())
+		if _, err := os.Stat(filepath.Join(subDir, "Chart.yaml")); err == nil {
+			return subDir, nil
+		}
+	}
+
+	return "", fmt.Errorf("Could not find a Chart.yaml in %s", dir)
+}
@@ -0,0 +1,234 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+// Options are the release settings that are used to render a chart.
+type Options struct {
+	// ReleaseName is the name of the release, exposed to templates as .Release.Name
+	ReleaseName string
+
+	// Namespace is the namespace of the release, exposed to templates as .Release.Namespace
+	Namespace string
+
+	// Values are merged over the default values of the chart
+	Values map[string]interface{}
+
+	// KubeVersion is the Kubernetes version exposed to templates via .Capabilities; defaults
+	// to the version of the Helm client libraries if unset.
+	KubeVersion string
+}
+
+// Render renders the argument chart with the Helm template engine, in the same way as
+// "helm template --include-crds". It returns a map from the path of each output file (e.g.,
+// "my-chart/templates/deployment.yaml") to its contents, in the format written by
+// "helm template --output-dir".
+//
+// Hooks are rendered as regular resources, as in "helm template", except for test hooks,
+// which are skipped. Since there's no cluster access at render time, the lookup function
+// always returns empty results.
+func Render(ch *chart.Chart, options Options) (map[string]string, error) {
+	// Processing the dependencies modifies the chart in place, so work on a copy
+	ch = copyChart(ch)
+
+	if err := checkInstallable(ch); err != nil {
+		return nil, err
+	}
+
+	capabilities := chartutil.DefaultCapabilities
+	if options.KubeVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(options.KubeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse Kubernetes version: %+v", err)
+		}
+		capabilities = capabilities.Copy()
+		capabilities.KubeVersion = *kubeVersion
+	}
+
+	if ch.Metadata.KubeVersion != "" &&
+		!chartutil.IsCompatibleRange(ch.Metadata.KubeVersion, capabilities.KubeVersion.String()) {
+		return nil, fmt.Errorf(
+			"Chart requires kubeVersion %s, which is incompatible with Kubernetes %s",
+			ch.Metadata.KubeVersion,
+			capabilities.KubeVersion.String(),
+		)
+	}
+
+	values := options.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	if err := chartutil.ProcessDependencies(ch, values); err != nil {
+		return nil, fmt.Errorf("Error processing chart dependencies: %+v", err)
+	}
+
+	renderValues, err := chartutil.ToRenderValues(
+		ch,
+		values,
+		chartutil.ReleaseOptions{
+			Name:      options.ReleaseName,
+			Namespace: options.Namespace,
+			Revision:  1,
+			IsInstall: true,
+		},
+		capabilities,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := engine.Render(ch, renderValues)
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+
+	hooks, manifests, err := releaseutil.SortManifests(
+		files,
+		capabilities.APIVersions,
+		releaseutil.InstallOrder,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]string{}
+	addResult := func(name string, contents string) {
+		results[name] += fmt.Sprintf("---\n# Source: %s\n%s\n", name, contents)
+	}
+
+	for _, crd := range ch.CRDObjects() {
+		addResult(crd.Filename, string(crd.File.Data))
+	}
+	for _, manifest := range manifests {
+		addResult(manifest.Name, manifest.Content)
+	}
+	for _, hook := range hooks {
+		if isTestHook(hook) {
+			continue
+		}
+		addResult(hook.Path, hook.Manifest)
+	}
+
+	return results, nil
+}
+
+// RenderToDir renders the argument chart and writes the results into the destination
+// directory, using the same layout as "helm template --output-dir". Output files that don't
+// have a .yaml extension have one added so that they're picked up as manifests.
+func RenderToDir(ch *chart.Chart, options Options, destDir string) error {
+	results, err := Render(ch, options)
+	if err != nil {
+		return err
+	}
+
+	for name, contents := range results {
+		if !strings.HasSuffix(name, ".yaml") {
+			name = fmt.Sprintf("%s.yaml", name)
+		}
+
+		outputPath := filepath.Join(destDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(outputPath, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	log.Infof(
+		"Rendered %d files from chart %s for release %s",
+		len(results),
+		ch.Metadata.Name,
+		options.ReleaseName,
+	)
+	return nil
+}
+
+// checkInstallable returns an error if the argument chart can't be installed, either because
+// it's a library chart or because it's missing dependencies that are listed in its
+// Chart.yaml. These are the same checks that "helm template" does.
+func checkInstallable(ch *chart.Chart) error {
+	switch ch.Metadata.Type {
+	case "", "application":
+	default:
+		return fmt.Errorf(
+			"Chart %s is a %s chart, which can't be rendered",
+			ch.Name(),
+			ch.Metadata.Type,
+		)
+	}
+
+	missing := []string{}
+
+	for _, dependency := range ch.Metadata.Dependencies {
+		found := false
+		for _, subchart := range ch.Dependencies() {
+			if subchart.Name() == dependency.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, dependency.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"Dependencies of chart %s are missing in its charts directory: %s",
+			ch.Name(),
+			strings.Join(missing, ", "),
+		)
+	}
+	return nil
+}
+
+// copyChart returns a copy of the argument chart and its dependencies that can be modified
+// without affecting the original. The file contents are shared.
+func copyChart(ch *chart.Chart) *chart.Chart {
+	copied := *ch
+
+	if ch.Metadata != nil {
+		metadata := *ch.Metadata
+		metadata.Dependencies = nil
+		for _, dependency := range ch.Metadata.Dependencies {
+			dependencyCopy := *dependency
+			metadata.Dependencies = append(metadata.Dependencies, &dependencyCopy)
+		}
+		copied.Metadata = &metadata
+	}
+
+	subcharts := []*chart.Chart{}
+	for _, subchart := range ch.Dependencies() {
+		subcharts = append(subcharts, copyChart(subchart))
+	}
+	copied.SetDependencies(subcharts...)
+
+	return &copied
+}
+
+func isTestHook(hook *release.Hook) bool {
+	for _, event := range hook.Events {
+		if event == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
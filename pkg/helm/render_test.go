@@ -0,0 +1,226 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestRender(t *testing.T) {
+	appChart, err := LoadChart("testdata/app")
+	require.NoError(t, err)
+
+	type testCase struct {
+		description      string
+		values           string
+		expectedFiles    []string
+		expectedContents map[string][]string
+		missingContents  map[string][]string
+	}
+
+	testCases := []testCase{
+		{
+			description: "defaults",
+			expectedFiles: []string{
+				"app/charts/monitoring/templates/service.yaml",
+				"app/crds/widgets.yaml",
+				"app/templates/configmap.yaml",
+				"app/templates/deployment.yaml",
+				"app/templates/migrate-job.yaml",
+			},
+			expectedContents: map[string][]string{
+				"app/templates/deployment.yaml": {
+					"# Source: app/templates/deployment.yaml\n",
+					"name: test-release-app\n",
+					"namespace: test-namespace\n",
+					"app.kubernetes.io/version: \"4.5.6\"\n",
+					"annotations:\n    team: platform\n",
+					"replicas: 1\n",
+					"image: example/app:latest\n",
+					"value: \"Hello from test-release\"\n",
+					"value: staging\n",
+				},
+				"app/templates/configmap.yaml": {
+					"data:\n  app.properties: |\n    log.level=info\n",
+				},
+				"app/templates/migrate-job.yaml": {
+					"name: test-release-app-migrate\n",
+					"helm.sh/hook: pre-install,pre-upgrade\n",
+				},
+				"app/charts/monitoring/templates/service.yaml": {
+					"name: test-release-monitoring\n",
+					"environment: staging\n",
+					"port: 9090\n",
+				},
+			},
+		},
+		{
+			description: "overrides",
+			values: `
+replicas: 2
+annotations: null
+image:
+  tag: v2
+global:
+  environment: production
+cache:
+  enabled: true
+tags:
+  observability: false
+`,
+			expectedFiles: []string{
+				"app/charts/cache/templates/service.yaml",
+				"app/crds/widgets.yaml",
+				"app/templates/configmap.yaml",
+				"app/templates/deployment.yaml",
+				"app/templates/migrate-job.yaml",
+				"app/templates/pdb.yaml",
+			},
+			expectedContents: map[string][]string{
+				"app/templates/deployment.yaml": {
+					"replicas: 2\n",
+					"image: example/app:v2\n",
+					"value: production\n",
+				},
+				"app/charts/cache/templates/service.yaml": {
+					"name: test-release-cache\n",
+				},
+			},
+			missingContents: map[string][]string{
+				"app/templates/deployment.yaml": {
+					"annotations",
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		values, err := ParseValues(testCase.values)
+		require.NoError(t, err, testCase.description)
+
+		results, err := Render(
+			appChart,
+			Options{
+				ReleaseName: "test-release",
+				Namespace:   "test-namespace",
+				Values:      values,
+			},
+		)
+		require.NoError(t, err, testCase.description)
+
+		files := []string{}
+		for name := range results {
+			files = append(files, name)
+		}
+		sort.Strings(files)
+		assert.Equal(t, testCase.expectedFiles, files, testCase.description)
+
+		for name, substrs := range testCase.expectedContents {
+			for _, substr := range substrs {
+				assert.Contains(t, results[name], substr, testCase.description)
+			}
+		}
+		for name, substrs := range testCase.missingContents {
+			for _, substr := range substrs {
+				assert.NotContains(t, results[name], substr, testCase.description)
+			}
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	appChart, err := LoadChart("testdata/app")
+	require.NoError(t, err)
+
+	values, err := ParseValues("image:\n  repository: \"\"")
+	require.NoError(t, err)
+
+	_, err = Render(appChart, Options{ReleaseName: "test-release", Values: values})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "image.repository is required")
+
+	appChart.Metadata.Dependencies = append(
+		appChart.Metadata.Dependencies,
+		&chart.Dependency{Name: "non-existent"},
+	)
+	_, err = Render(appChart, Options{ReleaseName: "test-release"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "non-existent")
+
+	libraryChart, err := LoadChart("testdata/library")
+	require.NoError(t, err)
+
+	_, err = Render(libraryChart, Options{ReleaseName: "test-release"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "library chart")
+}
+
+func TestRenderToDirFromArchive(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_helm_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	archiveFiles := map[string]string{}
+	err = filepath.Walk(
+		"testdata/app",
+		func(subPath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			contents, err := ioutil.ReadFile(subPath)
+			if err != nil {
+				return err
+			}
+			archiveFiles[strings.TrimPrefix(subPath, "testdata/")] = string(contents)
+			return nil
+		},
+	)
+	require.NoError(t, err)
+
+	archivePath := filepath.Join(tempDir, "app-1.2.3.tgz")
+	util.WriteArchive(t, archivePath, util.ArchiveFormatTarGz, archiveFiles)
+
+	appChart, err := LoadChart(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "app", appChart.Metadata.Name)
+	assert.Equal(t, "1.2.3", appChart.Metadata.Version)
+	assert.Equal(t, 2, len(appChart.Dependencies()))
+
+	outputDir := filepath.Join(tempDir, "output")
+	err = RenderToDir(
+		appChart,
+		Options{
+			ReleaseName: "test-release",
+			Namespace:   "test-namespace",
+		},
+		outputDir,
+	)
+	require.NoError(t, err)
+
+	contents := util.GetContents(t, outputDir)
+	files := []string{}
+	for name := range contents {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	assert.Equal(
+		t,
+		[]string{
+			"app/charts/monitoring/templates/service.yaml",
+			"app/crds/widgets.yaml",
+			"app/templates/configmap.yaml",
+			"app/templates/deployment.yaml",
+			"app/templates/migrate-job.yaml",
+		},
+		files,
+	)
+}
@@ -0,0 +1,166 @@
+package kustomize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// OutputFileName is the name of the file that the resources for each kustomization are
+// written to by BuildAll.
+const OutputFileName = "kustomized.yaml"
+
+// recordingFileSystem is a file system that keeps track of the files under a root directory
+// that are read by kustomize so that they can be cleaned up after the build.
+type recordingFileSystem struct {
+	filesys.FileSystem
+
+	rootDir   string
+	readFiles map[string]struct{}
+}
+
+// ReadFile reads the file at the argument path, recording it if it's under the root directory.
+func (r *recordingFileSystem) ReadFile(path string) ([]byte, error) {
+	if isWithin(r.rootDir, path) {
+		r.readFiles[path] = struct{}{}
+	}
+	return r.FileSystem.ReadFile(path)
+}
+
+// BuildAll finds all of the kustomizations in the argument directory and replaces each one that
+// isn't used as a base by another with a single file containing its resources, as built by
+// "kustomize build". The files in the kustomization directories, along with any other files
+// that the builds read, are removed.
+func BuildAll(rootDir string) error {
+	absRootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return err
+	}
+	// Kustomize resolves symlinks in the paths that it reads, so do the same here
+	absRootDir, err = filepath.EvalSymlinks(absRootDir)
+	if err != nil {
+		return err
+	}
+
+	kustomizationDirs := []string{}
+
+	err = filepath.Walk(
+		absRootDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && FindKustomizationFile(path) != "" {
+				kustomizationDirs = append(kustomizationDirs, path)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(kustomizationDirs) == 0 {
+		return nil
+	}
+
+	baseDirs := map[string]struct{}{}
+
+	for _, dir := range kustomizationDirs {
+		kustomization, err := loadKustomization(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range localEntries(kustomization) {
+			path := filepath.Join(dir, entry)
+			if !isWithin(absRootDir, path) {
+				return fmt.Errorf(
+					"Resource %s in kustomization %s is outside of %s",
+					entry,
+					dir,
+					absRootDir,
+				)
+			}
+
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				baseDirs[path] = struct{}{}
+			}
+		}
+	}
+
+	fileSystem := &recordingFileSystem{
+		FileSystem: filesys.MakeFsOnDisk(),
+		rootDir:    absRootDir,
+		readFiles:  map[string]struct{}{},
+	}
+
+	options := krusty.MakeDefaultOptions()
+	// Match the default output order of "kustomize build"
+	options.DoLegacyResourceSort = true
+	kustomizer := krusty.MakeKustomizer(options)
+
+	outputs := map[string][]byte{}
+
+	for _, dir := range kustomizationDirs {
+		if _, ok := baseDirs[dir]; ok {
+			continue
+		}
+
+		resMap, err := kustomizer.Run(fileSystem, dir)
+		if err != nil {
+			// Kustomize errors include stack traces when formatted with %+v
+			return fmt.Errorf("Error building kustomization in %s: %v", dir, err)
+		}
+
+		contents, err := resMap.AsYaml()
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Built kustomization in %s with %d resources", dir, resMap.Size())
+		outputs[dir] = contents
+	}
+
+	consumedFiles := fileSystem.readFiles
+
+	for _, dir := range kustomizationDirs {
+		fileInfos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fileInfo := range fileInfos {
+			if !fileInfo.IsDir() {
+				consumedFiles[filepath.Join(dir, fileInfo.Name())] = struct{}{}
+			}
+		}
+	}
+
+	for path := range consumedFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for dir, contents := range outputs {
+		if err := ioutil.WriteFile(filepath.Join(dir, OutputFileName), contents, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isWithin(dir string, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return relPath != ".." && !strings.HasPrefix(relPath, "../")
+}
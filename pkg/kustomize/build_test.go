@@ -0,0 +1,314 @@
+package kustomize
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var baseFiles = map[string]string{
+	"base/kustomization.yaml": `
+resources:
+  - deployment.yaml
+  - service.yaml
+commonLabels:
+  app: web
+configMapGenerator:
+  - name: web-config
+    literals:
+      - LOG_LEVEL=info
+      - PORT=8080
+`,
+	"base/deployment.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: web
+          image: example/web:1.0
+          envFrom:
+            - configMapRef:
+                name: web-config
+          env:
+            - name: PASSWORD
+              valueFrom:
+                secretKeyRef:
+                  name: credentials
+                  key: password
+`,
+	"base/service.yaml": `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`,
+}
+
+func TestBuildAll(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_kustomize_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"overlay/kustomization.yaml": `
+resources:
+  - ../base
+namespace: production
+namePrefix: prod-
+commonAnnotations:
+  team: platform
+labels:
+  - pairs:
+      tier: frontend
+images:
+  - name: example/web
+    newTag: "2.0"
+replicas:
+  - name: web
+    count: 3
+configMapGenerator:
+  - name: web-config
+    behavior: merge
+    literals:
+      - LOG_LEVEL=warn
+secretGenerator:
+  - name: credentials
+    envs:
+      - credentials.env
+    options:
+      disableNameSuffixHash: true
+patchesStrategicMerge:
+  - resources.yaml
+patches:
+  - target:
+      kind: Service
+    patch: |-
+      - op: add
+        path: /spec/type
+        value: NodePort
+`,
+		"overlay/resources.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          resources:
+            limits:
+              memory: 512Mi
+`,
+		"overlay/credentials.env": "# Test credentials\npassword=hunter2\n",
+		"overlay/README.md":       "Not a manifest",
+		"other/configmap.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+`,
+	}
+	for path, contents := range baseFiles {
+		files[path] = contents
+	}
+	util.WriteFiles(t, tempDir, files)
+
+	err = BuildAll(tempDir)
+	require.NoError(t, err)
+
+	remainingFiles := []string{}
+	err = filepath.Walk(
+		tempDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(tempDir, path)
+			remainingFiles = append(remainingFiles, relPath)
+			return err
+		},
+	)
+	require.NoError(t, err)
+	sort.Strings(remainingFiles)
+	assert.Equal(
+		t,
+		[]string{"other/configmap.yaml", "overlay/kustomized.yaml"},
+		remainingFiles,
+	)
+
+	objs := readObjects(t, filepath.Join(tempDir, "overlay", OutputFileName))
+	require.Equal(t, 4, len(objs))
+
+	deployment := objs["Deployment"]
+	assert.Equal(t, "prod-web", deployment.GetName())
+	assert.Equal(t, "production", deployment.GetNamespace())
+	assert.Equal(
+		t,
+		map[string]string{"app": "web", "tier": "frontend"},
+		deployment.GetLabels(),
+	)
+	assert.Equal(t, map[string]string{"team": "platform"}, deployment.GetAnnotations())
+
+	replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+	matchLabels, _, _ := unstructured.NestedStringMap(
+		deployment.Object,
+		"spec",
+		"selector",
+		"matchLabels",
+	)
+	assert.Equal(t, map[string]string{"app": "web"}, matchLabels)
+
+	containers, _, _ := unstructured.NestedSlice(
+		deployment.Object,
+		"spec",
+		"template",
+		"spec",
+		"containers",
+	)
+	require.Equal(t, 1, len(containers))
+	container := containers[0].(map[string]interface{})
+	assert.Equal(t, "example/web:2.0", container["image"])
+	memory, _, _ := unstructured.NestedString(container, "resources", "limits", "memory")
+	assert.Equal(t, "512Mi", memory)
+
+	configMap := objs["ConfigMap"]
+	assert.True(t, strings.HasPrefix(configMap.GetName(), "prod-web-config-"))
+	assert.Equal(t, "production", configMap.GetNamespace())
+	data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "warn", "PORT": "8080"}, data)
+
+	envFrom := container["envFrom"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(
+		t,
+		configMap.GetName(),
+		envFrom["configMapRef"].(map[string]interface{})["name"],
+	)
+
+	secret := objs["Secret"]
+	assert.Equal(t, "prod-credentials", secret.GetName())
+	password, _, _ := unstructured.NestedString(secret.Object, "data", "password")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hunter2")), password)
+
+	env := container["env"].([]interface{})[0].(map[string]interface{})
+	secretName, _, _ := unstructured.NestedString(env, "valueFrom", "secretKeyRef", "name")
+	assert.Equal(t, "prod-credentials", secretName)
+
+	service := objs["Service"]
+	assert.Equal(t, "prod-web", service.GetName())
+	serviceType, _, _ := unstructured.NestedString(service.Object, "spec", "type")
+	assert.Equal(t, "NodePort", serviceType)
+	selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector")
+	assert.Equal(t, map[string]string{"app": "web"}, selector)
+}
+
+func TestBuildAllErrors(t *testing.T) {
+	type testCase struct {
+		description   string
+		kustomization string
+		expectedError string
+	}
+
+	testCases := []testCase{
+		{
+			description:   "unknown field",
+			kustomization: "resources:\n  - ../base\nunknownField: true\n",
+			expectedError: "unknown field",
+		},
+		{
+			description:   "outside of root",
+			kustomization: "resources:\n  - ../../base\n",
+			expectedError: "is outside of",
+		},
+		{
+			description:   "duplicate resource",
+			kustomization: "resources:\n  - ../base\nconfigMapGenerator:\n  - name: web-config\n",
+			expectedError: "behavior must be merge or replace",
+		},
+		{
+			description: "unmatched patch",
+			kustomization: `
+resources:
+  - ../base
+patchesStrategicMerge:
+  - |-
+    apiVersion: v1
+    kind: Service
+    metadata:
+      name: non-existent
+`,
+			expectedError: "failed to find unique target for patch",
+		},
+		{
+			description: "JSON 6902 patch without target",
+			kustomization: `
+resources:
+  - ../base
+patches:
+  - patch: |-
+      - op: remove
+        path: /spec/ports
+`,
+			expectedError: "must specify a target for patch",
+		},
+	}
+
+	for _, testCase := range testCases {
+		tempDir, err := ioutil.TempDir("", "kubeapply_test_kustomize_")
+		require.NoError(t, err)
+		defer os.RemoveAll(tempDir)
+
+		files := map[string]string{
+			"overlay/kustomization.yaml": testCase.kustomization,
+		}
+		for path, contents := range baseFiles {
+			files[path] = contents
+		}
+		util.WriteFiles(t, tempDir, files)
+
+		err = BuildAll(tempDir)
+		require.Error(t, err, testCase.description)
+		assert.Contains(t, err.Error(), testCase.expectedError, testCase.description)
+	}
+}
+
+func readObjects(t *testing.T, path string) map[string]*unstructured.Unstructured {
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(contents), 4096)
+	objsByKind := map[string]*unstructured.Unstructured{}
+
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		objsByKind[obj.GetKind()] = obj
+	}
+	return objsByKind
+}
@@ -0,0 +1,72 @@
+package kustomize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// KustomizationFileNames are the names of the files that mark a directory as a kustomization,
+// in the order that they're checked.
+var KustomizationFileNames = konfig.RecognizedKustomizationFileNames()
+
+// FindKustomizationFile returns the path of the kustomization file in the argument directory,
+// or an empty string if there isn't one.
+func FindKustomizationFile(dir string) string {
+	for _, name := range KustomizationFileNames {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// loadKustomization reads the kustomization file in the argument directory. Only the fields
+// that kubeapply needs to find bases are used here; the file is fully validated by kustomize
+// during the build.
+func loadKustomization(dir string) (*types.Kustomization, error) {
+	path := FindKustomizationFile(dir)
+	if path == "" {
+		return nil, fmt.Errorf("No kustomization file found in %s", dir)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kustomization := &types.Kustomization{}
+	if err := kustomization.Unmarshal(contents); err != nil {
+		return nil, fmt.Errorf("Error parsing kustomization %s: %+v", path, err)
+	}
+	kustomization.FixKustomizationPostUnmarshalling()
+
+	return kustomization, nil
+}
+
+// localEntries returns the resources and components of the argument kustomization that refer
+// to paths in the local file system, i.e. that aren't remote URLs.
+func localEntries(kustomization *types.Kustomization) []string {
+	entries := []string{}
+
+	for _, entry := range append(kustomization.Resources, kustomization.Components...) {
+		if !isRemote(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+func isRemote(entry string) bool {
+	return strings.Contains(entry, "://") ||
+		strings.HasPrefix(entry, "git@") ||
+		strings.HasPrefix(entry, "github.com/")
+}
@@ -0,0 +1,298 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Function used to create the clients for each cluster; overridden in tests.
+var createClusterClients = newClusterClients
+
+// clustersSchema returns the schema for the named cluster blocks of a multi-cluster provider.
+func clustersSchema() *schema.Schema {
+	clusterSchema := authSchema()
+
+	clusterSchema["name"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Required:    true,
+		Description: "Name that profiles use to target this cluster",
+	}
+	clusterSchema["cluster_name"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Default:     "",
+		Description: "Name of the cluster; defaults to name",
+	}
+	for key, description := range map[string]string{
+		"cluster_version": "Cluster Kubernetes version",
+		"region":          "Region",
+		"environment":     "Account environment",
+		"account_name":    "Account name",
+		"account_id":      "Account ID",
+	} {
+		clusterSchema[key] = &schema.Schema{
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     "",
+			Description: fmt.Sprintf("%s; defaults to the provider setting", description),
+		}
+	}
+
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "Clusters that profiles can target with their cluster argument",
+		Elem: &schema.Resource{
+			Schema: clusterSchema,
+		},
+	}
+}
+
+// clusterTarget is a named cluster in a multi-cluster provider.
+type clusterTarget struct {
+	config cluster.Config
+	auth   resourceGetter
+}
+
+// clusterCache holds the named clusters of a multi-cluster provider along with the provider
+// contexts for the ones that have been used so far.
+type clusterCache struct {
+	sync.Mutex
+
+	targets  map[string]clusterTarget
+	contexts map[string]*providerContext
+}
+
+func newClusterCache(targets map[string]clusterTarget) *clusterCache {
+	return &clusterCache{
+		targets:  targets,
+		contexts: map[string]*providerContext{},
+	}
+}
+
+// getClusterTargets parses the cluster blocks of the argument provider. Fields that aren't
+// set in a block are taken from the argument provider cluster config.
+func getClusterTargets(
+	data resourceGetter,
+	providerConfig cluster.Config,
+) (map[string]clusterTarget, error) {
+	targets := map[string]clusterTarget{}
+
+	clusterRows, _ := data.Get("cluster").([]interface{})
+	for _, clusterRow := range clusterRows {
+		fields := mapGetter(clusterRow.(map[string]interface{}))
+
+		name := fields.Get("name").(string)
+		if _, ok := targets[name]; ok {
+			return nil, fmt.Errorf("Duplicate cluster name: %s", name)
+		}
+		if fields.Get("host").(string) == "" && fields.Get("config_path").(string) == "" {
+			return nil, fmt.Errorf("Cluster %s is missing a host or config_path", name)
+		}
+
+		config := providerConfig
+		config.Cluster = name
+		config.KubeConfigPath = ""
+
+		for key, value := range map[string]*string{
+			"cluster_name":    &config.Cluster,
+			"cluster_version": &config.Version,
+			"region":          &config.Region,
+			"environment":     &config.Environment,
+			"account_name":    &config.AccountName,
+			"account_id":      &config.AccountID,
+		} {
+			if fieldValue, _ := fields.Get(key).(string); fieldValue != "" {
+				*value = fieldValue
+			}
+		}
+
+		// These are required at the top level of single-cluster providers, so require them
+		// for each cluster here
+		for _, required := range []struct {
+			key   string
+			value string
+		}{
+			{key: "region", value: config.Region},
+			{key: "environment", value: config.Environment},
+			{key: "account_name", value: config.AccountName},
+			{key: "account_id", value: config.AccountID},
+		} {
+			if required.value == "" {
+				return nil, fmt.Errorf(
+					"Cluster %s is missing %s; set it in the cluster block or the provider",
+					name,
+					required.key,
+				)
+			}
+		}
+
+		targets[name] = clusterTarget{
+			config: config,
+			auth:   fields,
+		}
+	}
+
+	return targets, nil
+}
+
+// forResource returns the provider context for the cluster targeted by the argument resource.
+// Resources without a cluster use the provider's top-level cluster.
+func (p *providerContext) forResource(
+	ctx context.Context,
+	data resourceGetter,
+) (*providerContext, error) {
+	name, _ := data.Get("cluster").(string)
+	return p.forCluster(ctx, name)
+}
+
+// forCluster returns the provider context for the argument named cluster, or this context if
+// the name is empty. The clients for each cluster are created the first time that it's used
+// and then cached.
+func (p *providerContext) forCluster(
+	ctx context.Context,
+	name string,
+) (*providerContext, error) {
+	if name == "" {
+		return p, nil
+	} else if p.clusters == nil {
+		return nil, fmt.Errorf("Cluster %s is not configured in the provider", name)
+	}
+
+	p.clusters.Lock()
+	defer p.clusters.Unlock()
+
+	if clusterCtx, ok := p.clusters.contexts[name]; ok {
+		return clusterCtx, nil
+	}
+
+	target, ok := p.clusters.targets[name]
+	if !ok {
+		return nil, fmt.Errorf("Cluster %s is not configured in the provider", name)
+	}
+
+	kubeConfigDir := filepath.Join(p.tempDir, "clusters", name)
+	if err := os.MkdirAll(kubeConfigDir, 0755); err != nil {
+		return nil, err
+	}
+	kubeConfigPath, err := createKubeConfig(target.auth, kubeConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create kubeconfig for cluster %s: %+v", name, err)
+	}
+
+	clusterConfig := target.config
+	clusterConfig.KubeConfigPath = kubeConfigPath
+
+	log.Infof("Creating clients for cluster %s", name)
+	clients, err := createClusterClients(ctx, &clusterConfig, p.diffConfig, p.nativeClient)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create clients for cluster %s: %+v", name, err)
+	}
+
+	clusterCtx := *p
+	clusterCtx.canRun = true
+	clusterCtx.clusterConfig = clusterConfig
+	clusterCtx.clusterClient = clients.clusterClient
+	clusterCtx.dynamicClient = clients.dynamicClient
+	clusterCtx.rawClient = clients.rawClient
+	clusterCtx.restMapper = clients.restMapper
+
+	p.clusters.contexts[name] = &clusterCtx
+	return &clusterCtx, nil
+}
+
+// clusterClients are the clients that are used to access a single cluster.
+type clusterClients struct {
+	clusterClient cluster.Client
+	dynamicClient dynamic.Interface
+	rawClient     kubernetes.Interface
+	restMapper    meta.RESTMapper
+}
+
+// newClusterClients creates the clients for the cluster in the argument config.
+func newClusterClients(
+	ctx context.Context,
+	clusterConfig *cluster.Config,
+	diffConfig diff.DiffConfig,
+	nativeClient bool,
+) (*clusterClients, error) {
+	newClient := cluster.NewKubeClient
+	if nativeClient {
+		log.Info("Using native cluster client")
+		newClient = cluster.NewNativeClient
+	}
+
+	log.Info("Creating cluster client")
+	clusterClient, err := newClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: clusterConfig,
+			// Add extra environment variables that will be used by kadiff to configure diff
+			// outputs
+			ExtraEnv: []string{
+				fmt.Sprintf("KADIFF_CONTEXT_LINES=%d", diffConfig.ContextLines),
+				fmt.Sprintf("KADIFF_MAX_SIZE=%d", diffConfig.MaxSize),
+				fmt.Sprintf("KADIFF_MAX_LINE_LENGTH=%d", diffConfig.MaxLineLength),
+				fmt.Sprintf("KADIFF_MODE=%s", diffConfig.Mode),
+				// The provider always needs JSON outputs, even if kadiff is configured
+				// differently in the environment
+				"KADIFF_OUTPUT=json",
+				fmt.Sprintf(
+					"KADIFF_SENSITIVE_KINDS=%s",
+					strings.Join(diffConfig.SensitiveKinds, ","),
+				),
+				fmt.Sprintf(
+					"KADIFF_SENSITIVE_PATHS=%s",
+					strings.Join(diffConfig.SensitivePaths, ","),
+				),
+			},
+			DiffConfig: diffConfig,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Creating raw kube client")
+	kubeClientConfig, err := clientcmd.BuildConfigFromFlags("", clusterConfig.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rawClient, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Creating dynamic kube client")
+	dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	restMapper, err := kube.NewRESTMapper(kubeClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusterClients{
+		clusterClient: clusterClient,
+		dynamicClient: dynamicClient,
+		rawClient:     rawClient,
+		restMapper:    restMapper,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderClusters(t *testing.T) {
+	ctx := context.Background()
+
+	createdClusters := []string{}
+	createClusterClients = func(
+		ctx context.Context,
+		clusterConfig *cluster.Config,
+		diffConfig diff.DiffConfig,
+		nativeClient bool,
+	) (*clusterClients, error) {
+		createdClusters = append(createdClusters, clusterConfig.Cluster)
+		clusterClient, err := cluster.NewFakeClient(
+			ctx,
+			&cluster.ClientConfig{
+				Config: clusterConfig,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &clusterClients{clusterClient: clusterClient}, nil
+	}
+	defer func() {
+		createClusterClients = newClusterClients
+	}()
+
+	provider := Provider(nil)
+	err := provider.InternalValidate()
+	require.NoError(t, err)
+
+	config := terraform.NewResourceConfigRaw(
+		map[string]interface{}{
+			"environment":  "testEnvironment",
+			"account_name": "testAccountName",
+			"account_id":   "testAccountID",
+			"cluster": []interface{}{
+				map[string]interface{}{
+					"name":   "east",
+					"host":   "eastHost",
+					"region": "us-east-1",
+				},
+				map[string]interface{}{
+					"name":         "west",
+					"cluster_name": "west-cluster",
+					"host":         "westHost",
+					"region":       "us-west-2",
+					"environment":  "otherEnvironment",
+				},
+			},
+		},
+	)
+
+	diags := provider.Configure(ctx, config)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	providerCtx := provider.Meta().(*providerContext)
+	assert.False(t, providerCtx.canRun)
+	assert.Equal(t, 0, len(createdClusters))
+
+	// Resources without a cluster use the top-level one
+	baseCtx, err := providerCtx.forResource(
+		ctx,
+		&fakeChangerSetter{newValues: map[string]interface{}{"cluster": ""}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, providerCtx, baseCtx)
+
+	eastCtx, err := providerCtx.forResource(
+		ctx,
+		&fakeChangerSetter{newValues: map[string]interface{}{"cluster": "east"}},
+	)
+	require.NoError(t, err)
+	assert.True(t, eastCtx.canRun)
+	assert.Equal(t, "east", eastCtx.clusterConfig.Cluster)
+	assert.Equal(t, "us-east-1", eastCtx.clusterConfig.Region)
+	assert.Equal(t, "testEnvironment", eastCtx.clusterConfig.Environment)
+	assert.Equal(t, eastCtx.clusterConfig, *eastCtx.clusterClient.Config())
+
+	kubeConfig, err := ioutil.ReadFile(eastCtx.clusterConfig.KubeConfigPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(kubeConfig), "server: eastHost")
+
+	westCtx, err := providerCtx.forCluster(ctx, "west")
+	require.NoError(t, err)
+	assert.Equal(t, "west-cluster", westCtx.clusterConfig.Cluster)
+	assert.Equal(t, "us-west-2", westCtx.clusterConfig.Region)
+	assert.Equal(t, "otherEnvironment", westCtx.clusterConfig.Environment)
+	assert.NotEqual(t, eastCtx.clusterConfig.KubeConfigPath, westCtx.clusterConfig.KubeConfigPath)
+
+	// Clients are cached after the first use
+	eastCtx2, err := providerCtx.forCluster(ctx, "east")
+	require.NoError(t, err)
+	assert.True(t, eastCtx == eastCtx2)
+	assert.Equal(t, []string{"east", "west-cluster"}, createdClusters)
+
+	_, err = providerCtx.forCluster(ctx, "north")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cluster north is not configured")
+}
+
+func TestGetClusterTargetsErrors(t *testing.T) {
+	_, err := getClusterTargets(
+		mapGetter{
+			"cluster": []interface{}{
+				map[string]interface{}{
+					"name":        "east",
+					"host":        "",
+					"config_path": "",
+				},
+			},
+		},
+		cluster.Config{},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing a host or config_path")
+
+	_, err = getClusterTargets(
+		mapGetter{
+			"cluster": []interface{}{
+				map[string]interface{}{
+					"name":        "east",
+					"host":        "eastHost",
+					"config_path": "",
+				},
+				map[string]interface{}{
+					"name":        "east",
+					"host":        "",
+					"config_path": "/path/to/kubeconfig",
+				},
+			},
+		},
+		cluster.Config{
+			Region:      "testRegion",
+			Environment: "testEnvironment",
+			AccountName: "testAccountName",
+			AccountID:   "testAccountID",
+		},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Duplicate cluster name")
+
+	_, err = getClusterTargets(
+		mapGetter{
+			"cluster": []interface{}{
+				map[string]interface{}{
+					"name":        "east",
+					"host":        "eastHost",
+					"config_path": "",
+					"region":      "us-east-1",
+				},
+			},
+		},
+		cluster.Config{
+			Environment: "testEnvironment",
+			AccountName: "testAccountName",
+		},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cluster east is missing account_id")
+}
+
+func TestProviderRequiredFields(t *testing.T) {
+	provider := Provider(nil)
+
+	diags := provider.Validate(
+		terraform.NewResourceConfigRaw(
+			map[string]interface{}{
+				"cluster_name": "testCluster",
+				"host":         "testHost",
+				"region":       "testRegion",
+			},
+		),
+	)
+	require.True(t, diags.HasError())
+
+	summaries := []string{}
+	for _, diagnostic := range diags {
+		summaries = append(summaries, diagnostic.Detail)
+	}
+	for _, key := range []string{"environment", "account_name", "account_id"} {
+		assert.Contains(t, strings.Join(summaries, "\n"), key)
+	}
+	assert.NotContains(t, strings.Join(summaries, "\n"), "region")
+
+	// The fields aren't needed at the top level if there are cluster blocks
+	diags = provider.Validate(
+		terraform.NewResourceConfigRaw(
+			map[string]interface{}{
+				"cluster": []interface{}{
+					map[string]interface{}{
+						"name":         "east",
+						"host":         "eastHost",
+						"region":       "us-east-1",
+						"environment":  "testEnvironment",
+						"account_name": "testAccountName",
+						"account_id":   "testAccountID",
+					},
+				},
+			},
+		),
+	)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	log "github.com/sirupsen/logrus"
+)
+
+// expandedDataSource defines a kubeapply_expanded data source, which runs the same expansion as
+// a profile but only exposes the results instead of applying them.
+func expandedDataSource() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return dataSourceExpandedRead(ctx, data, provider)
+		},
+		Schema: map[string]*schema.Schema{
+			// Inputs
+			"helm": helmSchema(),
+			"parameters": {
+				Type:        schema.TypeMap,
+				Description: "Arbitrary parameters that will be used for expansion",
+				Optional:    true,
+			},
+			"set": setSchema(),
+			"source": {
+				Type:         schema.TypeString,
+				Description:  "Source for manifest files in local file system or remote git repo",
+				Optional:     true,
+				AtLeastOneOf: []string{"helm", "source"},
+			},
+
+			// Computed fields
+			"manifests": {
+				Type:        schema.TypeList,
+				Description: "Expanded manifests",
+				Computed:    true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"contents": {
+							Type:        schema.TypeString,
+							Description: "YAML contents of the manifest, with sensitive values redacted",
+							Computed:    true,
+						},
+						"hash": {
+							Type:        schema.TypeString,
+							Description: "Hash of the manifest contents",
+							Computed:    true,
+						},
+						"id": {
+							Type:        schema.TypeString,
+							Description: "ID of the resource, in the same format as the resources keys",
+							Computed:    true,
+						},
+						"kind": {
+							Type:        schema.TypeString,
+							Description: "Kind of the resource",
+							Computed:    true,
+						},
+						"name": {
+							Type:        schema.TypeString,
+							Description: "Name of the resource",
+							Computed:    true,
+						},
+						"namespace": {
+							Type:        schema.TypeString,
+							Description: "Namespace of the resource; empty for cluster-scoped resources",
+							Computed:    true,
+						},
+					},
+				},
+			},
+			"resources": {
+				Type:        schema.TypeMap,
+				Description: "Expanded resources, as a map from resource ID to hash",
+				Computed:    true,
+			},
+			"resources_hash": {
+				Type:        schema.TypeString,
+				Description: "Hash of all expanded resources",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func dataSourceExpandedRead(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	source, _ := data.Get("source").(string)
+	log.Infof("Expanding %s", source)
+	providerCtx := provider.(*providerContext)
+
+	// Expansion doesn't need access to the cluster, so this works even if canRun is false
+	expandResult, err := providerCtx.expand(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	defer providerCtx.cleanExpanded(expandResult)
+
+	// The contents end up in the state, so sensitive values are redacted like they are in the
+	// expanded files
+	redactor, err := diff.NewRedactor(providerCtx.diffConfig)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	manifests := []interface{}{}
+	for _, manifest := range expandResult.manifests {
+		var name, namespace string
+		if manifest.Head.Metadata != nil {
+			name = manifest.Head.Metadata.Name
+			namespace = manifest.Head.Metadata.Namespace
+		}
+
+		contents, err := redactor.RedactYAML([]byte(manifest.Contents))
+		if err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+			return diags
+		}
+
+		manifests = append(
+			manifests,
+			map[string]interface{}{
+				"contents":  string(contents),
+				"hash":      manifest.Hash,
+				"id":        manifest.ID,
+				"kind":      manifest.Head.Kind,
+				"name":      name,
+				"namespace": namespace,
+			},
+		)
+	}
+
+	if err := data.Set("manifests", manifests); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	if err := data.Set("resources", expandResult.resources); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	if err := data.Set("resources_hash", expandResult.totalHash); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	// The hash identifies the expanded contents, so use it as the id
+	data.SetId(expandResult.totalHash)
+
+	return diags
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataSourceExpandedRead(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_expanded_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	// The provider can't reach a cluster, which isn't required for expansion
+	providerCtx := &providerContext{
+		clusterConfig: cluster.Config{
+			Cluster:     "testCluster",
+			Environment: "testEnvironment",
+		},
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"source": "testdata/app2",
+			"parameters": map[string]interface{}{
+				"value2":         "Value2",
+				"serviceAccount": "testServiceAccount",
+			},
+			"set": schema.NewSet(schema.HashString, []interface{}{}),
+		},
+	}
+
+	diags := dataSourceExpandedRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	manifests := data.Get("manifests").([]interface{})
+	require.Equal(t, 2, len(manifests))
+
+	manifest := manifests[0].(map[string]interface{})
+	assert.Equal(t, "v1.Service.testNamespace2.testName", manifest["id"])
+	assert.Equal(t, "Service", manifest["kind"])
+	assert.Equal(t, "testName", manifest["name"])
+	assert.Equal(t, "testNamespace2", manifest["namespace"])
+	assert.Contains(t, manifest["contents"], "key2: Value2")
+
+	serviceAccountManifest := manifests[1].(map[string]interface{})
+	assert.Equal(t, "ServiceAccount", serviceAccountManifest["kind"])
+
+	resources := data.Get("resources").(map[string]interface{})
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"v1.Service.testNamespace2.testName":                  manifest["hash"],
+			"v1.ServiceAccount.testNamespace2.testServiceAccount": serviceAccountManifest["hash"],
+		},
+		resources,
+	)
+
+	resourcesHash := data.Get("resources_hash").(string)
+	assert.NotEqual(t, "", resourcesHash)
+	assert.Equal(t, resourcesHash, data.Id())
+
+	// Expanded files are cleaned up
+	expandedDirs, err := ioutil.ReadDir(filepath.Join(tempDir, "expanded"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(expandedDirs))
+}
+
+func TestDataSourceExpandedReadRedacted(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_expanded_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	util.WriteFiles(
+		t,
+		tempDir,
+		map[string]string{
+			"app/secret.yaml": `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test-secret
+  namespace: test-namespace
+data:
+  password: aHVudGVyMg==
+`,
+		},
+	)
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		clusterConfig: cluster.Config{
+			Cluster:     "testCluster",
+			Environment: "testEnvironment",
+		},
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"source":     filepath.Join(tempDir, "app"),
+			"parameters": map[string]interface{}{},
+			"set":        schema.NewSet(schema.HashString, []interface{}{}),
+		},
+	}
+
+	diags := dataSourceExpandedRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	manifests := data.Get("manifests").([]interface{})
+	require.Equal(t, 1, len(manifests))
+
+	manifest := manifests[0].(map[string]interface{})
+	assert.Equal(t, "Secret", manifest["kind"])
+	assert.Equal(t, "test-secret", manifest["name"])
+	assert.Contains(t, manifest["contents"], "password: (sensitive value)")
+	assert.NotContains(t, manifest["contents"], "aHVudGVyMg==")
+}
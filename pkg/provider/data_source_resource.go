@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// resourceDataSource defines a kubeapply_resource data source, which reads the live version of
+// an arbitrary resource from the cluster.
+func resourceDataSource() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return dataSourceResourceRead(ctx, data, provider)
+		},
+		Schema: map[string]*schema.Schema{
+			// Inputs
+			"api_version": {
+				Type:        schema.TypeString,
+				Description: "API version of the resource, e.g. v1 or apps/v1",
+				Required:    true,
+			},
+			"kind": {
+				Type:        schema.TypeString,
+				Description: "Kind of the resource",
+				Required:    true,
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Description: "Name of the resource",
+				Required:    true,
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Description: "Namespace of the resource; ignored for cluster-scoped kinds",
+				Optional:    true,
+				Default:     "default",
+			},
+
+			// Computed fields
+			"object": {
+				Type:        schema.TypeString,
+				Description: "Live version of the resource, in JSON format",
+				Computed:    true,
+			},
+			"status": {
+				Type:        schema.TypeMap,
+				Description: "Flattened status of the resource, keyed by dot-separated paths",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func dataSourceResourceRead(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	providerCtx := provider.(*providerContext)
+
+	if !providerCtx.canRun {
+		err := fmt.Errorf("Cannot read resource because provider is missing a host or kubeconfig")
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	apiVersion := data.Get("api_version").(string)
+	kind := data.Get("kind").(string)
+	namespace, _ := data.Get("namespace").(string)
+	name := data.Get("name").(string)
+
+	log.Infof("Reading %s %s in namespace %s", kind, name, namespace)
+
+	resourcePath, err := kube.ResourcePath(
+		providerCtx.clusterConfig.KubeConfigPath,
+		apiVersion,
+		kind,
+		namespace,
+		name,
+	)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	contents, err := providerCtx.rawClient.CoreV1().RESTClient().Get().
+		AbsPath(resourcePath).
+		DoRaw(ctx)
+	if errors.IsNotFound(err) {
+		err = fmt.Errorf("%s %s not found in namespace %s", kind, name, namespace)
+	}
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(contents, &obj); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	status := map[string]interface{}{}
+	if err := flattenValue(obj["status"], "", status); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	if err := data.Set("object", string(contents)); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	if err := data.Set("status", status); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		namespace, _ = metadata["namespace"].(string)
+	}
+
+	// Use the same format as the resource ids in profiles
+	data.SetId(fmt.Sprintf("%s.%s.%s.%s", apiVersion, kind, namespace, name))
+
+	return diags
+}
+
+// flattenValue flattens the argument value into a map from dot-separated paths to string
+// values. List elements are keyed by their indices, and non-string leaves are JSON-encoded.
+func flattenValue(value interface{}, prefix string, results map[string]interface{}) error {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return strings.Join([]string{prefix, key}, ".")
+	}
+
+	switch typedValue := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		for key, elem := range typedValue {
+			if err := flattenValue(elem, join(key), results); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, elem := range typedValue {
+			if err := flattenValue(elem, join(fmt.Sprintf("%d", i)), results); err != nil {
+				return err
+			}
+		}
+	case string:
+		results[prefix] = typedValue
+	default:
+		encoded, err := json.Marshal(typedValue)
+		if err != nil {
+			return err
+		}
+		results[prefix] = string(encoded)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestDataSourceResourceRead(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_resource_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// Fake API server that supports discovery and has a single service
+	responses := map[string]interface{}{
+		"/api": map[string]interface{}{
+			"kind":     "APIVersions",
+			"versions": []string{"v1"},
+		},
+		"/apis": map[string]interface{}{
+			"kind":   "APIGroupList",
+			"groups": []interface{}{},
+		},
+		"/api/v1": map[string]interface{}{
+			"kind":         "APIResourceList",
+			"groupVersion": "v1",
+			"resources": []interface{}{
+				map[string]interface{}{
+					"name":       "services",
+					"namespaced": true,
+					"kind":       "Service",
+					"verbs":      []string{"get"},
+				},
+			},
+		},
+		"/api/v1/namespaces/test-namespace/services/test-service": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      "test-service",
+				"namespace": "test-namespace",
+			},
+			"status": map[string]interface{}{
+				"loadBalancer": map[string]interface{}{
+					"ingress": []interface{}{
+						map[string]interface{}{
+							"hostname": "test.elb.amazonaws.com",
+							"ports": []interface{}{
+								map[string]interface{}{"port": 443},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				response, ok := responses[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(response)
+			},
+		),
+	)
+	defer server.Close()
+
+	kubeConfigPath := filepath.Join(tempDir, "kubeconfig")
+	err = ioutil.WriteFile(
+		kubeConfigPath,
+		[]byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+clusters:
+  - name: test-cluster
+    cluster:
+      server: %s
+contexts:
+  - name: test-context
+    context:
+      cluster: test-cluster
+      user: test-user
+current-context: test-context
+users:
+  - name: test-user
+    user: {}
+`, server.URL)),
+		0644,
+	)
+	require.NoError(t, err)
+
+	rawClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		canRun: true,
+		clusterConfig: cluster.Config{
+			KubeConfigPath: kubeConfigPath,
+		},
+		rawClient: rawClient,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"api_version": "v1",
+			"kind":        "Service",
+			"namespace":   "test-namespace",
+			"name":        "test-service",
+		},
+	}
+
+	diags := dataSourceResourceRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	assert.Equal(t, "v1.Service.test-namespace.test-service", data.Id())
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"loadBalancer.ingress.0.hostname":     "test.elb.amazonaws.com",
+			"loadBalancer.ingress.0.ports.0.port": "443",
+		},
+		data.Get("status"),
+	)
+
+	obj := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(data.Get("object").(string)), &obj))
+	assert.Equal(t, "Service", obj["kind"])
+
+	data.newValues["name"] = "non-existent"
+	diags = dataSourceResourceRead(ctx, data, providerCtx)
+	require.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "Service non-existent not found")
+
+	data.newValues["kind"] = "Deployment"
+	diags = dataSourceResourceRead(ctx, data, providerCtx)
+	require.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "Could not find resource type")
+}
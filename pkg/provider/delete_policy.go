@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotation that protects an object from being deleted by kubeapply. Objects with this
+// annotation set to "true" are orphaned in the cluster instead of deleted.
+const preventDestroyAnnotation = "kubeapply.segment.com/prevent-destroy"
+
+// deletePolicySchema returns the schema for the delete policy settings of the provider and
+// profiles.
+func deletePolicySchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Description: description,
+		Optional:    true,
+		MaxItems:    1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"exclude_kinds": {
+					Type:        schema.TypeList,
+					Description: "Kinds that are never deleted; removed objects of these kinds are orphaned in the cluster",
+					Optional:    true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+			},
+		},
+	}
+}
+
+// getExcludedKinds returns the kinds in the delete_policy block of the argument provider or
+// resource.
+func getExcludedKinds(data resourceGetter) map[string]struct{} {
+	kinds := map[string]struct{}{}
+
+	policyRows, _ := data.Get("delete_policy").([]interface{})
+	if len(policyRows) == 0 || policyRows[0] == nil {
+		return kinds
+	}
+	policyRow := policyRows[0].(map[string]interface{})
+
+	excludeKinds, _ := policyRow["exclude_kinds"].([]interface{})
+	for _, kind := range excludeKinds {
+		kinds[kind.(string)] = struct{}{}
+	}
+
+	return kinds
+}
+
+// protectedIDs returns the subset of the argument ids that shouldn't be deleted, mapped to
+// the reason why. Objects are protected if their kinds are excluded by the delete policy of
+// the provider or the argument resource, or if their live versions have the prevent-destroy
+// annotation.
+func (p *providerContext) protectedIDs(
+	ctx context.Context,
+	data resourceChanger,
+	ids []string,
+) (map[string]string, error) {
+	protected := map[string]string{}
+	if len(ids) == 0 {
+		return protected, nil
+	}
+
+	excludedKinds := getExcludedKinds(data)
+	for kind := range p.deleteExcludedKinds {
+		excludedKinds[kind] = struct{}{}
+	}
+
+	remainingIDs := []string{}
+
+	for _, id := range ids {
+		_, kind, _, _, err := kube.ParseManifestID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := excludedKinds[kind]; ok {
+			protected[id] = fmt.Sprintf("kind %s is excluded by delete_policy", kind)
+		} else {
+			remainingIDs = append(remainingIDs, id)
+		}
+	}
+
+	if len(remainingIDs) > 0 {
+		annotations, err := p.clusterClient.Annotations(ctx, remainingIDs)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get annotations of resources to delete: %+v", err)
+		}
+
+		for _, id := range remainingIDs {
+			if annotations[id][preventDestroyAnnotation] == "true" {
+				protected[id] = fmt.Sprintf("it has the %s annotation", preventDestroyAnnotation)
+			}
+		}
+	}
+
+	log.Infof("Found %d/%d resources that are protected from deletion", len(protected), len(ids))
+	return protected, nil
+}
@@ -3,26 +3,69 @@ package provider
 import (
 	"fmt"
 	"regexp"
-	"strings"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 )
 
 // Terraform gets upset if the same diff run multiple times yields any differences. This
-// regexp helps to replace the variable parts with fixed placeholders.
-var sanitizationRegexp = regexp.MustCompile(`(\s+)(creationTimestamp|uid)[:]([^\n]+)`)
+// regexp helps to replace the variable parts with fixed placeholders. It matches both the YAML
+// lines in unified diffs and the field paths in structural ones.
+var sanitizationRegexp = regexp.MustCompile(
+	`(\s+|metadata\.)(creationTimestamp|uid)[:]([^\n]+)`,
+)
 
 func sanitizeDiff(rawDiff string) string {
 	return sanitizationRegexp.ReplaceAllString(rawDiff, "${1}${2}: OMITTED")
 }
 
-func prettyResults(rawResults []byte) string {
-	lines := strings.Split(string(rawResults), "\n")
-	output := []string{}
+// formatDiffs converts the argument structured diffs and removed resource ids into the map
+// that's stored in the diff field of a resource. Removed resources that are in the argument
+// protected map, as returned by protectedIDs, are shown as orphaned.
+func (p *providerContext) formatDiffs(
+	data resourceChanger,
+	diffs []diff.Result,
+	removed []string,
+	protected map[string]string,
+) map[string]interface{} {
+	results := map[string]interface{}{}
+
+	for _, diffObj := range diffs {
+		if p.verboseDiffs {
+			results[diffObj.Name] = sanitizeDiff(diffObj.RawDiff)
+		} else {
+			switch diffObj.Operation {
+			case diff.OperationCreate:
+				results[diffObj.Name] = fmt.Sprintf(
+					"Creating new resource (%d lines added)",
+					diffObj.NumAdded,
+				)
+			case diff.OperationDelete:
+				results[diffObj.Name] = fmt.Sprintf(
+					"Completely removing resource (%d lines removed)",
+					diffObj.NumRemoved,
+				)
+			default:
+				results[diffObj.Name] = sanitizeDiff(diffObj.RawDiff)
+			}
+		}
+	}
 
-	for _, line := range lines {
-		if line != "" {
-			output = append(output, fmt.Sprintf("> %s", line))
+	if p.canDelete(data) {
+		for _, id := range removed {
+			if reason, ok := protected[id]; ok {
+				results[id] = fmt.Sprintf(
+					"TO BE REMOVED from Terraform but will not be deleted from cluster because %s.",
+					reason,
+				)
+			} else {
+				results[id] = "TO BE DELETED"
+			}
+		}
+	} else {
+		for _, id := range removed {
+			results[id] = "TO BE REMOVED from Terraform but will not be deleted from cluster due to value of allow_deletes.\nPlease delete manually."
 		}
 	}
 
-	return strings.Join(output, "\n")
+	return results
 }
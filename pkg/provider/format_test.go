@@ -24,4 +24,13 @@ func TestSanitizeDiff(t *testing.T) {
 			key3: value3
 		`),
 	)
+	assert.Equal(
+		t,
+		`+ metadata.creationTimestamp: OMITTED
++ metadata.name: "test"
++ metadata.uid: OMITTED`,
+		sanitizeDiff(`+ metadata.creationTimestamp: "2020-01-10T00:00:00Z"
++ metadata.name: "test"
++ metadata.uid: "12345h3123"`),
+	)
 }
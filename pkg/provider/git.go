@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 )
 
 type gitClient interface {
-	cloneRepo(ctx context.Context, repoURL string, ref string, dest string) error
+	cloneRepo(ctx context.Context, repoURL string, ref string, depth int, dest string) error
+	resolveRef(ctx context.Context, repoURL string, ref string) (string, error)
 }
 
 type commandLineGitClient struct{}
@@ -21,12 +23,22 @@ func (c *commandLineGitClient) cloneRepo(
 	ctx context.Context,
 	repoURL string,
 	ref string,
+	depth int,
 	dest string,
 ) error {
-	return util.CloneRepo(ctx, repoURL, ref, dest)
+	return util.CloneRepo(ctx, repoURL, ref, depth, dest)
+}
+
+func (c *commandLineGitClient) resolveRef(
+	ctx context.Context,
+	repoURL string,
+	ref string,
+) (string, error) {
+	return util.ResolveRef(ctx, repoURL, ref)
 }
 
 type fakeGitClient struct {
+	sync.Mutex
 	calls []string
 }
 
@@ -36,11 +48,15 @@ func (f *fakeGitClient) cloneRepo(
 	ctx context.Context,
 	repoURL string,
 	ref string,
+	depth int,
 	dest string,
 ) error {
+	f.Lock()
+	defer f.Unlock()
+
 	f.calls = append(
 		f.calls,
-		fmt.Sprintf("cloneRepo repoURL=%s,ref=%s", repoURL, ref),
+		fmt.Sprintf("cloneRepo repoURL=%s,ref=%s,depth=%d", repoURL, ref, depth),
 	)
 	profilePath := filepath.Join(dest, "profiles", "profile.txt")
 
@@ -57,3 +73,18 @@ func (f *fakeGitClient) cloneRepo(
 	_, err = file.WriteString(fmt.Sprintf("repoURL=%s ref=%s", repoURL, ref))
 	return err
 }
+
+func (f *fakeGitClient) resolveRef(
+	ctx context.Context,
+	repoURL string,
+	ref string,
+) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.calls = append(
+		f.calls,
+		fmt.Sprintf("resolveRef repoURL=%s,ref=%s", repoURL, ref),
+	)
+	return fmt.Sprintf("commit-%s", ref), nil
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/helm"
+	log "github.com/sirupsen/logrus"
+)
+
+// helmConfig stores the settings in the helm block of a profile.
+type helmConfig struct {
+	chart       string
+	values      string
+	releaseName string
+	namespace   string
+}
+
+// getHelmConfig returns the helm settings for the argument resource, or nil if it doesn't have
+// a helm block.
+func getHelmConfig(data resourceGetter) *helmConfig {
+	helmRows, _ := data.Get("helm").([]interface{})
+	if len(helmRows) == 0 || helmRows[0] == nil {
+		return nil
+	}
+	helmRow := helmRows[0].(map[string]interface{})
+
+	config := &helmConfig{}
+	config.chart, _ = helmRow["chart"].(string)
+	config.values, _ = helmRow["values"].(string)
+	config.releaseName, _ = helmRow["release_name"].(string)
+	config.namespace, _ = helmRow["namespace"].(string)
+
+	return config
+}
+
+// renderHelmChart renders the chart in the argument config into the destination directory.
+// Charts in the local file system are loaded in-place; other charts are fetched in the same
+// way as profile sources.
+func (p *providerContext) renderHelmChart(
+	ctx context.Context,
+	config *helmConfig,
+	destDir string,
+) error {
+	address, err := parseSourceAddress(config.chart)
+	if err != nil {
+		return err
+	}
+
+	chartPath := address.localPath
+
+	if !address.isLocal() {
+		chartPath, err = ioutil.TempDir(p.tempDir, "chart_")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(chartPath)
+
+		chartVersion, err := p.sourceFetcher.get(ctx, config.chart, chartPath)
+		if err != nil {
+			return err
+		}
+		log.Infof("Fetched chart %s at %s", config.chart, chartVersion)
+	}
+
+	chart, err := helm.LoadChart(chartPath)
+	if err != nil {
+		return fmt.Errorf("Could not load chart %s: %+v", config.chart, err)
+	}
+
+	values, err := helm.ParseValues(config.values)
+	if err != nil {
+		return fmt.Errorf("Could not parse values for chart %s: %+v", config.chart, err)
+	}
+
+	log.Infof(
+		"Rendering chart %s (version %s) for release %s",
+		chart.Metadata.Name,
+		chart.Metadata.Version,
+		config.releaseName,
+	)
+	return helm.RenderToDir(
+		chart,
+		helm.Options{
+			ReleaseName: config.releaseName,
+			Namespace:   config.namespace,
+			Values:      values,
+		},
+		destDir,
+	)
+}
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandHelmChart(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_helm_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	chartDir := filepath.Join(tempDir, "chart")
+	util.WriteFiles(
+		t,
+		chartDir,
+		map[string]string{
+			"Chart.yaml": `
+apiVersion: v2
+name: test-chart
+version: 0.1.0
+`,
+			"values.yaml": `
+port: 80
+`,
+			"templates/service.yaml": `
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+spec:
+  ports:
+    - port: {{ .Values.port }}
+`,
+		},
+	)
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		clusterConfig: cluster.Config{
+			Cluster:     "testCluster",
+			Environment: "testEnvironment",
+		},
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"source": "testdata/app2",
+			"parameters": map[string]interface{}{
+				"value2":         "Value2",
+				"serviceAccount": "",
+			},
+			"set": schema.NewSet(schema.HashString, []interface{}{}),
+			"helm": []interface{}{
+				map[string]interface{}{
+					"chart":        chartDir,
+					"release_name": "test-release",
+					"namespace":    "test-namespace",
+					"values":       "port: 8080",
+				},
+			},
+		},
+	}
+
+	expandResult, err := providerCtx.expand(ctx, data)
+	require.NoError(t, err)
+	defer providerCtx.cleanExpanded(expandResult)
+
+	assert.Equal(t, 2, len(expandResult.resources))
+	assert.Contains(t, expandResult.resources, "v1.Service.testNamespace2.testName")
+	assert.Contains(t, expandResult.resources, "v1.Service.test-namespace.test-release")
+	assert.Contains(
+		t,
+		expandResult.expandedFiles["test-chart/templates/service.yaml"],
+		"port: 8080",
+	)
+
+	// Charts can be rendered without a source
+	delete(data.newValues, "source")
+
+	expandResult, err = providerCtx.expand(ctx, data)
+	require.NoError(t, err)
+	defer providerCtx.cleanExpanded(expandResult)
+
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"v1.Service.test-namespace.test-release": expandResult.manifests[0].Hash,
+		},
+		expandResult.resources,
+	)
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+)
+
+// ignoreFieldsSchema returns the schema for the ignore_fields blocks of the provider and
+// profiles.
+func ignoreFieldsSchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Description: description,
+		Optional:    true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"kind": {
+					Type:        schema.TypeString,
+					Description: "Kind of the objects that this rule applies to; applies to all kinds if unset",
+					Optional:    true,
+					Default:     "",
+				},
+				"name": {
+					Type:        schema.TypeString,
+					Description: "Name of the objects that this rule applies to; applies to all names if unset",
+					Optional:    true,
+					Default:     "",
+				},
+				"paths": {
+					Type:        schema.TypeList,
+					Description: "Paths of the fields to ignore, e.g. spec.replicas or spec.template.spec.containers[name=istio-proxy]",
+					Required:    true,
+					MinItems:    1,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+			},
+		},
+	}
+}
+
+// getIgnoreRules returns the rules in the ignore_fields blocks of the argument provider or
+// resource.
+func getIgnoreRules(data resourceGetter) ([]diff.IgnoreRule, error) {
+	rules := []diff.IgnoreRule{}
+
+	ruleRows, _ := data.Get("ignore_fields").([]interface{})
+	for _, ruleRow := range ruleRows {
+		fields := mapGetter(ruleRow.(map[string]interface{}))
+
+		kind, _ := fields.Get("kind").(string)
+		name, _ := fields.Get("name").(string)
+		rule := diff.IgnoreRule{
+			Kind:  kind,
+			Name:  name,
+			Paths: getStringList(fields, "paths"),
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ignoreRules returns the ignore rules of the provider along with those of the argument
+// resource.
+func (p *providerContext) ignoreRules(data resourceGetter) ([]diff.IgnoreRule, error) {
+	rules, err := getIgnoreRules(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]diff.IgnoreRule{}, p.ignoreFields...), rules...), nil
+}
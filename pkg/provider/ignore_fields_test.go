@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	providerCtx := &providerContext{
+		ignoreFields: []diff.IgnoreRule{
+			{
+				Paths: []string{"status"},
+			},
+		},
+	}
+
+	rules, err := providerCtx.ignoreRules(
+		mapGetter{
+			"ignore_fields": []interface{}{
+				map[string]interface{}{
+					"kind":  "Deployment",
+					"name":  "",
+					"paths": []interface{}{"spec.replicas", "metadata.annotations[\"a/b\"]"},
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]diff.IgnoreRule{
+			{
+				Paths: []string{"status"},
+			},
+			{
+				Kind:  "Deployment",
+				Paths: []string{"spec.replicas", "metadata.annotations[\"a/b\"]"},
+			},
+		},
+		rules,
+	)
+
+	// Resources without ignore_fields just get the provider rules
+	rules, err = providerCtx.ignoreRules(mapGetter{})
+	require.NoError(t, err)
+	assert.Equal(t, providerCtx.ignoreFields, rules)
+
+	_, err = providerCtx.ignoreRules(
+		mapGetter{
+			"ignore_fields": []interface{}{
+				map[string]interface{}{
+					"kind":  "Deployment",
+					"paths": []interface{}{"spec.containers[name=app"},
+				},
+			},
+		},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unterminated bracket")
+}
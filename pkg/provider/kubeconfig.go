@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"text/template"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,6 +31,88 @@ type Exec struct {
 	Args       []string
 }
 
+// authSchema returns the schema for the API and auth settings of a cluster. These are used in
+// the provider and in each of its cluster blocks.
+func authSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"client_certificate": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "PEM-encoded client certificate for mTLS",
+		},
+		"client_key": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "PEM-encoded client key for mTLS",
+		},
+		"cluster_ca_certificate": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "PEM-encoded root certificates bundle for TLS authentication.",
+		},
+		"config_path": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Path to kubeconfig to use for cluster access",
+		},
+		"exec": {
+			Type:     schema.TypeList,
+			Optional: true,
+			MaxItems: 1,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"api_version": {
+						Type:     schema.TypeString,
+						Optional: true,
+						Default:  "client.authentication.k8s.io/v1beta1",
+					},
+					"command": {
+						Type:     schema.TypeString,
+						Required: true,
+					},
+					"env": {
+						Type:     schema.TypeMap,
+						Optional: true,
+						Elem:     &schema.Schema{Type: schema.TypeString},
+					},
+					"args": {
+						Type:     schema.TypeList,
+						Optional: true,
+						Elem:     &schema.Schema{Type: schema.TypeString},
+					},
+				},
+			},
+			Description: "",
+		},
+		"host": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The hostname (in form of URI) of Kubernetes master",
+		},
+		"insecure": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     false,
+			Description: "Skip TLS hostname verification",
+		},
+		"password": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Password for basic HTTP auth",
+		},
+		"token": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Token to authenticate with the Kubernetes API",
+		},
+		"username": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Username for basic HTTP auth",
+		},
+	}
+}
+
 func createKubeConfig(data resourceGetter, tempDir string) (string, error) {
 	existingKubeConfigPath := data.Get("config_path").(string)
 	if existingKubeConfigPath != "" {
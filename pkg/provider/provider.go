@@ -6,19 +6,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 	log "github.com/sirupsen/logrus"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Provider is the entrypoint for creating a new kubeapply terraform provider instance.
 func Provider(providerCtx *providerContext) *schema.Provider {
-	return &schema.Provider{
+	provider := &schema.Provider{
 		ConfigureContextFunc: func(
 			ctx context.Context,
 			data *schema.ResourceData,
@@ -33,9 +33,11 @@ func Provider(providerCtx *providerContext) *schema.Provider {
 		Schema: map[string]*schema.Schema{
 			// Basic info about the cluster
 			"cluster_name": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Name of the cluster",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				Description:  "Name of the cluster",
+				AtLeastOneOf: []string{"cluster_name", "cluster"},
 			},
 			"cluster_version": {
 				Type:        schema.TypeString,
@@ -44,104 +46,37 @@ func Provider(providerCtx *providerContext) *schema.Provider {
 				Description: "Cluster Kubernetes version",
 			},
 			"region": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Region",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				Description:  "Region",
+				AtLeastOneOf: []string{"region", "cluster"},
 			},
 			"environment": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Account environment",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				Description:  "Account environment",
+				AtLeastOneOf: []string{"environment", "cluster"},
 			},
 			"account_name": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Account name",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				Description:  "Account name",
+				AtLeastOneOf: []string{"account_name", "cluster"},
 			},
 			"account_id": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Account ID",
-			},
-
-			// Cluster API and auth information
-			"client_certificate": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "PEM-encoded client certificate for mTLS",
-			},
-			"client_key": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "PEM-encoded client key for mTLS",
-			},
-			"cluster_ca_certificate": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "PEM-encoded root certificates bundle for TLS authentication.",
-			},
-			"config_path": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Path to kubeconfig to use for cluster access",
-			},
-			"exec": {
-				Type:     schema.TypeList,
-				Optional: true,
-				MaxItems: 1,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"api_version": {
-							Type:     schema.TypeString,
-							Optional: true,
-							Default:  "client.authentication.k8s.io/v1beta1",
-						},
-						"command": {
-							Type:     schema.TypeString,
-							Required: true,
-						},
-						"env": {
-							Type:     schema.TypeMap,
-							Optional: true,
-							Elem:     &schema.Schema{Type: schema.TypeString},
-						},
-						"args": {
-							Type:     schema.TypeList,
-							Optional: true,
-							Elem:     &schema.Schema{Type: schema.TypeString},
-						},
-					},
-				},
-				Description: "",
-			},
-			"host": {
 				Type:         schema.TypeString,
 				Optional:     true,
-				Description:  "The hostname (in form of URI) of Kubernetes master",
-				AtLeastOneOf: []string{"host", "config_path"},
-			},
-			"insecure": {
-				Type:        schema.TypeBool,
-				Optional:    true,
-				Default:     false,
-				Description: "Skip TLS hostname verification",
-			},
-			"password": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Password for basic HTTP auth",
-			},
-			"token": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Token to authenticate with the Kubernetes API",
-			},
-			"username": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Username for basic HTTP auth",
+				Default:      "",
+				Description:  "Account ID",
+				AtLeastOneOf: []string{"account_id", "cluster"},
 			},
 
+			// Clusters for multi-cluster mode
+			"cluster": clustersSchema(),
+
 			// Optional behavior settings
 			"auto_create_namespaces": {
 				Type:        schema.TypeBool,
@@ -155,18 +90,48 @@ func Provider(providerCtx *providerContext) *schema.Provider {
 				Default:     true,
 				Optional:    true,
 			},
+			"delete_policy": deletePolicySchema(
+				"Policy for deleting resources that are removed from terraform; applies to all profiles and manifests",
+			),
+			"delete_timeout": {
+				Type:        schema.TypeString,
+				Description: "Maximum amount of time to wait for deleted resources to be removed, as a duration string; deletes don't wait if unset",
+				Default:     "",
+				Optional:    true,
+			},
 			"diff_context_lines": {
 				Type:        schema.TypeInt,
 				Description: "Number of lines of context to show on diffs",
 				Default:     2,
 				Optional:    true,
 			},
+			"diff_mode": {
+				Type:        schema.TypeString,
+				Description: "How objects are compared in diffs; either line for unified diffs of the YAML or structural for a list of the changed fields",
+				Default:     "line",
+				Optional:    true,
+			},
+			"field_manager": {
+				Type:        schema.TypeString,
+				Description: "Field manager to use for applies and diffs; defaults to the client's default",
+				Default:     "",
+				Optional:    true,
+			},
+			"force_conflicts": {
+				Type:        schema.TypeBool,
+				Description: "Take ownership of fields managed by others in server-side applies",
+				Default:     false,
+				Optional:    true,
+			},
 			"force_diffs": {
 				Type:        schema.TypeBool,
 				Description: "Force diffs for all resources managed by this provider",
 				Default:     true,
 				Optional:    true,
 			},
+			"ignore_fields": ignoreFieldsSchema(
+				"Fields to ignore in the diffs for all profiles and manifests, e.g. ones that are mutated by controllers",
+			),
 			"max_diff_line_length": {
 				Type:        schema.TypeInt,
 				Description: "Max line length for all resources managed by this provider",
@@ -179,9 +144,49 @@ func Provider(providerCtx *providerContext) *schema.Provider {
 				Default:     3000,
 				Optional:    true,
 			},
+			"native_client": {
+				Type:        schema.TypeBool,
+				Description: "Use an in-process Kubernetes client instead of kubectl and kadiff",
+				Default:     false,
+				Optional:    true,
+			},
+			"report_markdown": {
+				Type:        schema.TypeBool,
+				Description: "Also write a Markdown version of each report in report_path, e.g. for posting in pull request comments",
+				Default:     false,
+				Optional:    true,
+			},
+			"report_path": {
+				Type:        schema.TypeString,
+				Description: "Directory to write a JSON report of the planned changes for each profile to; reports aren't written if unset",
+				Default:     "",
+				Optional:    true,
+			},
+			"sensitive_kinds": {
+				Type:        schema.TypeList,
+				Description: "Kinds, in addition to Secret, whose values are redacted in diffs and expanded files",
+				Optional:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"sensitive_paths": {
+				Type:        schema.TypeList,
+				Description: "Fields, in kind:dotted.path format, whose values are redacted in diffs and expanded files",
+				Optional:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"server_side_apply": {
+				Type:        schema.TypeBool,
+				Description: "Use server-side applies and diffs for all resources managed by this provider",
+				Default:     false,
+				Optional:    true,
+			},
 			"verbose_applies": {
 				Type:        schema.TypeBool,
-				Description: "Generate verbose output for applies",
+				Description: "Generate verbose output for applies, including a table of the resources that were created or updated",
 				Default:     false,
 				Optional:    true,
 			},
@@ -193,10 +198,22 @@ func Provider(providerCtx *providerContext) *schema.Provider {
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"kubeapply_profile": profileResource(),
+			"kubeapply_manifest": manifestResource(),
+			"kubeapply_profile":  profileResource(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"kubeapply_expanded": expandedDataSource(),
+			"kubeapply_resource": resourceDataSource(),
 		},
-		DataSourcesMap: map[string]*schema.Resource{},
 	}
+
+	// Cluster API and auth information
+	for key, value := range authSchema() {
+		provider.Schema[key] = value
+	}
+	provider.Schema["host"].AtLeastOneOf = []string{"host", "config_path", "cluster"}
+
+	return provider
 }
 
 func providerConfigure(
@@ -221,6 +238,8 @@ func providerConfigure(
 		AccountID:   data.Get("account_id").(string),
 		Environment: data.Get("environment").(string),
 		Version:     data.Get("cluster_version").(string),
+
+		ServerSideApply: data.Get("server_side_apply").(bool),
 	}
 
 	tempDir, err := ioutil.TempDir("", "kubeapply_kubeconfig_")
@@ -233,10 +252,14 @@ func providerConfigure(
 		return nil, diag.FromErr(err)
 	}
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	if err != nil {
 		return nil, diag.FromErr(err)
 	}
+	registerSourceFetcher(sourceFetcher)
 
 	log.Infof("Setting provider kubeconfig path to %s", kubeConfigPath)
 	clusterConfig.KubeConfigPath = kubeConfigPath
@@ -244,37 +267,48 @@ func providerConfigure(
 	// We require at least a host or a kubeconfig to run
 	canRun := data.Get("host").(string) != "" || data.Get("config_path") != ""
 
-	var clusterClient cluster.Client
-	var rawClient *kubernetes.Clientset
+	diffConfig := diff.DiffConfig{
+		ContextLines:  data.Get("diff_context_lines").(int),
+		MaxLineLength: data.Get("max_diff_line_length").(int),
+		MaxSize:       data.Get("max_diff_size").(int),
+		Mode:          diff.DiffMode(data.Get("diff_mode").(string)),
+
+		SensitiveKinds: getStringList(data, "sensitive_kinds"),
+		SensitivePaths: getStringList(data, "sensitive_paths"),
+	}
+	if err := diffConfig.Validate(); err != nil {
+		return nil, diag.FromErr(err)
+	}
+	nativeClient := data.Get("native_client").(bool)
+
+	ignoreFields, err := getIgnoreRules(data)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
 
+	clients := &clusterClients{}
 	if canRun {
-		log.Info("Creating cluster client")
-		clusterClient, err = cluster.NewKubeClient(
-			ctx,
-			&cluster.ClientConfig{
-				Config: &clusterConfig,
-				// Add extra environment variables that will be used by kadiff to configure diff
-				// outputs
-				ExtraEnv: []string{
-					fmt.Sprintf("KADIFF_CONTEXT_LINES=%d", data.Get("diff_context_lines").(int)),
-					fmt.Sprintf("KADIFF_MAX_SIZE=%d", data.Get("max_diff_size").(int)),
-					fmt.Sprintf("KADIFF_MAX_LINE_LENGTH=%d", data.Get("max_diff_line_length").(int)),
-				},
-			},
-		)
+		clients, err = createClusterClients(ctx, &clusterConfig, diffConfig, nativeClient)
 		if err != nil {
 			return nil, diag.FromErr(err)
 		}
+	}
 
-		log.Info("Creating raw kube client")
-		kubeClientConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
-		if err != nil {
-			return nil, diag.FromErr(err)
-		}
+	clusterTargets, err := getClusterTargets(data, clusterConfig)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	if len(clusterTargets) > 0 {
+		log.Infof("Configured %d clusters for multi-cluster mode", len(clusterTargets))
+	}
 
-		rawClient, err = kubernetes.NewForConfig(kubeClientConfig)
+	var deleteTimeout time.Duration
+	if deleteTimeoutStr := data.Get("delete_timeout").(string); deleteTimeoutStr != "" {
+		deleteTimeout, err = time.ParseDuration(deleteTimeoutStr)
 		if err != nil {
-			return nil, diag.FromErr(err)
+			return nil, diag.FromErr(
+				fmt.Errorf("Could not parse delete_timeout: %+v", err),
+			)
 		}
 	}
 
@@ -283,11 +317,24 @@ func providerConfigure(
 		autoCreateNamespaces: data.Get("auto_create_namespaces").(bool),
 		canRun:               canRun,
 		clusterConfig:        clusterConfig,
-		clusterClient:        clusterClient,
+		clusterClient:        clients.clusterClient,
+		clusters:             newClusterCache(clusterTargets),
 		createdAt:            now,
+		deleteExcludedKinds:  getExcludedKinds(data),
+		deleteTimeout:        deleteTimeout,
+		diffConfig:           diffConfig,
+		dynamicClient:        clients.dynamicClient,
+		fieldManager:         data.Get("field_manager").(string),
+		forceConflicts:       data.Get("force_conflicts").(bool),
 		forceDiffs:           data.Get("force_diffs").(bool),
+		ignoreFields:         ignoreFields,
+		nativeClient:         nativeClient,
 		pid:                  pid,
-		rawClient:            rawClient,
+		rawClient:            clients.rawClient,
+		reportMarkdown:       data.Get("report_markdown").(bool),
+		reportPath:           data.Get("report_path").(string),
+		restMapper:           clients.restMapper,
+		serverSideApply:      data.Get("server_side_apply").(bool),
 		sourceFetcher:        sourceFetcher,
 		tempDir:              tempDir,
 		verboseApplies:       data.Get("verbose_applies").(bool),
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
@@ -15,18 +16,26 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/apply"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/kustomize"
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	// Default value used for sets where inputs are unknown
 	defaultPlaceholder = `"DEFAULT_PLACEHOLDER_VALUE"`
+
+	// Value stored in place of the manifest hash for resources that have been changed outside
+	// of kubeapply
+	driftedResourceHash = "DRIFTED"
 )
 
 type providerContext struct {
@@ -35,11 +44,24 @@ type providerContext struct {
 	canRun               bool
 	clusterClient        cluster.Client
 	clusterConfig        cluster.Config
+	clusters             *clusterCache
 	createdAt            time.Time
+	deleteExcludedKinds  map[string]struct{}
+	deleteTimeout        time.Duration
+	diffConfig           diff.DiffConfig
+	dynamicClient        dynamic.Interface
+	fieldManager         string
+	forceConflicts       bool
 	forceDiffs           bool
+	ignoreFields         []diff.IgnoreRule
 	keepExpanded         bool
+	nativeClient         bool
 	pid                  int
 	rawClient            kubernetes.Interface
+	reportMarkdown       bool
+	reportPath           string
+	restMapper           meta.RESTMapper
+	serverSideApply      bool
 	showExpanded         bool
 	sourceFetcher        *sourceFetcher
 	tempDir              string
@@ -59,7 +81,7 @@ func (p *providerContext) expand(
 	ctx context.Context,
 	data resourceGetter,
 ) (*expandResult, error) {
-	source := data.Get("source").(string)
+	source, _ := data.Get("source").(string)
 	strParams := data.Get("parameters").(map[string]interface{})
 	setParams := data.Get("set").(*schema.Set).List()
 
@@ -104,11 +126,30 @@ func (p *providerContext) expand(
 		fmt.Sprintf("%d", timeStamp),
 	)
 
-	err := p.sourceFetcher.get(ctx, source, expandedDir)
-	if err != nil {
+	if source != "" {
+		sourceVersion, err := p.sourceFetcher.get(ctx, source, expandedDir)
+		if err != nil {
+			return nil, err
+		}
+		if sourceVersion != "" {
+			log.Infof("Fetched %s at %s", source, sourceVersion)
+		}
+		clusterConfig.SourceVersion = sourceVersion
+	}
+
+	// Render charts before the hashing and templating below so that their output is treated
+	// in the same way as the manifests in the source
+	if helmConfig := getHelmConfig(data); helmConfig != nil {
+		if err := p.renderHelmChart(ctx, helmConfig, expandedDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(expandedDir, 0755); err != nil {
 		return nil, err
 	}
 
+	var err error
 	clusterConfig.ConfigHash, err = p.clusterConfigHash(
 		expandedDir,
 		clusterConfig.Parameters,
@@ -121,6 +162,19 @@ func (p *providerContext) expand(
 		return nil, err
 	}
 
+	// Kustomizations are built after templating so that they can use the cluster config and
+	// parameters
+	if err := kustomize.BuildAll(expandedDir); err != nil {
+		return nil, err
+	}
+
+	// Sensitive values are redacted in the expanded files since these can end up in the plan
+	// output and state
+	redactor, err := diff.NewRedactor(p.diffConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	expandedFiles := map[string]interface{}{}
 
 	err = filepath.Walk(expandedDir, func(path string, info fs.FileInfo, err error) error {
@@ -141,7 +195,11 @@ func (p *providerContext) expand(
 			return err
 		}
 
-		expandedFiles[relPath] = string(contents)
+		redactedContents, err := redactor.RedactYAML(contents)
+		if err != nil {
+			return err
+		}
+		expandedFiles[relPath] = string(redactedContents)
 
 		return nil
 	})
@@ -182,48 +240,106 @@ func (p *providerContext) shouldDiff(data resourceChanger) bool {
 	}
 }
 
+// serverSideOptions returns the server-side apply settings for the argument resource. The
+// resource settings can turn on server-side applies and forced conflicts, but not turn them
+// off if they're enabled in the provider.
+func (p *providerContext) serverSideOptions(data resourceGetter) kube.ServerSideOptions {
+	serverSideApply, _ := data.Get("server_side_apply").(bool)
+	forceConflicts, _ := data.Get("force_conflicts").(bool)
+
+	options := kube.ServerSideOptions{
+		Enabled:        p.serverSideApply || serverSideApply,
+		FieldManager:   p.fieldManager,
+		ForceConflicts: p.forceConflicts || forceConflicts,
+	}
+
+	if fieldManager, _ := data.Get("field_manager").(string); fieldManager != "" {
+		options.FieldManager = fieldManager
+	}
+
+	return options
+}
+
 func (p *providerContext) diff(
 	ctx context.Context,
 	path string,
+	serverSide kube.ServerSideOptions,
+	ignoreFields []diff.IgnoreRule,
 ) ([]diff.Result, error) {
-	return p.clusterClient.DiffStructured(ctx, []string{path}, false)
+	return p.clusterClient.DiffStructured(ctx, []string{path}, serverSide, ignoreFields)
 }
 
 func (p *providerContext) apply(
 	ctx context.Context,
 	path string,
 	moduleName string,
+	serverSide kube.ServerSideOptions,
 ) diag.Diagnostics {
 	var diags diag.Diagnostics
-	results, err := p.clusterClient.Apply(ctx, []string{path}, false)
+	var rawResults []byte
+	var results []apply.Result
+	var err error
 
-	log.Infof(
-		"Apply results for %s (err=%+v): %s",
-		moduleName,
-		err,
-		string(results),
-	)
+	if p.verboseApplies {
+		// Get structured results so that we can show which resources changed
+		results, err = p.clusterClient.ApplyStructured(ctx, []string{path}, serverSide)
+		log.Infof(
+			"Apply results for %s (err=%+v): %+v",
+			moduleName,
+			err,
+			results,
+		)
+	} else {
+		rawResults, err = p.clusterClient.Apply(ctx, []string{path}, serverSide)
+		log.Infof(
+			"Apply results for %s (err=%+v): %s",
+			moduleName,
+			err,
+			string(rawResults),
+		)
+	}
 
-	if err != nil {
+	var conflictsErr *kube.ApplyConflictsError
+	if errors.As(err, &conflictsErr) {
+		// Report each conflict separately so that it's clear which resources need attention
+		for _, conflict := range conflictsErr.Conflicts {
+			diags = append(
+				diags,
+				diag.Diagnostic{
+					Severity: diag.Error,
+					Summary: fmt.Sprintf(
+						"Server-side apply conflict for %s in %s",
+						conflict.Resource,
+						moduleName,
+					),
+					Detail: fmt.Sprintf(
+						"%s\n\nSet force_conflicts to take ownership of the conflicting fields.",
+						conflict.Message,
+					),
+				},
+			)
+		}
+		return diags
+	} else if err != nil {
 		diags = append(
 			diags,
 			diag.Diagnostic{
 				Severity: diag.Error,
 				Summary:  err.Error(),
-				Detail:   string(results),
+				Detail:   string(rawResults),
 			},
 		)
 		return diags
 	}
 
 	if p.verboseApplies {
-		// Add kubectl results as a "WARNING" so they show up in apply output
+		// Add apply results as a "WARNING" so they show up in apply output
 		diags = append(
 			diags,
 			diag.Diagnostic{
 				Severity: diag.Warning,
 				Summary:  "kubectl apply successful",
-				Detail:   prettyResults(results),
+				Detail:   apply.ResultsTextTable(results),
 			},
 		)
 	}
@@ -231,6 +347,13 @@ func (p *providerContext) apply(
 	return diags
 }
 
+// deleteOptions returns the options for deleting resources.
+func (p *providerContext) deleteOptions() kube.DeleteOptions {
+	return kube.DeleteOptions{
+		WaitTimeout: p.deleteTimeout,
+	}
+}
+
 func (p *providerContext) canDelete(data resourceChanger) bool {
 	return p.allowDeletes
 }
@@ -256,7 +379,36 @@ func (p *providerContext) delete(
 		return diags
 	}
 
-	results, err := p.clusterClient.Delete(ctx, ids)
+	protected, err := p.protectedIDs(ctx, data, ids)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	deleteIDs := []string{}
+	for _, id := range ids {
+		if reason, ok := protected[id]; ok {
+			diags = append(
+				diags,
+				diag.Diagnostic{
+					Severity: diag.Warning,
+					Summary: fmt.Sprintf(
+						"Not deleting %s in %s because %s; it will be orphaned in the cluster.",
+						id,
+						moduleName(data),
+						reason,
+					),
+				},
+			)
+		} else {
+			deleteIDs = append(deleteIDs, id)
+		}
+	}
+	if len(deleteIDs) == 0 {
+		return diags
+	}
+
+	results, err := p.clusterClient.Delete(ctx, deleteIDs, p.deleteOptions())
 	log.Infof(
 		"Delete results for %s (err=%+v): %s",
 		moduleName(data),
@@ -264,7 +416,30 @@ func (p *providerContext) delete(
 		string(results),
 	)
 
-	if err != nil {
+	var timeoutErr *kube.DeleteTimeoutError
+	if errors.As(err, &timeoutErr) {
+		// Report each stuck resource separately so that it's clear which finalizers need
+		// attention
+		for _, stuck := range timeoutErr.Stuck {
+			diags = append(
+				diags,
+				diag.Diagnostic{
+					Severity: diag.Error,
+					Summary: fmt.Sprintf(
+						"Resource %s in %s is stuck terminating",
+						stuck.ID,
+						moduleName(data),
+					),
+					Detail: fmt.Sprintf(
+						"The resource was still in the cluster after %s. Remaining finalizers: %s",
+						p.deleteTimeout,
+						strings.Join(stuck.Finalizers, ", "),
+					),
+				},
+			)
+		}
+		return diags
+	} else if err != nil {
 		diags = append(
 			diags,
 			diag.Diagnostic{
@@ -287,6 +462,67 @@ func (p *providerContext) delete(
 	return diags
 }
 
+// hashes returns the live hashes of the resources associated with the argument ids. The
+// fields ignored by the provider and the argument resource are left out, the same as in
+// diffs.
+func (p *providerContext) hashes(
+	ctx context.Context,
+	data resourceGetter,
+	ids []string,
+) (map[string]string, error) {
+	ignoreFields, err := p.ignoreRules(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.clusterClient.Hashes(ctx, ids, ignoreFields)
+}
+
+// setLiveHashes stores the hashes of the live versions of the resources in the argument
+// resource. These are compared against the cluster state in subsequent reads to detect
+// drift.
+func (p *providerContext) setLiveHashes(
+	ctx context.Context,
+	data resourceChangerSetter,
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	resources := data.Get("resources").(map[string]interface{})
+	ids := []string{}
+	for id := range resources {
+		ids = append(ids, id)
+	}
+
+	hashes, err := p.hashes(ctx, data, ids)
+	if err != nil {
+		// Don't fail the apply since drift detection is best-effort
+		diags = append(
+			diags,
+			diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary: fmt.Sprintf(
+					"Could not get live hashes for %s: %+v",
+					moduleName(data),
+					err,
+				),
+			},
+		)
+		hashes = map[string]string{}
+	}
+
+	liveHashes := map[string]interface{}{}
+	for id, hash := range hashes {
+		if hash != "" {
+			liveHashes[id] = hash
+		}
+	}
+
+	if err := data.Set("live_hashes", liveHashes); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+	}
+	return diags
+}
+
 func (p *providerContext) shouldShowExpanded(data resourceChanger) bool {
 	return data.Get("show_expanded").(bool)
 }
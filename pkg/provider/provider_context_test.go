@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandKustomization(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_kustomize_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		clusterConfig: cluster.Config{
+			Cluster:     "testCluster",
+			Environment: "testEnvironment",
+		},
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"source": "testdata/app3",
+			"parameters": map[string]interface{}{
+				"namespace": "testNamespace",
+				"imageTag":  "v1.2.3",
+			},
+			"set": schema.NewSet(schema.HashString, []interface{}{}),
+		},
+	}
+
+	expandResult, err := providerCtx.expand(ctx, data)
+	require.NoError(t, err)
+	defer providerCtx.cleanExpanded(expandResult)
+
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"apps/v1.Deployment.testNamespace.testName": expandResult.manifests[0].Hash,
+		},
+		expandResult.resources,
+	)
+	assert.Equal(t, []string{"kustomized.yaml"}, mapKeys(expandResult.expandedFiles))
+
+	contents := expandResult.expandedFiles["kustomized.yaml"].(string)
+	assert.Contains(t, contents, "image: example/app:v1.2.3\n")
+	assert.Contains(t, contents, "environment: testEnvironment\n")
+}
+
+func mapKeys(values map[string]interface{}) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
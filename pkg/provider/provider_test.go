@@ -6,9 +6,12 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -158,3 +161,218 @@ users:
 		strings.TrimSpace(string(kubeConfig)),
 	)
 }
+
+func TestProviderServerSideOptions(t *testing.T) {
+	providerCtx := &providerContext{
+		fieldManager: "providerManager",
+	}
+
+	assert.Equal(
+		t,
+		kube.ServerSideOptions{
+			FieldManager: "providerManager",
+		},
+		providerCtx.serverSideOptions(
+			fakeDiffChangerSetter{
+				newValues: map[string]interface{}{
+					"field_manager":     "",
+					"force_conflicts":   false,
+					"server_side_apply": false,
+				},
+			},
+		),
+	)
+	assert.Equal(
+		t,
+		kube.ServerSideOptions{
+			Enabled:        true,
+			FieldManager:   "profileManager",
+			ForceConflicts: true,
+		},
+		providerCtx.serverSideOptions(
+			fakeDiffChangerSetter{
+				newValues: map[string]interface{}{
+					"field_manager":     "profileManager",
+					"force_conflicts":   true,
+					"server_side_apply": true,
+				},
+			},
+		),
+	)
+
+	providerCtx.serverSideApply = true
+	assert.True(
+		t,
+		providerCtx.serverSideOptions(
+			fakeDiffChangerSetter{
+				newValues: map[string]interface{}{
+					"field_manager":     "",
+					"force_conflicts":   false,
+					"server_side_apply": false,
+				},
+			},
+		).Enabled,
+	)
+}
+
+func TestProviderVerboseApply(t *testing.T) {
+	ctx := context.Background()
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "test-cluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		clusterClient: clusterClient,
+	}
+	diags := providerCtx.apply(ctx, "test-path", "test-module", kube.ServerSideOptions{})
+	assert.Equal(t, 0, len(diags))
+
+	providerCtx.verboseApplies = true
+	diags = providerCtx.apply(ctx, "test-path", "test-module", kube.ServerSideOptions{})
+	require.Equal(t, 1, len(diags))
+	assert.Equal(t, diag.Warning, diags[0].Severity)
+	assert.Contains(t, diags[0].Detail, "result")
+	assert.Contains(t, diags[0].Detail, "Deployment")
+
+	fakeClient := clusterClient.(*cluster.FakeClient)
+	require.Equal(t, 2, len(fakeClient.Calls))
+	assert.Equal(t, "Apply", fakeClient.Calls[0].CallType)
+	assert.Equal(t, "ApplyStructured", fakeClient.Calls[1].CallType)
+}
+
+func TestProviderDeleteStuck(t *testing.T) {
+	ctx := context.Background()
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "test-cluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	clusterClient.(*cluster.FakeClient).StuckResources = []kube.StuckResource{
+		{
+			ID:         "v1.Namespace..test-namespace",
+			Finalizers: []string{"kubernetes"},
+		},
+	}
+
+	providerCtx := &providerContext{
+		allowDeletes:  true,
+		clusterClient: clusterClient,
+	}
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{},
+	}
+
+	// Deletes don't wait by default
+	diags := providerCtx.delete(ctx, data, []string{"v1.Namespace..test-namespace"})
+	require.False(t, diags.HasError())
+
+	providerCtx.deleteTimeout = time.Minute
+	diags = providerCtx.delete(ctx, data, []string{"v1.Namespace..test-namespace"})
+	require.Equal(t, 1, len(diags))
+	assert.Equal(t, diag.Error, diags[0].Severity)
+	assert.Equal(
+		t,
+		"Resource v1.Namespace..test-namespace in module is stuck terminating",
+		diags[0].Summary,
+	)
+	assert.Contains(t, diags[0].Detail, "Remaining finalizers: kubernetes")
+}
+
+func TestProviderDeleteProtected(t *testing.T) {
+	ctx := context.Background()
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "test-cluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	fakeClient := clusterClient.(*cluster.FakeClient)
+	fakeClient.LiveAnnotations = map[string]map[string]string{
+		"v1.ConfigMap.test-namespace.protected": {
+			preventDestroyAnnotation: "true",
+		},
+		"v1.ConfigMap.test-namespace.unprotected": {
+			preventDestroyAnnotation: "false",
+		},
+	}
+
+	providerCtx := &providerContext{
+		allowDeletes:  true,
+		clusterClient: clusterClient,
+		deleteExcludedKinds: map[string]struct{}{
+			"Namespace": {},
+		},
+	}
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"delete_policy": []interface{}{
+				map[string]interface{}{
+					"exclude_kinds": []interface{}{"PersistentVolumeClaim"},
+				},
+			},
+		},
+	}
+
+	diags := providerCtx.delete(
+		ctx,
+		data,
+		[]string{
+			"v1.Namespace..test-namespace",
+			"v1.PersistentVolumeClaim.test-namespace.data",
+			"v1.ConfigMap.test-namespace.protected",
+			"v1.ConfigMap.test-namespace.unprotected",
+		},
+	)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	summaries := []string{}
+	for _, diagnostic := range diags {
+		summaries = append(summaries, diagnostic.Summary)
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"Not deleting v1.Namespace..test-namespace in module because kind Namespace is excluded by delete_policy; it will be orphaned in the cluster.",
+			"Not deleting v1.PersistentVolumeClaim.test-namespace.data in module because kind PersistentVolumeClaim is excluded by delete_policy; it will be orphaned in the cluster.",
+			"Not deleting v1.ConfigMap.test-namespace.protected in module because it has the kubeapply.segment.com/prevent-destroy annotation; it will be orphaned in the cluster.",
+			"kubectl delete successful",
+		},
+		summaries,
+	)
+
+	require.Equal(t, 2, len(fakeClient.Calls))
+	assert.Equal(
+		t,
+		[]string{
+			"v1.ConfigMap.test-namespace.protected",
+			"v1.ConfigMap.test-namespace.unprotected",
+		},
+		fakeClient.Calls[0].Paths,
+	)
+	assert.Equal(t, "Delete", fakeClient.Calls[1].CallType)
+	assert.Equal(
+		t,
+		[]string{"v1.ConfigMap.test-namespace.unprotected"},
+		fakeClient.Calls[1].Paths,
+	)
+
+	// Nothing is deleted if all of the resources are protected
+	fakeClient.Calls = nil
+	diags = providerCtx.delete(ctx, data, []string{"v1.Namespace..test-namespace"})
+	require.Equal(t, 1, len(diags))
+	assert.Equal(t, diag.Warning, diags[0].Severity)
+	assert.Equal(t, 0, len(fakeClient.Calls))
+}
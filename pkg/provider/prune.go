@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+const (
+	// Label that's added to every object applied for a profile. The value is the id of the
+	// profile, which is used to find the objects to prune.
+	profileIDLabel = "kubeapply.segment.com/profile-id"
+
+	// Annotation that's added to every object applied for a profile. The value is the name
+	// of the module that contains the profile.
+	moduleAnnotation = "kubeapply.segment.com/module"
+
+	// Value in the diff for objects that will be pruned in the apply
+	pruneDiffValue = "TO BE PRUNED"
+)
+
+// labelManifests adds the ownership label and annotation to each of the expanded manifests.
+// The hashes in the argument result aren't updated so that the resources of a profile don't
+// depend on its id, which isn't known until the profile is created. The label is left out if
+// the profile id is empty.
+func (p *providerContext) labelManifests(
+	expandResult *expandResult,
+	profileID string,
+	moduleName string,
+) error {
+	paths := []string{}
+	documents := map[string][]string{}
+
+	for _, manifest := range expandResult.manifests {
+		if _, ok := documents[manifest.Path]; !ok {
+			paths = append(paths, manifest.Path)
+		}
+
+		contents := manifest.Contents
+		if manifest.Head.Metadata != nil {
+			var err error
+			contents, err = labelManifest(manifest.Contents, profileID, moduleName)
+			if err != nil {
+				return fmt.Errorf("Could not label %s: %+v", manifest.ID, err)
+			}
+		}
+
+		documents[manifest.Path] = append(documents[manifest.Path], contents)
+	}
+
+	for _, path := range paths {
+		if err := ioutil.WriteFile(
+			path,
+			[]byte(strings.Join(documents[path], "\n---\n")),
+			0644,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func labelManifest(contents string, profileID string, moduleName string) (string, error) {
+	jsonBytes, err := yaml.YAMLToJSON([]byte(contents))
+	if err != nil {
+		return "", err
+	}
+
+	// Use the apimachinery decoder so that integers aren't converted to floats
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := json.Unmarshal(jsonBytes, &obj.Object); err != nil {
+		return "", err
+	}
+
+	if profileID != "" {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[profileIDLabel] = profileID
+		obj.SetLabels(labels)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[moduleAnnotation] = moduleName
+	obj.SetAnnotations(annotations)
+
+	labeledContents, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(labeledContents), nil
+}
+
+// shouldPrune returns whether objects that are labeled for the argument profile but aren't in
+// its manifests should be deleted.
+func (p *providerContext) shouldPrune(data resourceChanger) bool {
+	prune, _ := data.Get("prune").(bool)
+	return prune && p.canDelete(data)
+}
+
+// findOrphans returns the ids of the objects in the cluster that are labeled for the argument
+// profile but aren't in the expanded manifests. Objects in the argument removed ids, which
+// are already being deleted, and objects that are protected from deletion are left out.
+func (p *providerContext) findOrphans(
+	ctx context.Context,
+	data resourceChanger,
+	profileID string,
+	expandResult *expandResult,
+	removed []string,
+) ([]string, error) {
+	labeledIDs, err := p.clusterClient.LabeledIDs(
+		ctx,
+		fmt.Sprintf("%s=%s", profileIDLabel, profileID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list objects to prune: %+v", err)
+	}
+
+	// Compare objects by key since the cluster can return them under different API versions
+	// than the ones in the manifests
+	keys := map[string]struct{}{}
+	for id := range expandResult.resources {
+		keys[kube.ObjectKey(id)] = struct{}{}
+	}
+	for _, id := range removed {
+		keys[kube.ObjectKey(id)] = struct{}{}
+	}
+
+	candidates := []string{}
+	for _, id := range labeledIDs {
+		if _, ok := keys[kube.ObjectKey(id)]; !ok {
+			candidates = append(candidates, id)
+		}
+	}
+
+	// Skip protected objects here instead of in the delete so that they're not shown as
+	// orphans in every plan
+	protected, err := p.protectedIDs(ctx, data, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []string{}
+	for _, id := range candidates {
+		if _, ok := protected[id]; !ok {
+			orphans = append(orphans, id)
+		}
+	}
+
+	log.Infof("Found %d/%d labeled objects to prune", len(orphans), len(labeledIDs))
+	return orphans, nil
+}
+
+// plannedOrphans returns the ids that were marked to be pruned in the argument planned diff.
+// Objects that are now in the expanded manifests are left out. The cluster isn't checked again
+// so that objects that were labeled after the plan (e.g., by another profile that's being
+// applied concurrently) aren't deleted without having been shown in the plan.
+func plannedOrphans(
+	diffValue map[string]interface{},
+	expandResult *expandResult,
+) []string {
+	keys := map[string]struct{}{}
+	for id := range expandResult.resources {
+		keys[kube.ObjectKey(id)] = struct{}{}
+	}
+
+	orphans := []string{}
+	for id, value := range diffValue {
+		if value != pruneDiffValue {
+			continue
+		}
+		if _, ok := keys[kube.ObjectKey(id)]; ok {
+			continue
+		}
+		orphans = append(orphans, id)
+	}
+
+	sort.Strings(orphans)
+	return orphans
+}
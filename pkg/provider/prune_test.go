@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelManifests(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_prune_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	util.WriteFiles(
+		t,
+		tempDir,
+		map[string]string{
+			"deployment.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test-deployment
+  namespace: test-namespace
+  labels:
+    app: test
+spec:
+  replicas: 1000000
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: test-service
+  namespace: test-namespace
+`,
+		},
+	)
+
+	manifests, err := kube.GetManifests([]string{tempDir})
+	require.NoError(t, err)
+	expandResult := &expandResult{
+		expandedDir: tempDir,
+		manifests:   manifests,
+	}
+
+	providerCtx := &providerContext{}
+	err = providerCtx.labelManifests(expandResult, "test-profile", "test-module")
+	require.NoError(t, err)
+
+	labeledManifests, err := kube.GetManifests([]string{tempDir})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(labeledManifests))
+
+	assert.Equal(t, manifests[0].ID, labeledManifests[0].ID)
+	assert.Contains(t, labeledManifests[0].Contents, "app: test")
+	assert.Contains(t, labeledManifests[0].Contents, "replicas: 1000000")
+	assert.Contains(
+		t,
+		labeledManifests[0].Contents,
+		"kubeapply.segment.com/profile-id: test-profile",
+	)
+	assert.Contains(
+		t,
+		labeledManifests[1].Contents,
+		"kubeapply.segment.com/module: test-module",
+	)
+
+	// The original hashes are kept in the expand result
+	assert.Equal(t, manifests[0].Hash, expandResult.manifests[0].Hash)
+	assert.NotEqual(t, manifests[0].Hash, labeledManifests[0].Hash)
+}
+
+func TestResourceProfilePrune(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_prune_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	fakeClient := clusterClient.(*cluster.FakeClient)
+	fakeClient.LabeledResources = []string{
+		"apps/v1.Deployment.testNamespace2.removed",
+		"v1.ConfigMap.testNamespace2.orphan",
+		"v1.ConfigMap.testNamespace2.protected",
+		"v1.Service.testNamespace2.testName",
+	}
+	fakeClient.LiveAnnotations = map[string]map[string]string{
+		"v1.ConfigMap.testNamespace2.protected": {
+			preventDestroyAnnotation: "true",
+		},
+	}
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		allowDeletes:  true,
+		canRun:        true,
+		clusterClient: clusterClient,
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	parameters := map[string]interface{}{
+		"value2":         "Value2",
+		"serviceAccount": "",
+	}
+	diffData := fakeDiffChangerSetter{
+		id:          "test-profile",
+		newComputed: map[string]struct{}{},
+		oldValues: map[string]interface{}{
+			"parameters": parameters,
+			"resources": map[string]interface{}{
+				"apps/v1.Deployment.testNamespace2.removed": "hash1",
+				"v1.Service.testNamespace2.testName":        "hash2",
+			},
+		},
+		newValues: map[string]interface{}{
+			"no_diff":       false,
+			"parameters":    parameters,
+			"prune":         true,
+			"set":           schema.NewSet(schema.HashString, []interface{}{}),
+			"show_expanded": false,
+			"source":        "testdata/app2",
+		},
+	}
+
+	err = resourceProfileCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"result": "structured diff result for testCluster",
+			"apps/v1.Deployment.testNamespace2.removed": "TO BE DELETED",
+			"v1.ConfigMap.testNamespace2.orphan":        "TO BE PRUNED",
+		},
+		diffData.Get("diff"),
+	)
+	assert.Equal(
+		t,
+		cluster.FakeClientCall{
+			CallType: "LabeledIDs",
+			Paths:    []string{"kubeapply.segment.com/profile-id=test-profile"},
+		},
+		fakeClient.Calls[0],
+	)
+
+	// Planned orphans are deleted in the update after the apply. Objects that are labeled
+	// after the plan aren't pruned.
+	fakeClient.Calls = nil
+	fakeClient.LabeledResources = append(
+		fakeClient.LabeledResources,
+		"v1.ConfigMap.testNamespace2.unplanned",
+	)
+	resources := diffData.Get("resources").(map[string]interface{})
+	data := &fakeChangerSetter{
+		id: "test-profile",
+		oldValues: map[string]interface{}{
+			"resources": diffData.oldValues["resources"],
+		},
+		newValues: map[string]interface{}{
+			"diff":          diffData.Get("diff"),
+			"live_hashes":   map[string]interface{}{},
+			"no_diff":       false,
+			"parameters":    parameters,
+			"prune":         true,
+			"resources":     resources,
+			"set":           schema.NewSet(schema.HashString, []interface{}{}),
+			"show_expanded": false,
+			"source":        "testdata/app2",
+			"wait":          []interface{}{},
+		},
+	}
+
+	diags := resourceProfileUpdate(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+
+	callTypes := []string{}
+	for _, call := range fakeClient.Calls {
+		callTypes = append(callTypes, call.CallType)
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"Annotations",
+			"Delete",
+			"Apply",
+			"Hashes",
+			"Annotations",
+			"Delete",
+			"Hashes",
+		},
+		callTypes,
+	)
+	assert.Equal(
+		t,
+		[]string{"apps/v1.Deployment.testNamespace2.removed"},
+		fakeClient.Calls[1].Paths,
+	)
+	assert.Equal(
+		t,
+		[]string{"v1.ConfigMap.testNamespace2.orphan"},
+		fakeClient.Calls[5].Paths,
+	)
+}
@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	log "github.com/sirupsen/logrus"
+)
+
+// Maximum length of each raw diff in the Markdown reports. This keeps the reports for big
+// profiles under the size limits of Github comments.
+const maxReportDiffLength = 2000
+
+var (
+	//go:embed templates/report.md
+	reportTemplateStr string
+
+	reportTemplate *template.Template
+)
+
+func init() {
+	reportTemplate = template.Must(
+		template.New("report").Funcs(
+			template.FuncMap{
+				"resultsMarkdown": diff.ResultsMarkdown,
+			},
+		).Parse(reportTemplateStr),
+	)
+}
+
+// profileReport is a machine-readable summary of the plan for a single profile. These are
+// written to the provider's report_path after each diff so that CI tools can consume them
+// without parsing the terraform plan output.
+type profileReport struct {
+	Module      string        `json:"module"`
+	ID          string        `json:"id,omitempty"`
+	Source      string        `json:"source"`
+	Cluster     string        `json:"cluster"`
+	DiffSkipped bool          `json:"diffSkipped"`
+	Counts      reportCounts  `json:"counts"`
+	Added       []string      `json:"added"`
+	Updated     []string      `json:"updated"`
+	Removed     []string      `json:"removed"`
+	Unchanged   []string      `json:"unchanged"`
+	Pruned      []string      `json:"pruned"`
+	Diffs       []diff.Result `json:"diffs"`
+
+	// MaxDiffLength is the length that raw diffs are clipped to in the Markdown version of
+	// the report.
+	MaxDiffLength int `json:"-"`
+}
+
+type reportCounts struct {
+	Added        int `json:"added"`
+	Updated      int `json:"updated"`
+	Removed      int `json:"removed"`
+	Unchanged    int `json:"unchanged"`
+	Pruned       int `json:"pruned"`
+	Diffs        int `json:"diffs"`
+	LinesAdded   int `json:"linesAdded"`
+	LinesRemoved int `json:"linesRemoved"`
+}
+
+// writeReport writes a report for the argument profile to the provider's report_path. It's a
+// no-op if report_path isn't set. Reports are informational, so errors writing them are
+// logged as warnings instead of failing the plan.
+func (p *providerContext) writeReport(
+	data resourceDiffChangerSetter,
+	changes resourceChanges,
+	diffs []diff.Result,
+	pruned []string,
+	diffSkipped bool,
+) {
+	if p.reportPath == "" {
+		return
+	}
+
+	if err := p.writeReportFiles(data, changes, diffs, pruned, diffSkipped); err != nil {
+		log.Warnf("Could not write report for %s: %+v", moduleName(data), err)
+	}
+}
+
+func (p *providerContext) writeReportFiles(
+	data resourceDiffChangerSetter,
+	changes resourceChanges,
+	diffs []diff.Result,
+	pruned []string,
+	diffSkipped bool,
+) error {
+	source, _ := data.Get("source").(string)
+	report := profileReport{
+		Module:      moduleName(data),
+		ID:          data.Id(),
+		Source:      source,
+		Cluster:     p.clusterConfig.Cluster,
+		DiffSkipped: diffSkipped,
+		Counts: reportCounts{
+			Added:     len(changes.added),
+			Updated:   len(changes.updated),
+			Removed:   len(changes.removed),
+			Unchanged: len(changes.unchanged),
+			Pruned:    len(pruned),
+			Diffs:     len(diffs),
+		},
+		Added:         nonNil(changes.added),
+		Updated:       nonNil(changes.updated),
+		Removed:       nonNil(changes.removed),
+		Unchanged:     nonNil(changes.unchanged),
+		Pruned:        nonNil(pruned),
+		Diffs:         []diff.Result{},
+		MaxDiffLength: maxReportDiffLength,
+	}
+
+	for _, result := range diffs {
+		result.RawDiff = sanitizeDiff(result.RawDiff)
+		report.Diffs = append(report.Diffs, result)
+		report.Counts.LinesAdded += result.NumAdded
+		report.Counts.LinesRemoved += result.NumRemoved
+	}
+
+	if err := os.MkdirAll(p.reportPath, 0755); err != nil {
+		return fmt.Errorf("Could not create report directory: %+v", err)
+	}
+	basePath := filepath.Join(p.reportPath, reportName(data))
+
+	jsonBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Infof("Writing report for %s to %s.json", report.Module, basePath)
+	if err := ioutil.WriteFile(basePath+".json", jsonBytes, 0644); err != nil {
+		return fmt.Errorf("Could not write report: %+v", err)
+	}
+
+	if p.reportMarkdown {
+		buf := &bytes.Buffer{}
+		if err := reportTemplate.Execute(buf, report); err != nil {
+			return fmt.Errorf("Could not render Markdown report: %+v", err)
+		}
+		if err := ioutil.WriteFile(basePath+".md", buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("Could not write Markdown report: %+v", err)
+		}
+	}
+
+	return nil
+}
+
+// reportName returns the base name of the report files for the argument profile. Profiles
+// don't have ids until they're created, so the name is based on the module name along with
+// a hash of the settings that distinguish profiles in the same module.
+func reportName(data resourceGetter) string {
+	source, _ := data.Get("source").(string)
+	cluster, _ := data.Get("cluster").(string)
+	parameters, _ := data.Get("parameters").(map[string]interface{})
+
+	setValues := map[string]interface{}{}
+	if setParams, ok := data.Get("set").(*schema.Set); ok {
+		for _, setParam := range setParams.List() {
+			rawMap := setParam.(map[string]interface{})
+			setValues[rawMap["name"].(string)] = rawMap["value"]
+		}
+	}
+
+	// Map keys are sorted when marshalled, so this is stable
+	parametersBytes, _ := json.Marshal(parameters)
+	setBytes, _ := json.Marshal(setValues)
+
+	h := sha1.New()
+	fmt.Fprintf(
+		h,
+		"%s\n%s\n%s\n%s",
+		source,
+		cluster,
+		string(parametersBytes),
+		string(setBytes),
+	)
+	return fmt.Sprintf("%s-%x", moduleName(data), h.Sum(nil)[0:6])
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
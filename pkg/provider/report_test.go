@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceProfileReport(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_report_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	reportPath := filepath.Join(tempDir, "reports")
+	providerCtx := &providerContext{
+		allowDeletes:   true,
+		canRun:         true,
+		clusterClient:  clusterClient,
+		clusterConfig:  cluster.Config{Cluster: "testCluster"},
+		reportMarkdown: true,
+		reportPath:     reportPath,
+		sourceFetcher:  sourceFetcher,
+		tempDir:        tempDir,
+	}
+
+	parameters := map[string]interface{}{
+		"value2":         "Value2",
+		"serviceAccount": "",
+	}
+	diffData := fakeDiffChangerSetter{
+		id:          "test-profile",
+		newComputed: map[string]struct{}{},
+		oldValues: map[string]interface{}{
+			"parameters": parameters,
+			"resources": map[string]interface{}{
+				"apps/v1.Deployment.testNamespace2.removed": "hash1",
+				"v1.Service.testNamespace2.testName":        "hash2",
+			},
+		},
+		newValues: map[string]interface{}{
+			"no_diff":       false,
+			"parameters":    parameters,
+			"set":           schema.NewSet(schema.HashString, []interface{}{}),
+			"show_expanded": false,
+			"source":        "testdata/app2",
+		},
+	}
+
+	err = resourceProfileCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+
+	baseName := reportName(diffData)
+	assert.Regexp(t, "^module-[0-9a-f]{12}$", baseName)
+
+	jsonBytes, err := ioutil.ReadFile(filepath.Join(reportPath, baseName+".json"))
+	require.NoError(t, err)
+	report := profileReport{}
+	require.NoError(t, json.Unmarshal(jsonBytes, &report))
+
+	assert.Equal(t, "test-profile", report.ID)
+	assert.Equal(t, "testdata/app2", report.Source)
+	assert.Equal(t, "testCluster", report.Cluster)
+	assert.False(t, report.DiffSkipped)
+	assert.Equal(t, []string{"apps/v1.Deployment.testNamespace2.removed"}, report.Removed)
+	assert.Equal(t, 1, report.Counts.Removed)
+	assert.Equal(t, 1, report.Counts.Diffs)
+	assert.Equal(t, []string{"v1.Service.testNamespace2.testName"}, report.Updated)
+	require.Equal(t, 1, len(report.Diffs))
+	assert.Equal(t, "structured diff result for testCluster", report.Diffs[0].RawDiff)
+
+	markdownBytes, err := ioutil.ReadFile(filepath.Join(reportPath, baseName+".md"))
+	require.NoError(t, err)
+	markdown := string(markdownBytes)
+	assert.Contains(t, markdown, "#### module in testCluster")
+	assert.Contains(t, markdown, "1 removed")
+	assert.Contains(t, markdown, "<summary>result (+0/-0)</summary>")
+	assert.Contains(t, markdown, "```diff\nstructured diff result for testCluster\n```")
+	assert.Contains(t, markdown, "- `apps/v1.Deployment.testNamespace2.removed`")
+
+	// Reports are still written when diffs are skipped
+	os.RemoveAll(reportPath)
+	diffData.newValues["no_diff"] = true
+	providerCtx.reportMarkdown = false
+
+	err = resourceProfileCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+
+	jsonBytes, err = ioutil.ReadFile(filepath.Join(reportPath, baseName+".json"))
+	require.NoError(t, err)
+	report = profileReport{}
+	require.NoError(t, json.Unmarshal(jsonBytes, &report))
+	assert.True(t, report.DiffSkipped)
+	assert.Equal(t, 0, len(report.Diffs))
+
+	_, err = os.Stat(filepath.Join(reportPath, baseName+".md"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Errors writing reports don't fail the plan
+	blockingFile := filepath.Join(tempDir, "blocking")
+	require.NoError(t, ioutil.WriteFile(blockingFile, []byte{}, 0644))
+	providerCtx.reportPath = filepath.Join(blockingFile, "reports")
+
+	err = resourceProfileCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+}
+
+func TestReportName(t *testing.T) {
+	setElem := profileResource().Schema["set"].Elem.(*schema.Resource)
+	newData := func(setValue string) mapGetter {
+		return mapGetter{
+			"source":     "testdata/app2",
+			"cluster":    "",
+			"parameters": map[string]interface{}{"key": "value"},
+			"set": schema.NewSet(
+				schema.HashResource(setElem),
+				[]interface{}{
+					map[string]interface{}{
+						"name":        "image",
+						"value":       setValue,
+						"placeholder": "",
+					},
+				},
+			),
+		}
+	}
+
+	// Profiles that only differ in their set values get different reports
+	assert.Equal(t, reportName(newData(`"app:v1"`)), reportName(newData(`"app:v1"`)))
+	assert.NotEqual(t, reportName(newData(`"app:v1"`)), reportName(newData(`"app:v2"`)))
+}
@@ -20,6 +20,18 @@ type resourceGetter interface {
 var _ resourceGetter = (*schema.ResourceData)(nil)
 var _ resourceGetter = (*schema.ResourceDiff)(nil)
 
+// mapGetter is a resourceGetter for the fields of a nested block.
+type mapGetter map[string]interface{}
+
+func (m mapGetter) GetOk(key string) (interface{}, bool) {
+	value, ok := m[key]
+	return value, ok
+}
+
+func (m mapGetter) Get(key string) interface{} {
+	return m[key]
+}
+
 type resourceChanger interface {
 	resourceGetter
 	HasChange(key string) bool
@@ -33,6 +45,7 @@ var _ resourceChanger = (*schema.ResourceDiff)(nil)
 type resourceChangerSetter interface {
 	resourceChanger
 	Set(key string, value interface{}) error
+	Id() string
 	SetId(id string)
 }
 
@@ -42,12 +55,13 @@ type resourceDiffChangerSetter interface {
 	resourceChanger
 	SetNew(key string, value interface{}) error
 	SetNewComputed(key string) error
+	Id() string
 }
 
 var _ resourceDiffChangerSetter = (*schema.ResourceDiff)(nil)
 
 func moduleName(data resourceGetter) string {
-	source := data.Get("source").(string)
+	source, _ := data.Get("source").(string)
 	components := strings.Split(source, "/")
 	if len(components) >= 3 && components[0] == ".terraform" && components[1] == "modules" {
 		return components[2]
@@ -127,3 +141,14 @@ func getHasUnknownSetValues(changer resourceChanger) bool {
 
 	return false
 }
+
+// getStringList returns the values of the argument string list field.
+func getStringList(data resourceGetter, key string) []string {
+	values := []string{}
+	rawValues, _ := data.Get(key).([]interface{})
+	for _, rawValue := range rawValues {
+		value, _ := rawValue.(string)
+		values = append(values, value)
+	}
+	return values
+}
@@ -9,6 +9,7 @@ import (
 )
 
 type fakeDiffChangerSetter struct {
+	id          string
 	newComputed map[string]struct{}
 	oldValues   map[string]interface{}
 	newValues   map[string]interface{}
@@ -41,8 +42,50 @@ func (f fakeDiffChangerSetter) SetNewComputed(key string) error {
 	return nil
 }
 
+func (f fakeDiffChangerSetter) Id() string {
+	return f.id
+}
+
 var _ resourceDiffChangerSetter = (*fakeDiffChangerSetter)(nil)
 
+type fakeChangerSetter struct {
+	id        string
+	oldValues map[string]interface{}
+	newValues map[string]interface{}
+}
+
+func (f *fakeChangerSetter) Get(key string) interface{} {
+	return f.newValues[key]
+}
+
+func (f *fakeChangerSetter) GetOk(key string) (interface{}, bool) {
+	value, ok := f.newValues[key]
+	return value, ok
+}
+
+func (f *fakeChangerSetter) HasChange(key string) bool {
+	return !reflect.DeepEqual(f.oldValues[key], f.newValues[key])
+}
+
+func (f *fakeChangerSetter) GetChange(key string) (interface{}, interface{}) {
+	return f.oldValues[key], f.newValues[key]
+}
+
+func (f *fakeChangerSetter) Set(key string, value interface{}) error {
+	f.newValues[key] = value
+	return nil
+}
+
+func (f *fakeChangerSetter) Id() string {
+	return f.id
+}
+
+func (f *fakeChangerSetter) SetId(id string) {
+	f.id = id
+}
+
+var _ resourceChangerSetter = (*fakeChangerSetter)(nil)
+
 func TestGetResourceChanges(t *testing.T) {
 	changer := fakeDiffChangerSetter{
 		oldValues: map[string]interface{}{
@@ -0,0 +1,650 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Annotation set by client-side applies; it's removed from imported objects since it just
+// duplicates the rest of the manifest.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// manifestResource defines a new kubeapply_manifest resource instance, which manages a single
+// object from inline YAML.
+func manifestResource() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return resourceManifestCreate(ctx, data, provider)
+		},
+		ReadContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return resourceManifestRead(ctx, data, provider)
+		},
+		UpdateContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return resourceManifestUpdate(ctx, data, provider)
+		},
+		DeleteContext: func(
+			ctx context.Context,
+			data *schema.ResourceData,
+			provider interface{},
+		) diag.Diagnostics {
+			return resourceManifestDelete(ctx, data, provider)
+		},
+		CustomizeDiff: func(
+			ctx context.Context,
+			data *schema.ResourceDiff,
+			provider interface{},
+		) error {
+			return resourceManifestCustomDiff(ctx, data, provider)
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: func(
+				ctx context.Context,
+				data *schema.ResourceData,
+				provider interface{},
+			) ([]*schema.ResourceData, error) {
+				if err := resourceManifestImport(ctx, data, provider); err != nil {
+					return nil, err
+				}
+				return []*schema.ResourceData{data}, nil
+			},
+		},
+		Schema: map[string]*schema.Schema{
+			// Inputs
+			"cluster": {
+				Type:        schema.TypeString,
+				Description: "Name of the provider cluster block to apply this object to; defaults to the provider's top-level cluster",
+				Optional:    true,
+				ForceNew:    true,
+			},
+			"field_manager": {
+				Type:        schema.TypeString,
+				Description: "Field manager to use for applies and diffs; overrides the provider setting",
+				Optional:    true,
+			},
+			"force_conflicts": {
+				Type:        schema.TypeBool,
+				Description: "Take ownership of fields managed by others in server-side applies",
+				Optional:    true,
+			},
+			"server_side_apply": {
+				Type:        schema.TypeBool,
+				Description: "Use server-side applies and diffs for this resource",
+				Optional:    true,
+			},
+			"yaml_body": {
+				Type:        schema.TypeString,
+				Description: "YAML contents of a single Kubernetes object",
+				Required:    true,
+			},
+
+			// Computed fields
+			"diff": {
+				Type:        schema.TypeMap,
+				Description: "Diff result from applying the object",
+				Computed:    true,
+			},
+			"live_hash": {
+				Type:        schema.TypeString,
+				Description: "Hash of the applied fields in the live version of the object; used for drift detection",
+				Computed:    true,
+			},
+			"resource_hash": {
+				Type:        schema.TypeString,
+				Description: "Hash of the object",
+				Computed:    true,
+			},
+			"resource_id": {
+				Type:        schema.TypeString,
+				Description: "ID of the object, in the same format as the keys of a profile's resources",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func resourceManifestCreate(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	if !providerCtx.canRun {
+		err := fmt.Errorf("Cannot create because provider is missing a host or kubeconfig")
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	expandResult, err := providerCtx.expandManifest(data.Get("yaml_body").(string))
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	defer providerCtx.cleanExpanded(expandResult)
+
+	manifest := expandResult.manifests[0]
+	log.Infof("Running create for %s", manifest.ID)
+
+	diags = append(diags, providerCtx.applyManifest(ctx, data, expandResult)...)
+	if diags.HasError() {
+		return diags
+	}
+
+	if err := data.Set("resource_hash", manifest.Hash); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	// Null out diff so it's not persisted and we get a clean diff for the next apply
+	if err := data.Set("diff", map[string]interface{}{}); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	data.SetId(manifest.ID)
+
+	log.Infof("Create successful for %s", manifest.ID)
+	return diags
+}
+
+func resourceManifestRead(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	id, _ := data.Get("resource_id").(string)
+
+	log.Infof("Running read for %s", id)
+
+	if !providerCtx.canRun {
+		// We can't check the live state of the object, so just leave everything as-is
+		log.Infof(
+			"Not checking for drift in %s because the provider is missing a host or kubeconfig",
+			id,
+		)
+		return diags
+	}
+
+	if id == "" {
+		return diags
+	}
+
+	hashes, err := providerCtx.hashes(ctx, data, []string{id})
+	if err != nil {
+		diags = append(
+			diags,
+			diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Could not check for drift in %s: %+v", id, err),
+			},
+		)
+		return diags
+	}
+
+	hash, ok := hashes[id]
+	prevHash, _ := data.Get("live_hash").(string)
+
+	if !ok {
+		// The object couldn't be checked, so leave it as-is
+		return diags
+	} else if hash == "" {
+		// Remove the object from the state so that it's recreated in the next apply
+		log.Infof("Resource %s not found in cluster", id)
+		data.SetId("")
+	} else if prevHash != "" && prevHash != hash {
+		// Keep the previous hash so that the drift persists until the next apply
+		log.Infof("Resource %s has drifted", id)
+		if err := data.Set("resource_hash", driftedResourceHash); err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+			return diags
+		}
+	} else {
+		if err := data.Set("live_hash", hash); err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+			return diags
+		}
+	}
+
+	return diags
+}
+
+func resourceManifestCustomDiff(
+	ctx context.Context,
+	data resourceDiffChangerSetter,
+	provider interface{},
+) error {
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		return err
+	}
+	yamlBody, _ := data.Get("yaml_body").(string)
+
+	if yamlBody == "" || yamlBody == unknownValue {
+		log.Info("Not doing diff for manifest because yaml_body is unknown")
+		if err := data.SetNew(
+			"diff",
+			map[string]interface{}{
+				"": "DIFFS UNKNOWN because yaml_body is unknown",
+			},
+		); err != nil {
+			return err
+		}
+		if err := data.SetNewComputed("resource_id"); err != nil {
+			return err
+		}
+		if err := data.SetNewComputed("resource_hash"); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	expandResult, err := providerCtx.expandManifest(yamlBody)
+	if err != nil {
+		return err
+	}
+	defer providerCtx.cleanExpanded(expandResult)
+
+	manifest := expandResult.manifests[0]
+	log.Infof("Running custom diff for %s", manifest.ID)
+
+	if err := data.SetNew("resource_id", manifest.ID); err != nil {
+		return err
+	}
+	if err := data.SetNew("resource_hash", manifest.Hash); err != nil {
+		return err
+	}
+
+	if !providerCtx.canRun {
+		log.Infof(
+			"Not doing diff for %s because the provider is missing a host or kubeconfig",
+			manifest.ID,
+		)
+		return data.SetNew(
+			"diff",
+			map[string]interface{}{
+				"": "DIFFS UNKNOWN because provider missing host or kubeconfig",
+			},
+		)
+	}
+
+	if !providerCtx.forceDiffs && !data.HasChange("resource_hash") {
+		log.Infof("Skipping diff for %s", manifest.ID)
+		return data.SetNew("diff", map[string]interface{}{})
+	}
+
+	// Changing the identity of the object replaces it, so the old one is shown as removed
+	removed := []string{}
+	oldValue, _ := data.GetChange("resource_id")
+	if oldID, _ := oldValue.(string); oldID != "" && oldID != manifest.ID {
+		removed = append(removed, oldID)
+	}
+
+	if err := providerCtx.createNamespaces(ctx, expandResult.manifests); err != nil {
+		return err
+	}
+
+	ignoreFields, err := providerCtx.ignoreRules(data)
+	if err != nil {
+		return err
+	}
+
+	diffs, err := providerCtx.diff(
+		ctx,
+		expandResult.expandedDir,
+		providerCtx.serverSideOptions(data),
+		ignoreFields,
+	)
+	if err != nil {
+		return err
+	}
+
+	protected := map[string]string{}
+	if providerCtx.canDelete(data) {
+		protected, err = providerCtx.protectedIDs(ctx, data, removed)
+		if err != nil {
+			return err
+		}
+	}
+
+	results := providerCtx.formatDiffs(data, diffs, removed, protected)
+	if len(results) == 0 {
+		// Add an explicit placeholder so that terraform doesn't show "(known after apply)"
+		results[""] = "NO DIFFS FOUND"
+	}
+
+	return data.SetNew("diff", results)
+}
+
+func resourceManifestUpdate(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	if !providerCtx.canRun {
+		err := fmt.Errorf("Cannot update because provider is missing a host or kubeconfig")
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	expandResult, err := providerCtx.expandManifest(data.Get("yaml_body").(string))
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	defer providerCtx.cleanExpanded(expandResult)
+
+	manifest := expandResult.manifests[0]
+	log.Infof("Running update for %s", manifest.ID)
+
+	if oldID := data.Id(); oldID != "" && oldID != manifest.ID {
+		deleteDiags := providerCtx.delete(ctx, data, []string{oldID})
+		diags = append(diags, deleteDiags...)
+		if diags.HasError() {
+			return diags
+		}
+	}
+
+	// Null out diff so it's not persisted and we get a clean diff for the next apply
+	if err := data.Set("diff", map[string]interface{}{}); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	diags = append(diags, providerCtx.applyManifest(ctx, data, expandResult)...)
+	if diags.HasError() {
+		return diags
+	}
+
+	if err := data.Set("resource_hash", manifest.Hash); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	data.SetId(manifest.ID)
+
+	log.Infof("Update successful for %s", manifest.ID)
+	return diags
+}
+
+func resourceManifestDelete(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) diag.Diagnostics {
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if !providerCtx.canRun && providerCtx.canDelete(data) {
+		err := fmt.Errorf("Cannot delete because provider is missing a host or kubeconfig")
+		return diag.FromErr(err)
+	}
+
+	return providerCtx.delete(ctx, data, []string{data.Id()})
+}
+
+// manifestImportID is the JSON format for import ids of objects in named clusters.
+type manifestImportID struct {
+	ID      string `json:"id"`
+	Cluster string `json:"cluster"`
+}
+
+// parseManifestImportID parses the argument import id, which can either be a plain object id or
+// a JSON-encoded manifestImportID.
+func parseManifestImportID(id string) (manifestImportID, error) {
+	importID := manifestImportID{}
+
+	if strings.HasPrefix(strings.TrimSpace(id), "{") {
+		if err := json.Unmarshal([]byte(id), &importID); err != nil {
+			return importID, fmt.Errorf("Could not parse import id as JSON: %+v", err)
+		}
+	} else {
+		importID.ID = id
+	}
+
+	if importID.ID == "" {
+		return importID, fmt.Errorf("Import id must include an object id")
+	}
+
+	return importID, nil
+}
+
+// resourceManifestImport imports an existing object. The import id is the id of the object,
+// in the same format as the keys of a profile's resources, or a JSON object with the id and
+// the name of the cluster.
+func resourceManifestImport(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) error {
+	importID, err := parseManifestImportID(data.Id())
+	if err != nil {
+		return err
+	}
+
+	providerCtx, err := provider.(*providerContext).forCluster(ctx, importID.Cluster)
+	if err != nil {
+		return err
+	}
+
+	if !providerCtx.canRun {
+		return fmt.Errorf("Cannot import because provider is missing a host or kubeconfig")
+	}
+
+	id := importID.ID
+	log.Infof("Running import for %s", id)
+
+	apiVersion, kind, namespace, name, err := kube.ParseManifestID(id)
+	if err != nil {
+		return err
+	}
+
+	obj, err := kube.GetObject(
+		ctx,
+		providerCtx.dynamicClient,
+		providerCtx.restMapper,
+		kubeschema.FromAPIVersionAndKind(apiVersion, kind),
+		namespace,
+		name,
+	)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return fmt.Errorf("Resource %s not found in cluster", id)
+	}
+
+	importObj := kube.WithoutServerFields(obj)
+	unstructured.RemoveNestedField(
+		importObj.Object,
+		"metadata",
+		"annotations",
+		lastAppliedAnnotation,
+	)
+	if len(importObj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(importObj.Object, "metadata", "annotations")
+	}
+
+	// The body is stored in the state, so sensitive values are redacted in the same way as in
+	// the expanded files of profiles. The configured body replaces it in the next apply.
+	redactor, err := diff.NewRedactor(providerCtx.diffConfig)
+	if err != nil {
+		return err
+	}
+	if redactor.RedactObject(importObj.Object) {
+		log.Warnf("Redacted sensitive values in the imported body of %s", id)
+	}
+
+	yamlBody, err := yaml.Marshal(importObj.Object)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := providerCtx.hashes(ctx, data, []string{id})
+	if err != nil {
+		return err
+	}
+
+	// Use the live hash in place of the manifest one so that the next plan does a full diff
+	for key, value := range map[string]interface{}{
+		"cluster":       importID.Cluster,
+		"yaml_body":     string(yamlBody),
+		"resource_id":   id,
+		"resource_hash": hashes[id],
+		"live_hash":     hashes[id],
+		"diff":          map[string]interface{}{},
+	} {
+		if err := data.Set(key, value); err != nil {
+			return err
+		}
+	}
+
+	data.SetId(id)
+
+	log.Infof("Import successful for %s", id)
+	return nil
+}
+
+// expandManifest writes the argument YAML into a temporary directory so that it can be
+// diffed and applied in the same way as the expanded files for a profile.
+func (p *providerContext) expandManifest(yamlBody string) (*expandResult, error) {
+	expandedDir := filepath.Join(
+		p.tempDir,
+		"expanded",
+		fmt.Sprintf("%d", time.Now().UnixNano()),
+	)
+	if err := os.MkdirAll(expandedDir, 0755); err != nil {
+		return nil, err
+	}
+
+	result := &expandResult{
+		expandedDir: expandedDir,
+		expandedFiles: map[string]interface{}{
+			"manifest.yaml": yamlBody,
+		},
+	}
+
+	if err := ioutil.WriteFile(
+		filepath.Join(expandedDir, "manifest.yaml"),
+		[]byte(yamlBody),
+		0644,
+	); err != nil {
+		p.cleanExpanded(result)
+		return nil, err
+	}
+
+	manifests, err := kube.GetManifests([]string{expandedDir})
+	if err != nil {
+		p.cleanExpanded(result)
+		return nil, err
+	}
+	if len(manifests) != 1 {
+		p.cleanExpanded(result)
+		return nil, fmt.Errorf(
+			"yaml_body must contain exactly one resource; found %d",
+			len(manifests),
+		)
+	}
+
+	result.manifests = manifests
+	return result, nil
+}
+
+// applyManifest applies the object in the argument expand result and updates the id and
+// live hash in the argument resource.
+func (p *providerContext) applyManifest(
+	ctx context.Context,
+	data resourceChangerSetter,
+	expandResult *expandResult,
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	manifest := expandResult.manifests[0]
+
+	if err := p.createNamespaces(ctx, expandResult.manifests); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	diags = append(
+		diags,
+		p.apply(ctx, expandResult.expandedDir, manifest.ID, p.serverSideOptions(data))...,
+	)
+	if diags.HasError() {
+		return diags
+	}
+
+	if err := data.Set("resource_id", manifest.ID); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	hashes, err := p.hashes(ctx, data, []string{manifest.ID})
+	if err != nil {
+		// Don't fail the apply since drift detection is best-effort
+		diags = append(
+			diags,
+			diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary: fmt.Sprintf(
+					"Could not get live hash for %s: %+v",
+					manifest.ID,
+					err,
+				),
+			},
+		)
+	}
+
+	if err := data.Set("live_hash", hashes[manifest.ID]); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+	}
+	return diags
+}
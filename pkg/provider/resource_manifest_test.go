@@ -0,0 +1,357 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testManifestYAML = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+  namespace: test-namespace
+data:
+  key: value
+`
+
+func TestResourceManifest(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_manifest_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	fakeClient := clusterClient.(*cluster.FakeClient)
+
+	providerCtx := &providerContext{
+		allowDeletes:  true,
+		canRun:        true,
+		clusterClient: clusterClient,
+		tempDir:       tempDir,
+	}
+
+	// Plan a new object
+	diffData := fakeDiffChangerSetter{
+		newComputed: map[string]struct{}{},
+		oldValues:   map[string]interface{}{},
+		newValues: map[string]interface{}{
+			"yaml_body": testManifestYAML,
+		},
+	}
+	err = resourceManifestCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"v1.ConfigMap.test-namespace.test-config",
+		diffData.Get("resource_id"),
+	)
+	assert.NotEmpty(t, diffData.Get("resource_hash"))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"result": "structured diff result for testCluster",
+		},
+		diffData.Get("diff"),
+	)
+
+	// Create it
+	fakeClient.LiveHashes = map[string]string{
+		"v1.ConfigMap.test-namespace.test-config": "liveHash1",
+	}
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"yaml_body": testManifestYAML,
+		},
+	}
+	diags := resourceManifestCreate(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+	assert.Equal(t, "v1.ConfigMap.test-namespace.test-config", data.Id())
+	assert.Equal(t, diffData.Get("resource_hash"), data.Get("resource_hash"))
+	assert.Equal(t, "liveHash1", data.Get("live_hash"))
+	assert.Equal(t, map[string]interface{}{}, data.Get("diff"))
+
+	// Read after a change outside of terraform
+	fakeClient.LiveHashes["v1.ConfigMap.test-namespace.test-config"] = "liveHash2"
+	diags = resourceManifestRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+	assert.Equal(t, driftedResourceHash, data.Get("resource_hash"))
+	assert.Equal(t, "liveHash1", data.Get("live_hash"))
+
+	// Rename the object, which deletes the old version in the update
+	renamedYAML := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config-renamed
+  namespace: test-namespace
+`
+	diffData = fakeDiffChangerSetter{
+		newComputed: map[string]struct{}{},
+		oldValues: map[string]interface{}{
+			"resource_id": "v1.ConfigMap.test-namespace.test-config",
+		},
+		newValues: map[string]interface{}{
+			"yaml_body":   renamedYAML,
+			"resource_id": "v1.ConfigMap.test-namespace.test-config",
+		},
+	}
+	err = resourceManifestCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"TO BE DELETED",
+		diffData.Get("diff").(map[string]interface{})["v1.ConfigMap.test-namespace.test-config"],
+	)
+
+	fakeClient.Calls = nil
+	data.newValues["yaml_body"] = renamedYAML
+	diags = resourceManifestUpdate(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+	assert.Equal(t, "v1.ConfigMap.test-namespace.test-config-renamed", data.Id())
+	assert.Equal(t, "", data.Get("live_hash"))
+	require.Equal(t, 4, len(fakeClient.Calls))
+	assert.Equal(t, "Annotations", fakeClient.Calls[0].CallType)
+	assert.Equal(t, "Delete", fakeClient.Calls[1].CallType)
+	assert.Equal(
+		t,
+		[]string{"v1.ConfigMap.test-namespace.test-config"},
+		fakeClient.Calls[1].Paths,
+	)
+	assert.Equal(t, "Apply", fakeClient.Calls[2].CallType)
+
+	// Read after the object is removed from the cluster
+	fakeClient.LiveHashes["v1.ConfigMap.test-namespace.test-config-renamed"] = ""
+	diags = resourceManifestRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+	assert.Equal(t, "", data.Id())
+
+	// Multiple objects aren't allowed
+	diffData.newValues["yaml_body"] = testManifestYAML + "---\n" + renamedYAML
+	err = resourceManifestCustomDiff(ctx, diffData, providerCtx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exactly one resource; found 2")
+
+	// Unknown bodies are diffed as computed
+	diffData.newValues["yaml_body"] = unknownValue
+	err = resourceManifestCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+	assert.Contains(t, diffData.newComputed, "resource_id")
+}
+
+func TestResourceManifestImport(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_manifest_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	clusterClient.(*cluster.FakeClient).LiveHashes = map[string]string{
+		"apps/v1.Deployment.test-namespace.test-deployment": "liveHash1",
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		schema.GroupVersionKind{Version: "v1", Kind: "Secret"},
+		meta.RESTScopeNamespace,
+	)
+
+	secretObj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      "test-secret",
+				"namespace": "test-namespace",
+				"annotations": map[string]interface{}{
+					lastAppliedAnnotation: `{"data":{"password":"aHVudGVyMg=="}}`,
+					"team":                "platform",
+				},
+			},
+			"data": map[string]interface{}{
+				"password": "aHVudGVyMg==",
+			},
+		},
+	}
+
+	liveObj := testWaitObject(
+		"apps/v1",
+		"Deployment",
+		"test-deployment",
+		map[string]interface{}{
+			"replicas": int64(1),
+		},
+	)
+	liveObj.SetResourceVersion("10")
+	liveObj.SetAnnotations(
+		map[string]string{
+			lastAppliedAnnotation: "{}",
+		},
+	)
+
+	providerCtx := &providerContext{
+		canRun:        true,
+		clusterClient: clusterClient,
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(
+			runtime.NewScheme(),
+			liveObj,
+			secretObj,
+		),
+		restMapper: mapper,
+		tempDir:    tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		id:        "apps/v1.Deployment.test-namespace.test-deployment",
+		newValues: map[string]interface{}{},
+	}
+	err = resourceManifestImport(ctx, data, providerCtx)
+	require.NoError(t, err)
+
+	yamlBody := data.Get("yaml_body").(string)
+	assert.Contains(t, yamlBody, "name: test-deployment")
+	assert.NotContains(t, yamlBody, "resourceVersion")
+	assert.NotContains(t, yamlBody, "annotations")
+	assert.NotContains(t, yamlBody, "status")
+	assert.Equal(t, "liveHash1", data.Get("resource_hash"))
+	assert.Equal(t, "liveHash1", data.Get("live_hash"))
+
+	// The imported body is a valid manifest for the same object
+	expandResult, err := providerCtx.expandManifest(yamlBody)
+	require.NoError(t, err)
+	defer providerCtx.cleanExpanded(expandResult)
+	assert.Equal(t, data.Id(), expandResult.manifests[0].ID)
+
+	// Sensitive values aren't stored in the state
+	data = &fakeChangerSetter{
+		id:        "v1.Secret.test-namespace.test-secret",
+		newValues: map[string]interface{}{},
+	}
+	err = resourceManifestImport(ctx, data, providerCtx)
+	require.NoError(t, err)
+
+	yamlBody = data.Get("yaml_body").(string)
+	assert.Contains(t, yamlBody, "name: test-secret")
+	assert.Contains(t, yamlBody, "team: platform")
+	assert.Contains(t, yamlBody, "sensitive value")
+	assert.NotContains(t, yamlBody, "aHVudGVyMg==")
+
+	data.id = "apps/v1.Deployment.test-namespace.non-existent"
+	err = resourceManifestImport(ctx, data, providerCtx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found in cluster")
+}
+
+func TestResourceManifestCluster(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_manifest_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	eastClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "eastCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	eastClient.(*cluster.FakeClient).LiveHashes = map[string]string{
+		"v1.ConfigMap.test-namespace.test-config": "liveHash1",
+	}
+
+	// The top-level cluster isn't configured, so only the named one can be used
+	providerCtx := &providerContext{
+		allowDeletes: true,
+		clusters:     newClusterCache(map[string]clusterTarget{"east": {}}),
+		tempDir:      tempDir,
+	}
+	providerCtx.clusters.contexts["east"] = &providerContext{
+		allowDeletes:  true,
+		canRun:        true,
+		clusterClient: eastClient,
+		tempDir:       tempDir,
+	}
+
+	diffData := fakeDiffChangerSetter{
+		newComputed: map[string]struct{}{},
+		oldValues:   map[string]interface{}{},
+		newValues: map[string]interface{}{
+			"cluster":   "east",
+			"yaml_body": testManifestYAML,
+		},
+	}
+	err = resourceManifestCustomDiff(ctx, diffData, providerCtx)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"result": "structured diff result for eastCluster",
+		},
+		diffData.Get("diff"),
+	)
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"cluster":   "east",
+			"yaml_body": testManifestYAML,
+		},
+	}
+	diags := resourceManifestCreate(ctx, data, providerCtx)
+	require.False(t, diags.HasError(), "Unexpected errors: %+v", diags)
+	assert.Equal(t, "v1.ConfigMap.test-namespace.test-config", data.Id())
+	assert.Equal(t, "liveHash1", data.Get("live_hash"))
+
+	data.newValues["cluster"] = "west"
+	diags = resourceManifestRead(ctx, data, providerCtx)
+	require.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "Cluster west is not configured")
+
+	importID, err := parseManifestImportID(
+		`{"cluster": "east", "id": "v1.ConfigMap.test-namespace.test-config"}`,
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		manifestImportID{
+			ID:      "v1.ConfigMap.test-namespace.test-config",
+			Cluster: "east",
+		},
+		importID,
+	)
+
+	_, err = parseManifestImportID(`{"cluster": "east"}`)
+	require.Error(t, err)
+}
@@ -2,12 +2,15 @@ package provider
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/diff"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -52,8 +55,43 @@ func profileResource() *schema.Resource {
 		) error {
 			return resourceProfileCustomDiff(ctx, data, provider)
 		},
+		Importer: &schema.ResourceImporter{
+			StateContext: func(
+				ctx context.Context,
+				data *schema.ResourceData,
+				provider interface{},
+			) ([]*schema.ResourceData, error) {
+				if err := resourceProfileImport(ctx, data, provider); err != nil {
+					return nil, err
+				}
+				return []*schema.ResourceData{data}, nil
+			},
+		},
 		Schema: map[string]*schema.Schema{
 			// Inputs
+			"cluster": {
+				Type:        schema.TypeString,
+				Description: "Name of the provider cluster block to apply this profile to; defaults to the provider's top-level cluster",
+				Optional:    true,
+				ForceNew:    true,
+			},
+			"delete_policy": deletePolicySchema(
+				"Policy for deleting resources that are removed from this profile; combined with the provider setting",
+			),
+			"field_manager": {
+				Type:        schema.TypeString,
+				Description: "Field manager to use for applies and diffs; overrides the provider setting",
+				Optional:    true,
+			},
+			"force_conflicts": {
+				Type:        schema.TypeBool,
+				Description: "Take ownership of fields managed by others in server-side applies",
+				Optional:    true,
+			},
+			"helm": helmSchema(),
+			"ignore_fields": ignoreFieldsSchema(
+				"Fields to ignore in the diffs for this profile, in addition to the ones set in the provider",
+			),
 			"no_diff": {
 				Type:        schema.TypeBool,
 				Description: "Don't do a full diff for this resource",
@@ -64,27 +102,21 @@ func profileResource() *schema.Resource {
 				Description: "Arbitrary parameters that will be used for profile expansion",
 				Optional:    true,
 			},
-			"set": {
-				Type:        schema.TypeSet,
+			"prune": {
+				Type:        schema.TypeBool,
+				Description: "Delete objects that are labeled for this profile but aren't in its manifests",
+				Optional:    true,
+			},
+			"set": setSchema(),
+			"rollback_on_failure": {
+				Type:        schema.TypeBool,
+				Description: "Restore the previous versions of resources if an apply fails",
+				Optional:    true,
+			},
+			"server_side_apply": {
+				Type:        schema.TypeBool,
+				Description: "Use server-side applies and diffs for this resource",
 				Optional:    true,
-				Description: "Custom, JSON-encoded parameters to be merged parameters above",
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"name": {
-							Type:     schema.TypeString,
-							Required: true,
-						},
-						"value": {
-							Type:     schema.TypeString,
-							Required: true,
-						},
-						"placeholder": {
-							Type:     schema.TypeString,
-							Optional: true,
-							Default:  "",
-						},
-					},
-				},
 			},
 			"show_expanded": {
 				Type:        schema.TypeBool,
@@ -92,9 +124,34 @@ func profileResource() *schema.Resource {
 				Optional:    true,
 			},
 			"source": {
-				Type:        schema.TypeString,
-				Description: "Source for profile manifest files in local file system or remote git repo",
-				Required:    true,
+				Type:         schema.TypeString,
+				Description:  "Source for profile manifest files in local file system or remote git repo",
+				Optional:     true,
+				AtLeastOneOf: []string{"helm", "source"},
+			},
+			"wait": {
+				Type:        schema.TypeList,
+				Description: "Wait for applied resources to be ready",
+				Optional:    true,
+				MaxItems:    1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"kinds": {
+							Type:        schema.TypeList,
+							Description: "Kinds to wait on; defaults to all kinds with rollout checks",
+							Optional:    true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"timeout": {
+							Type:        schema.TypeString,
+							Description: "Maximum amount of time to wait, as a duration string",
+							Optional:    true,
+							Default:     "5m",
+						},
+					},
+				},
 			},
 
 			// Computed fields
@@ -108,6 +165,11 @@ func profileResource() *schema.Resource {
 				Description: "Result of expanding templates; only set if show_expanded is set to true",
 				Computed:    true,
 			},
+			"live_hashes": {
+				Type:        schema.TypeMap,
+				Description: "Hashes of the applied fields in the live versions of resources; used for drift detection",
+				Computed:    true,
+			},
 			"resources": {
 				Type:        schema.TypeMap,
 				Description: "Resources in this profile",
@@ -122,6 +184,69 @@ func profileResource() *schema.Resource {
 	}
 }
 
+// helmSchema returns the schema for the helm block that's shared by profiles and expanded data
+// sources.
+func helmSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Description: "Helm chart to render into the profile",
+		Optional:    true,
+		MaxItems:    1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"chart": {
+					Type:        schema.TypeString,
+					Description: "Path or URL of the chart; supports the same formats as source",
+					Required:    true,
+				},
+				"namespace": {
+					Type:        schema.TypeString,
+					Description: "Namespace of the release",
+					Optional:    true,
+					Default:     "default",
+				},
+				"release_name": {
+					Type:        schema.TypeString,
+					Description: "Name of the release",
+					Required:    true,
+				},
+				"values": {
+					Type:        schema.TypeString,
+					Description: "Values for the chart, in YAML format",
+					Optional:    true,
+				},
+			},
+		},
+		AtLeastOneOf: []string{"helm", "source"},
+	}
+}
+
+// setSchema returns the schema for JSON-encoded parameters.
+func setSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeSet,
+		Optional:    true,
+		Description: "Custom, JSON-encoded parameters to be merged parameters above",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"name": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"value": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"placeholder": {
+					Type:     schema.TypeString,
+					Optional: true,
+					Default:  "",
+				},
+			},
+		},
+	}
+}
+
 func resourceProfileCreate(
 	ctx context.Context,
 	data resourceChangerSetter,
@@ -130,7 +255,11 @@ func resourceProfileCreate(
 	var diags diag.Diagnostics
 
 	log.Infof("Running create for %s", moduleName(data))
-	providerCtx := provider.(*providerContext)
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
 
 	if !providerCtx.canRun {
 		err := fmt.Errorf("Cannot create because provider is missing a host or kubeconfig")
@@ -145,22 +274,32 @@ func resourceProfileCreate(
 	}
 	defer providerCtx.cleanExpanded(expandResult)
 
-	applyDiags := providerCtx.apply(
-		ctx,
-		expandResult.expandedDir,
-		moduleName(data),
-	)
+	// Just make up an id from the timestamp; this is generated before the apply so that it
+	// can be used in the ownership labels
+	id := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	if err := providerCtx.labelManifests(expandResult, id, moduleName(data)); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	applyDiags := providerCtx.applyProfile(ctx, data, expandResult)
 	diags = append(diags, applyDiags...)
 
 	if diags.HasError() {
 		return diags
 	}
 
+	// Record the applied resources before waiting so that they're tracked (and the profile is
+	// tainted) even if they don't become ready.
 	if err := data.Set("resources", expandResult.resources); err != nil {
 		diags = append(diags, diag.FromErr(err)...)
 		return diags
 	}
 
+	liveHashesDiags := providerCtx.setLiveHashes(ctx, data)
+	diags = append(diags, liveHashesDiags...)
+
 	// Null out diff and expanded_files so they're not persisted and we get a clean diff for the
 	// next apply.
 	if err := data.Set("diff", map[string]interface{}{}); err != nil {
@@ -172,8 +311,14 @@ func resourceProfileCreate(
 		return diags
 	}
 
-	// Just make up an id from the timestamp
-	data.SetId(fmt.Sprintf("%d", time.Now().UnixNano()))
+	data.SetId(id)
+
+	waitDiags := providerCtx.wait(ctx, data, expandResult.manifests)
+	diags = append(diags, waitDiags...)
+
+	if diags.HasError() {
+		return diags
+	}
 
 	log.Infof("Create successful for %s", moduleName(data))
 	return diags
@@ -187,8 +332,84 @@ func resourceProfileRead(
 	log.Infof("Running read for %s", moduleName(data))
 	var diags diag.Diagnostics
 
-	// There's nothing to do here since we only read the remote kube state if we're doing a
-	// diff.
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
+	if !providerCtx.canRun {
+		// We can't check the live state of the resources, so just leave everything as-is
+		log.Infof(
+			"Not checking for drift in %s because the provider is missing a host or kubeconfig",
+			moduleName(data),
+		)
+		return diags
+	}
+
+	resources := data.Get("resources").(map[string]interface{})
+	if len(resources) == 0 {
+		return diags
+	}
+
+	ids := []string{}
+	for id := range resources {
+		ids = append(ids, id)
+	}
+
+	hashes, err := providerCtx.hashes(ctx, data, ids)
+	if err != nil {
+		diags = append(
+			diags,
+			diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary: fmt.Sprintf(
+					"Could not check for drift in %s: %+v",
+					moduleName(data),
+					err,
+				),
+			},
+		)
+		return diags
+	}
+
+	prevHashes := data.Get("live_hashes").(map[string]interface{})
+	updatedResources := map[string]interface{}{}
+	updatedHashes := map[string]interface{}{}
+
+	for id, value := range resources {
+		hash, ok := hashes[id]
+		prevHash, hasPrevHash := prevHashes[id]
+
+		if !ok {
+			// The resource couldn't be checked, so leave it as-is
+			updatedResources[id] = value
+			if hasPrevHash {
+				updatedHashes[id] = prevHash
+			}
+		} else if hash == "" {
+			// Remove the resource so that it's treated as an addition in the next diff
+			log.Infof("Resource %s in %s not found in cluster", id, moduleName(data))
+		} else if hasPrevHash && prevHash != hash {
+			// Keep the previous hash so that the drift persists until the next apply
+			log.Infof("Resource %s in %s has drifted", id, moduleName(data))
+			updatedResources[id] = driftedResourceHash
+			updatedHashes[id] = prevHash
+		} else {
+			updatedResources[id] = value
+			updatedHashes[id] = hash
+		}
+	}
+
+	if err := data.Set("resources", updatedResources); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+	if err := data.Set("live_hashes", updatedHashes); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
+
 	return diags
 }
 
@@ -199,7 +420,10 @@ func resourceProfileCustomDiff(
 ) error {
 	log.Infof("Running custom diff for %s", moduleName(data))
 
-	providerCtx := provider.(*providerContext)
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		return err
+	}
 
 	hasUnknownParameters := getHasUnknownParameters(data)
 	hasUnknownSetValues := getHasUnknownSetValues(data)
@@ -315,18 +539,48 @@ func resourceProfileCustomDiff(
 		moduleName(data),
 	)
 
+	orphans := []string{}
+	if providerCtx.shouldPrune(data) && data.Id() != "" {
+		orphans, err = providerCtx.findOrphans(
+			ctx,
+			data,
+			data.Id(),
+			expandResult,
+			changes.removed,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	if providerCtx.shouldDiff(data) {
 		log.Infof(
 			"Doing full diff for %s",
 			moduleName(data),
 		)
-		var results map[string]interface{}
-
 		if err := providerCtx.createNamespaces(ctx, expandResult.manifests); err != nil {
 			return err
 		}
 
-		diffs, err := providerCtx.diff(ctx, expandResult.expandedDir)
+		if err := providerCtx.labelManifests(
+			expandResult,
+			data.Id(),
+			moduleName(data),
+		); err != nil {
+			return err
+		}
+
+		ignoreFields, err := providerCtx.ignoreRules(data)
+		if err != nil {
+			return err
+		}
+
+		diffs, err := providerCtx.diff(
+			ctx,
+			expandResult.expandedDir,
+			providerCtx.serverSideOptions(data),
+			ignoreFields,
+		)
 		if err != nil {
 			return err
 		}
@@ -336,36 +590,17 @@ func resourceProfileCustomDiff(
 			len(diffs),
 		)
 
-		results = map[string]interface{}{}
-		for _, diffObj := range diffs {
-			if providerCtx.verboseDiffs {
-				results[diffObj.Name] = sanitizeDiff(diffObj.RawDiff)
-			} else {
-				switch diffObj.Operation {
-				case diff.OperationCreate:
-					results[diffObj.Name] = fmt.Sprintf(
-						"Creating new resource (%d lines added)",
-						diffObj.NumAdded,
-					)
-				case diff.OperationDelete:
-					results[diffObj.Name] = fmt.Sprintf(
-						"Completely removing resource (%d lines removed)",
-						diffObj.NumRemoved,
-					)
-				default:
-					results[diffObj.Name] = sanitizeDiff(diffObj.RawDiff)
-				}
+		protected := map[string]string{}
+		if providerCtx.canDelete(data) {
+			protected, err = providerCtx.protectedIDs(ctx, data, changes.removed)
+			if err != nil {
+				return err
 			}
 		}
 
-		if providerCtx.canDelete(data) {
-			for _, id := range changes.removed {
-				results[id] = "TO BE DELETED"
-			}
-		} else {
-			for _, id := range changes.removed {
-				results[id] = "TO BE REMOVED from Terraform but will not be deleted from cluster due to value of allow_deletes.\nPlease delete manually."
-			}
+		results := providerCtx.formatDiffs(data, diffs, changes.removed, protected)
+		for _, id := range orphans {
+			results[id] = pruneDiffValue
 		}
 
 		if len(results) == 0 && data.HasChange("resources") {
@@ -377,14 +612,23 @@ func resourceProfileCustomDiff(
 		if err := data.SetNew("diff", results); err != nil {
 			return err
 		}
-	} else {
-		data.SetNew("diff", map[string]interface{}{})
-		log.Infof(
-			"Skipping diff for %s",
-			moduleName(data),
-		)
+
+		providerCtx.writeReport(data, changes, diffs, orphans, false)
+		return nil
+	}
+
+	// Orphans are still shown so that the profile is updated to prune them
+	results := map[string]interface{}{}
+	for _, id := range orphans {
+		results[id] = pruneDiffValue
 	}
+	data.SetNew("diff", results)
+	log.Infof(
+		"Skipping diff for %s",
+		moduleName(data),
+	)
 
+	providerCtx.writeReport(data, changes, nil, orphans, true)
 	return nil
 }
 
@@ -394,7 +638,11 @@ func resourceProfileUpdate(
 	provider interface{},
 ) diag.Diagnostics {
 	var diags diag.Diagnostics
-	providerCtx := provider.(*providerContext)
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+		return diags
+	}
 
 	if !providerCtx.canRun {
 		err := fmt.Errorf("Cannot update because provider is missing a host or kubeconfig")
@@ -434,16 +682,45 @@ func resourceProfileUpdate(
 		}
 		defer providerCtx.cleanExpanded(expandResult)
 
-		applyDiags := providerCtx.apply(
-			ctx,
-			expandResult.expandedDir,
+		if err := providerCtx.labelManifests(
+			expandResult,
+			data.Id(),
 			moduleName(data),
-		)
+		); err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+			return diags
+		}
+
+		applyDiags := providerCtx.applyProfile(ctx, data, expandResult)
 		diags = append(diags, applyDiags...)
 
 		if diags.HasError() {
 			return diags
 		}
+
+		// Refresh the live hashes before waiting so that the state reflects the apply even if
+		// the resources don't become ready.
+		liveHashesDiags := providerCtx.setLiveHashes(ctx, data)
+		diags = append(diags, liveHashesDiags...)
+
+		waitDiags := providerCtx.wait(ctx, data, expandResult.manifests)
+		diags = append(diags, waitDiags...)
+
+		if diags.HasError() {
+			return diags
+		}
+
+		if providerCtx.shouldPrune(data) {
+			// Prune after the apply so that objects that are moved between profiles aren't
+			// missing in the meantime. Only the orphans in the plan are deleted.
+			orphans := plannedOrphans(diffValue, expandResult)
+			if len(orphans) > 0 {
+				diags = append(diags, providerCtx.delete(ctx, data, orphans)...)
+				if diags.HasError() {
+					return diags
+				}
+			}
+		}
 	} else {
 		log.Infof(
 			"Diff is empty for %s, so not running apply",
@@ -460,7 +737,10 @@ func resourceProfileDelete(
 	data resourceChangerSetter,
 	provider interface{},
 ) diag.Diagnostics {
-	providerCtx := provider.(*providerContext)
+	providerCtx, err := provider.(*providerContext).forResource(ctx, data)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	if !providerCtx.canRun && providerCtx.canDelete(data) {
 		err := fmt.Errorf("Cannot delete because provider is missing a host or kubeconfig")
@@ -477,3 +757,153 @@ func resourceProfileDelete(
 
 	return providerCtx.delete(ctx, data, ids)
 }
+
+// profileImportID is the JSON format for import ids that need more than just a source.
+type profileImportID struct {
+	Source     string                 `json:"source"`
+	Parameters map[string]string      `json:"parameters"`
+	Set        map[string]interface{} `json:"set"`
+	Cluster    string                 `json:"cluster"`
+}
+
+// parseProfileImportID parses the argument import id, which can either be a plain source or
+// a JSON-encoded profileImportID.
+func parseProfileImportID(id string) (profileImportID, error) {
+	importID := profileImportID{}
+
+	if strings.HasPrefix(strings.TrimSpace(id), "{") {
+		if err := json.Unmarshal([]byte(id), &importID); err != nil {
+			return importID, fmt.Errorf("Could not parse import id as JSON: %+v", err)
+		}
+	} else {
+		importID.Source = id
+	}
+
+	if importID.Source == "" {
+		return importID, fmt.Errorf("Import id must include a source")
+	}
+
+	return importID, nil
+}
+
+func resourceProfileImport(
+	ctx context.Context,
+	data resourceChangerSetter,
+	provider interface{},
+) error {
+	importID, err := parseProfileImportID(data.Id())
+	if err != nil {
+		return err
+	}
+	log.Infof("Running import for source %s", importID.Source)
+
+	providerCtx, err := provider.(*providerContext).forCluster(ctx, importID.Cluster)
+	if err != nil {
+		return err
+	}
+
+	if !providerCtx.canRun {
+		return fmt.Errorf("Cannot import because provider is missing a host or kubeconfig")
+	}
+
+	parameters := map[string]interface{}{}
+	for key, value := range importID.Parameters {
+		parameters[key] = value
+	}
+
+	setElem := profileResource().Schema["set"].Elem.(*schema.Resource)
+	setValues := schema.NewSet(schema.HashResource(setElem), []interface{}{})
+	for name, value := range importID.Set {
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		setValues.Add(
+			map[string]interface{}{
+				"name":        name,
+				"value":       string(encodedValue),
+				"placeholder": "",
+			},
+		)
+	}
+
+	for key, value := range map[string]interface{}{
+		"cluster":    importID.Cluster,
+		"source":     importID.Source,
+		"parameters": parameters,
+		"set":        setValues,
+	} {
+		if err := data.Set(key, value); err != nil {
+			return err
+		}
+	}
+
+	expandResult, err := providerCtx.expand(ctx, data)
+	if err != nil {
+		return err
+	}
+	defer providerCtx.cleanExpanded(expandResult)
+
+	ids := []string{}
+	for id := range expandResult.resources {
+		ids = append(ids, id)
+	}
+
+	hashes, err := providerCtx.hashes(ctx, data, ids)
+	if err != nil {
+		return err
+	}
+
+	// Use the live hashes in place of the manifest ones so that the next plan does a full
+	// diff of every resource that already exists in the cluster. Resources that don't exist
+	// are left out so that they show up as additions.
+	resources := map[string]interface{}{}
+	for id, hash := range hashes {
+		if hash != "" {
+			resources[id] = hash
+		}
+	}
+
+	log.Infof(
+		"Found %d/%d resources in cluster for %s",
+		len(resources),
+		len(expandResult.resources),
+		moduleName(data),
+	)
+
+	for key, value := range map[string]interface{}{
+		"resources":      resources,
+		"resources_hash": liveResourcesHash(resources),
+		"live_hashes":    resources,
+		"diff":           map[string]interface{}{},
+		"expanded_files": map[string]interface{}{},
+	} {
+		if err := data.Set(key, value); err != nil {
+			return err
+		}
+	}
+
+	// Make up an id from the timestamp, like in create
+	data.SetId(fmt.Sprintf("%d", time.Now().UnixNano()))
+
+	log.Infof("Import successful for %s", moduleName(data))
+	return nil
+}
+
+// liveResourcesHash returns an overall hash for the argument map of resource ids to live
+// hashes.
+func liveResourcesHash(resources map[string]interface{}) string {
+	ids := []string{}
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hash := md5.New()
+	for _, id := range ids {
+		hash.Write([]byte(id))
+		hash.Write([]byte(resources[id].(string)))
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
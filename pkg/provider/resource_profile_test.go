@@ -54,7 +54,10 @@ func TestResourceProfile(t *testing.T) {
 		},
 	)
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	require.NoError(t, err)
 
 	providerCtx := &providerContext{
@@ -355,7 +358,10 @@ func TestResourceProfileDeletesAllowed(t *testing.T) {
 		},
 	)
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	require.NoError(t, err)
 
 	providerCtx := &providerContext{
@@ -509,7 +515,10 @@ func TestResourceProfileDeletesDisabled(t *testing.T) {
 		},
 	)
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	require.NoError(t, err)
 
 	providerCtx := &providerContext{
@@ -650,7 +659,10 @@ func TestResourceProfileCanNotRun(t *testing.T) {
 		},
 	)
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	require.NoError(t, err)
 
 	providerCtx := &providerContext{
@@ -732,7 +744,10 @@ func TestDiffs(t *testing.T) {
 		},
 	)
 
-	sourceFetcher, err := newSourceFetcher(&commandLineGitClient{})
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
 	require.NoError(t, err)
 
 	providerCtx := &providerContext{
@@ -1362,3 +1377,150 @@ func createSet(values []map[string]interface{}) *schema.Set {
 		setValues,
 	)
 }
+
+func TestResourceProfileReadDrift(t *testing.T) {
+	ctx := context.Background()
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	clusterClient.(*cluster.FakeClient).LiveHashes = map[string]string{
+		"v1.Service.testNamespace.unchanged": "liveHash1",
+		"v1.Service.testNamespace.drifted":   "liveHash2Updated",
+		"v1.Service.testNamespace.deleted":   "",
+		"v1.Service.testNamespace.new":       "liveHash4",
+	}
+
+	providerCtx := &providerContext{
+		canRun:        true,
+		clusterClient: clusterClient,
+	}
+
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"source": "testSource",
+			"resources": map[string]interface{}{
+				"v1.Service.testNamespace.unchanged": "hash1",
+				"v1.Service.testNamespace.drifted":   "hash2",
+				"v1.Service.testNamespace.deleted":   "hash3",
+				"v1.Service.testNamespace.new":       "hash4",
+				"v1.Unknown.testNamespace.unknown":   "hash5",
+			},
+			"live_hashes": map[string]interface{}{
+				"v1.Service.testNamespace.unchanged": "liveHash1",
+				"v1.Service.testNamespace.drifted":   "liveHash2",
+				"v1.Service.testNamespace.deleted":   "liveHash3",
+			},
+		},
+	}
+
+	diags := resourceProfileRead(ctx, data, providerCtx)
+	require.False(t, diags.HasError())
+
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"v1.Service.testNamespace.unchanged": "hash1",
+			"v1.Service.testNamespace.drifted":   driftedResourceHash,
+			"v1.Service.testNamespace.new":       "hash4",
+			"v1.Unknown.testNamespace.unknown":   "hash5",
+		},
+		data.Get("resources"),
+	)
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"v1.Service.testNamespace.unchanged": "liveHash1",
+			"v1.Service.testNamespace.drifted":   "liveHash2",
+			"v1.Service.testNamespace.new":       "liveHash4",
+		},
+		data.Get("live_hashes"),
+	)
+}
+
+func TestResourceProfileImport(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_profile_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterConfig := cluster.Config{
+		Cluster:     "testCluster",
+		Region:      "testRegion",
+		Environment: "testEnvironment",
+		AccountName: "testAccountName",
+		AccountID:   "testAccountID",
+	}
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &clusterConfig,
+		},
+	)
+	require.NoError(t, err)
+	clusterClient.(*cluster.FakeClient).LiveHashes = map[string]string{
+		"apps/v1.Deployment.testNamespace.testName": "liveHash1",
+		"v1.Service.testNamespace2.testName":        "",
+	}
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		canRun:        true,
+		clusterClient: clusterClient,
+		clusterConfig: clusterConfig,
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	data := &fakeChangerSetter{
+		id:        `{"source": "testdata/app1", "parameters": {"value1": "Value1", "value2": "Value2", "serviceAccount": ""}, "set": {"keys": ["a", "b"]}}`,
+		newValues: map[string]interface{}{},
+	}
+
+	err = resourceProfileImport(ctx, data, providerCtx)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, data.Id())
+	assert.Equal(t, "testdata/app1", data.Get("source"))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"value1":         "Value1",
+			"value2":         "Value2",
+			"serviceAccount": "",
+		},
+		data.Get("parameters"),
+	)
+	assert.Equal(t, 1, data.Get("set").(*schema.Set).Len())
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"apps/v1.Deployment.testNamespace.testName": "liveHash1",
+		},
+		data.Get("resources"),
+	)
+	assert.Equal(t, data.Get("resources"), data.Get("live_hashes"))
+	assert.NotEmpty(t, data.Get("resources_hash"))
+
+	_, err = parseProfileImportID("")
+	assert.Error(t, err)
+
+	importID, err := parseProfileImportID("git@github.com:segmentio/kubeapply.git//path?ref=main")
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		profileImportID{Source: "git@github.com:segmentio/kubeapply.git//path?ref=main"},
+		importID,
+	)
+}
@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// snapshot stores the live state of a profile's resources from before an apply.
+type snapshot struct {
+	// existing contains the live versions of the resources that were already in the cluster,
+	// in apply order.
+	existing []*unstructured.Unstructured
+
+	// created contains the ids of the resources that weren't in the cluster.
+	created []string
+}
+
+// applyProfile applies the expanded manifests for a profile. If the profile has
+// rollback_on_failure set, then the resources are snapshotted before the apply and restored
+// if the apply fails.
+func (p *providerContext) applyProfile(
+	ctx context.Context,
+	data resourceChanger,
+	expandResult *expandResult,
+) diag.Diagnostics {
+	serverSide := p.serverSideOptions(data)
+
+	if rollbackOnFailure, _ := data.Get("rollback_on_failure").(bool); !rollbackOnFailure {
+		return p.apply(ctx, expandResult.expandedDir, moduleName(data), serverSide)
+	}
+
+	snapshot, err := p.takeSnapshot(ctx, expandResult.manifests)
+	if err != nil {
+		return diag.FromErr(
+			fmt.Errorf("Could not snapshot resources before apply: %+v", err),
+		)
+	}
+
+	diags := p.apply(ctx, expandResult.expandedDir, moduleName(data), serverSide)
+	if !diags.HasError() {
+		return diags
+	}
+
+	return append(diags, p.rollback(ctx, data, snapshot, serverSide)...)
+}
+
+// takeSnapshot fetches the live versions of the resources in the argument manifests.
+// Resources with kinds that the cluster doesn't know about yet are treated as new.
+func (p *providerContext) takeSnapshot(
+	ctx context.Context,
+	manifests []kube.Manifest,
+) (*snapshot, error) {
+	result := &snapshot{
+		existing: []*unstructured.Unstructured{},
+		created:  []string{},
+	}
+
+	for _, manifest := range manifests {
+		if manifest.Head.Metadata == nil {
+			continue
+		}
+
+		obj, err := kube.GetObject(
+			ctx,
+			p.dynamicClient,
+			p.restMapper,
+			schema.FromAPIVersionAndKind(manifest.Head.Version, manifest.Head.Kind),
+			manifest.Head.Metadata.Namespace,
+			manifest.Head.Metadata.Name,
+		)
+		if meta.IsNoMatchError(err) {
+			// The kind isn't in the cluster yet, e.g. because its CRD is created in the same
+			// profile, so the object can't exist either
+			obj = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Error getting %s: %+v", manifest.ID, err)
+		}
+
+		if obj == nil {
+			result.created = append(result.created, manifest.ID)
+			continue
+		}
+
+		// Only restore the fields that were applied; fields owned by other managers, e.g.
+		// the replicas of an autoscaled deployment, are left alone
+		appliedObj, err := kube.AppliedObject(obj)
+		if err != nil {
+			return nil, err
+		}
+		result.existing = append(result.existing, appliedObj)
+	}
+
+	log.Infof(
+		"Snapshotted %d existing resources (%d new)",
+		len(result.existing),
+		len(result.created),
+	)
+	return result, nil
+}
+
+// rollback restores the resources in the argument snapshot and deletes the ones that were
+// created since it was taken. New resources that are protected from deletion are left in the
+// cluster.
+func (p *providerContext) rollback(
+	ctx context.Context,
+	data resourceChanger,
+	snapshot *snapshot,
+	serverSide kube.ServerSideOptions,
+) diag.Diagnostics {
+	log.Infof("Rolling back failed apply for %s", moduleName(data))
+
+	rollbackErr := func(err error, output []byte) diag.Diagnostics {
+		return diag.Diagnostics{
+			{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Rollback failed for %s", moduleName(data)),
+				Detail:   fmt.Sprintf("%+v\n\n%s", err, string(output)),
+			},
+		}
+	}
+
+	if len(snapshot.existing) > 0 {
+		snapshotDir := filepath.Join(
+			p.tempDir,
+			"snapshots",
+			fmt.Sprintf("%d", time.Now().UnixNano()),
+		)
+		if err := writeSnapshot(snapshot, snapshotDir); err != nil {
+			return rollbackErr(err, nil)
+		}
+		defer os.RemoveAll(snapshotDir)
+
+		results, err := p.clusterClient.Apply(ctx, []string{snapshotDir}, serverSide)
+		if err != nil {
+			return rollbackErr(err, results)
+		}
+	}
+
+	protected, err := p.protectedIDs(ctx, data, snapshot.created)
+	if err != nil {
+		return rollbackErr(err, nil)
+	}
+
+	deleteIDs := []string{}
+	leftBehind := []string{}
+	for _, id := range snapshot.created {
+		if reason, ok := protected[id]; ok {
+			leftBehind = append(leftBehind, fmt.Sprintf("%s (%s)", id, reason))
+		} else {
+			deleteIDs = append(deleteIDs, id)
+		}
+	}
+
+	if len(deleteIDs) > 0 {
+		results, err := p.clusterClient.Delete(ctx, deleteIDs, p.deleteOptions())
+		if err != nil {
+			return rollbackErr(err, results)
+		}
+	}
+
+	detail := fmt.Sprintf(
+		"Restored %d existing resource(s) and deleted %d new resource(s)",
+		len(snapshot.existing),
+		len(deleteIDs),
+	)
+	if len(leftBehind) > 0 {
+		detail = fmt.Sprintf(
+			"%s. Left %d new resource(s) in the cluster because they're protected from "+
+				"deletion: %s",
+			detail,
+			len(leftBehind),
+			strings.Join(leftBehind, ", "),
+		)
+	}
+
+	return diag.Diagnostics{
+		{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("Rolled back failed apply for %s", moduleName(data)),
+			Detail:   detail,
+		},
+	}
+}
+
+// writeSnapshot writes the existing objects in the argument snapshot to manifest files in
+// the argument directory.
+func writeSnapshot(snapshot *snapshot, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for o, obj := range snapshot.existing {
+		contents, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%03d_%s.yaml", o, obj.GetName()))
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
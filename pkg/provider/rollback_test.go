@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_rollback_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	manifestsDir := filepath.Join(tempDir, "manifests")
+	util.WriteFiles(
+		t,
+		manifestsDir,
+		map[string]string{
+			"deployments.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: existing
+  namespace: test-namespace
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: new
+  namespace: test-namespace
+`,
+			// The CRD for this kind would be created in the same apply, so the cluster doesn't
+			// know about it yet
+			"widget.yaml": `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: new-widget
+  namespace: test-namespace
+`,
+		},
+	)
+	manifests, err := kube.GetManifests([]string{manifestsDir})
+	require.NoError(t, err)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		meta.RESTScopeNamespace,
+	)
+
+	existingObj := testWaitObject(
+		"apps/v1",
+		"Deployment",
+		"existing",
+		map[string]interface{}{
+			"replicas": int64(1),
+		},
+	)
+	existingObj.SetResourceVersion("10")
+	existingObj.SetUID("test-uid")
+	existingObj.SetLabels(map[string]string{"app": "existing"})
+	unstructured.SetNestedField(existingObj.Object, int64(2), "spec", "replicas")
+	unstructured.SetNestedField(existingObj.Object, int64(5), "spec", "minReadySeconds")
+
+	// The replicas are managed by an autoscaler, so they shouldn't be restored
+	existingObj.SetManagedFields(
+		[]metav1.ManagedFieldsEntry{
+			{
+				Manager:   "kubeapply",
+				Operation: metav1.ManagedFieldsOperationApply,
+				FieldsV1: &metav1.FieldsV1{
+					Raw: []byte(
+						`{"f:metadata":{"f:labels":{"f:app":{}}},"f:spec":{"f:minReadySeconds":{}}}`,
+					),
+				},
+			},
+			{
+				Manager:   "horizontal-pod-autoscaler",
+				Operation: metav1.ManagedFieldsOperationUpdate,
+				FieldsV1: &metav1.FieldsV1{
+					Raw: []byte(`{"f:spec":{"f:replicas":{}}}`),
+				},
+			},
+		},
+	)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "test-cluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	providerCtx := &providerContext{
+		clusterClient: clusterClient,
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), existingObj),
+		restMapper:    mapper,
+		tempDir:       tempDir,
+	}
+
+	snapshot, err := providerCtx.takeSnapshot(ctx, manifests)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshot.existing))
+	assert.Equal(t, "existing", snapshot.existing[0].GetName())
+	assert.Equal(t, "", snapshot.existing[0].GetResourceVersion())
+	assert.Equal(t, "", string(snapshot.existing[0].GetUID()))
+	assert.Equal(t, "test-namespace", snapshot.existing[0].GetNamespace())
+	assert.Equal(t, map[string]string{"app": "existing"}, snapshot.existing[0].GetLabels())
+	assert.Nil(t, snapshot.existing[0].GetManagedFields())
+	_, hasStatus := snapshot.existing[0].Object["status"]
+	assert.False(t, hasStatus)
+	assert.Equal(
+		t,
+		map[string]interface{}{"minReadySeconds": int64(5)},
+		snapshot.existing[0].Object["spec"],
+	)
+	assert.Equal(
+		t,
+		[]string{
+			"apps/v1.Deployment.test-namespace.new",
+			"example.com/v1.Widget.test-namespace.new-widget",
+		},
+		snapshot.created,
+	)
+
+	// New resources that are protected from deletion are left behind
+	data := &fakeChangerSetter{
+		newValues: map[string]interface{}{
+			"delete_policy": []interface{}{
+				map[string]interface{}{
+					"exclude_kinds": []interface{}{"Widget"},
+				},
+			},
+		},
+	}
+
+	diags := providerCtx.rollback(ctx, data, snapshot, kube.ServerSideOptions{})
+	require.Equal(t, 1, len(diags))
+	assert.Equal(t, diag.Warning, diags[0].Severity)
+	assert.Equal(
+		t,
+		"Restored 1 existing resource(s) and deleted 1 new resource(s). Left 1 new "+
+			"resource(s) in the cluster because they're protected from deletion: "+
+			"example.com/v1.Widget.test-namespace.new-widget (kind Widget is excluded by "+
+			"delete_policy)",
+		diags[0].Detail,
+	)
+
+	fakeClient := clusterClient.(*cluster.FakeClient)
+	require.Equal(t, 3, len(fakeClient.Calls))
+	assert.Equal(t, "Apply", fakeClient.Calls[0].CallType)
+	assert.Equal(t, "Annotations", fakeClient.Calls[1].CallType)
+	assert.Equal(t, "Delete", fakeClient.Calls[2].CallType)
+	assert.Equal(
+		t,
+		[]string{"apps/v1.Deployment.test-namespace.new"},
+		fakeClient.Calls[2].Paths,
+	)
+
+	// The snapshot files are removed after the rollback
+	_, err = os.Stat(fakeClient.Calls[0].Paths[0])
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	snapshotDir, err := ioutil.TempDir("", "kubeapply_test_snapshot_")
+	require.NoError(t, err)
+	defer os.RemoveAll(snapshotDir)
+
+	err = writeSnapshot(
+		&snapshot{
+			existing: []*unstructured.Unstructured{
+				testWaitObject("apps/v1", "Deployment", "test-deployment", nil),
+			},
+		},
+		snapshotDir,
+	)
+	require.NoError(t, err)
+
+	manifests, err := kube.GetManifests([]string{snapshotDir})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(manifests))
+	assert.Equal(t, "apps/v1.Deployment.test-namespace.test-deployment", manifests[0].ID)
+}
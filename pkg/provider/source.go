@@ -2,71 +2,333 @@ package provider
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	githubSourceRegexp = regexp.MustCompile(
-		"^(git@github.com:[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+)//([a-zA-Z0-9_/-]*)[?]ref=([a-zA-Z0-9_/.-]+)$",
-	)
-)
-
+// sourceFetcher gets profile sources and copies them into expansion directories. Clones of
+// remote repos and OCI artifacts are cached by commit or digest so that each version is only
+// fetched once per provider process. Archives are downloaded and extracted on each call.
 type sourceFetcher struct {
 	gitClientObj gitClient
+	ociClient    *util.OCIClient
+	cacheDir     string
+
+	sync.Mutex
+	cacheLocks map[string]*sync.Mutex
 }
 
-func newSourceFetcher(gitClientObj gitClient) (*sourceFetcher, error) {
+var (
+	// Fetchers created by the providers in this process; their caches are removed by
+	// CleanupSources when the process exits.
+	sourceFetchersLock sync.Mutex
+	sourceFetchers     []*sourceFetcher
+)
+
+func newSourceFetcher(gitClientObj gitClient, cacheDir string) (*sourceFetcher, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+
 	return &sourceFetcher{
 		gitClientObj: gitClientObj,
+		ociClient:    util.NewOCIClient(),
+		cacheDir:     cacheDir,
+		cacheLocks:   map[string]*sync.Mutex{},
 	}, nil
 }
 
-func (s *sourceFetcher) get(ctx context.Context, source string, dest string) error {
-	matches := githubSourceRegexp.FindStringSubmatch(source)
+// get copies the argument source into the destination directory. For remote sources, it
+// returns the resolved version of the source that was fetched, i.e. the commit SHA for git
+// repos and the digest for archives and OCI artifacts.
+func (s *sourceFetcher) get(ctx context.Context, source string, dest string) (string, error) {
+	address, err := parseSourceAddress(source)
+	if err != nil {
+		return "", err
+	}
 
-	if len(matches) > 0 {
-		repoURL := matches[1]
-		path := matches[2]
-		ref := matches[3]
+	if address.isLocal() {
+		log.Debugf("Copying profile from %s to %s", source, dest)
+		return "", util.RecursiveCopy(address.localPath, dest)
+	}
 
-		log.Infof("Cloning repo with source %s", source)
+	var rootDir string
+	var version string
 
-		cloneDir, err := ioutil.TempDir("", "kubeapply_clone_")
+	if address.isArchive() {
+		rootDir, err = ioutil.TempDir(s.cacheDir, "archive_")
 		if err != nil {
-			return err
+			return "", err
 		}
-		defer os.RemoveAll(cloneDir)
+		defer os.RemoveAll(rootDir)
+
+		version, err = s.extractArchive(ctx, address, rootDir)
+	} else if address.isOCI() {
+		rootDir, version, err = s.pullOCI(ctx, address)
+	} else {
+		rootDir, version, err = s.clone(ctx, address)
+	}
+	if err != nil {
+		return "", err
+	}
 
-		err = s.gitClientObj.cloneRepo(
-			ctx,
-			repoURL,
-			ref,
-			cloneDir,
+	sourcePath := filepath.Join(rootDir, address.subdir)
+	if sourcePath != filepath.Clean(rootDir) &&
+		!strings.HasPrefix(sourcePath, filepath.Clean(rootDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf(
+			"Subdirectory %s in source %s is outside of the source root",
+			address.subdir,
+			source,
 		)
-		if err != nil {
-			return err
-		}
+	}
+	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
+		return "", fmt.Errorf(
+			"Subdirectory %s does not exist in source %s",
+			address.subdir,
+			source,
+		)
+	}
 
-		sourcePath := filepath.Join(cloneDir, path)
-		if err := util.RecursiveCopy(sourcePath, dest); err != nil {
+	return version, util.RecursiveCopy(sourcePath, dest)
+}
+
+// extractArchive downloads the archive in the argument address, verifies its checksum (if
+// set), and extracts it into the destination directory. It returns the digest of the archive.
+func (s *sourceFetcher) extractArchive(
+	ctx context.Context,
+	address sourceAddress,
+	dest string,
+) (string, error) {
+	archiveFile, err := ioutil.TempFile(s.cacheDir, "archive_*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archiveFile.Name())
+	defer archiveFile.Close()
+
+	hasher := sha256.New()
+	if err := downloadArchive(
+		ctx,
+		address.archiveURL,
+		io.MultiWriter(archiveFile, hasher),
+	); err != nil {
+		return "", err
+	}
+
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+	if address.checksum != "" && checksum != address.checksum {
+		return "", fmt.Errorf(
+			"Checksum mismatch for %s: expected sha256:%s, got sha256:%s",
+			address.archiveURL,
+			address.checksum,
+			checksum,
+		)
+	}
+
+	if err := archiveFile.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%s", checksum),
+		util.ExtractArchive(archiveFile.Name(), address.archiveFormat, dest)
+}
+
+// downloadArchive writes the contents of the archive at the argument HTTP(S) or file URL to
+// the argument writer.
+func downloadArchive(ctx context.Context, archiveURL string, writer io.Writer) error {
+	parsedURL, err := url.Parse(archiveURL)
+	if err != nil {
+		return fmt.Errorf("Could not parse archive URL %s: %+v", archiveURL, err)
+	}
+
+	var reader io.ReadCloser
+
+	if parsedURL.Scheme == "file" {
+		log.Infof("Reading archive from %s", parsedURL.Path)
+		reader, err = os.Open(parsedURL.Path)
+		if err != nil {
 			return err
 		}
 	} else {
-		log.Debugf("Copying profile from %s to %s", source, dest)
-		if err := util.RecursiveCopy(source, dest); err != nil {
+		log.Infof("Downloading archive from %s", parsedURL.Redacted())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+		if err != nil {
 			return err
 		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("Error downloading archive: %+v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf(
+				"Error downloading archive from %s: got status %s",
+				parsedURL.Redacted(),
+				resp.Status,
+			)
+		}
+		reader = resp.Body
 	}
+	defer reader.Close()
 
-	return nil
+	_, err = io.Copy(writer, reader)
+	return err
 }
 
+// clone returns the path to a clone of the repo in the argument address along with the
+// commit that was cloned, cloning it into the cache if needed.
+func (s *sourceFetcher) clone(
+	ctx context.Context,
+	address sourceAddress,
+) (string, string, error) {
+	commit, err := s.gitClientObj.resolveRef(ctx, address.repoURL, address.ref)
+	if err != nil {
+		return "", "", err
+	}
+
+	cloneDir, err := s.cached(
+		address.repoURL,
+		commit,
+		func(tempDir string) error {
+			log.Infof("Cloning repo %s at %s", address.repoURL, commit)
+			// Fetch the resolved commit instead of the ref so that the cache entry matches
+			// its key even if the ref has moved since it was resolved
+			return s.gitClientObj.cloneRepo(
+				ctx,
+				address.repoURL,
+				commit,
+				address.depth,
+				tempDir,
+			)
+		},
+	)
+	return cloneDir, commit, err
+}
+
+// pullOCI returns the path to the contents of the OCI artifact in the argument address
+// along with the artifact's digest, pulling it into the cache if needed.
+func (s *sourceFetcher) pullOCI(
+	ctx context.Context,
+	address sourceAddress,
+) (string, string, error) {
+	image, digest, err := s.ociClient.ResolveManifest(ctx, address.ociReference)
+	if err != nil {
+		return "", "", err
+	}
+	log.Infof("Resolved %s to %s", address.ociReference, digest)
+
+	artifactDir, err := s.cached(
+		fmt.Sprintf("%s/%s", address.ociReference.Registry, address.ociReference.Repository),
+		digest,
+		func(tempDir string) error {
+			log.Infof("Pulling %s", address.ociReference)
+			return s.ociClient.ExtractLayers(address.ociReference, image, tempDir)
+		},
+	)
+	return artifactDir, digest, err
+}
+
+// cached returns the path to the cache entry for the argument source and version, running
+// the argument function to populate it if needed.
+func (s *sourceFetcher) cached(
+	source string,
+	version string,
+	populate func(tempDir string) error,
+) (string, error) {
+	cacheKey := fmt.Sprintf(
+		"%x",
+		md5.Sum([]byte(fmt.Sprintf("%s\n%s", source, version))),
+	)
+	cacheEntryDir := filepath.Join(s.cacheDir, cacheKey)
+
+	// Hold a lock for the key so that concurrent resource operations don't fetch the same
+	// version multiple times.
+	cacheLock := s.cacheLock(cacheKey)
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	ok, err := util.DirExists(cacheEntryDir)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		log.Infof("Using cached copy of %s at %s", source, version)
+		return cacheEntryDir, nil
+	}
+
+	// Fetch into a temporary location and then move it into place so that failed fetches
+	// aren't cached.
+	tempDir, err := ioutil.TempDir(s.cacheDir, "fetch_")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := populate(tempDir); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tempDir, cacheEntryDir); err != nil {
+		return "", err
+	}
+
+	return cacheEntryDir, nil
+}
+
+func (s *sourceFetcher) cacheLock(cacheKey string) *sync.Mutex {
+	s.Lock()
+	defer s.Unlock()
+
+	cacheLock, ok := s.cacheLocks[cacheKey]
+	if !ok {
+		cacheLock = &sync.Mutex{}
+		s.cacheLocks[cacheKey] = cacheLock
+	}
+	return cacheLock
+}
+
+// registerSourceFetcher adds the argument fetcher to the ones that are cleaned up by
+// CleanupSources.
+func registerSourceFetcher(fetcher *sourceFetcher) {
+	sourceFetchersLock.Lock()
+	defer sourceFetchersLock.Unlock()
+
+	sourceFetchers = append(sourceFetchers, fetcher)
+}
+
+// CleanupSources removes the cached sources of all of the providers that were configured in
+// this process. It should be called when the provider server exits.
+func CleanupSources() {
+	sourceFetchersLock.Lock()
+	defer sourceFetchersLock.Unlock()
+
+	for _, fetcher := range sourceFetchers {
+		log.Infof("Cleaning source cache %s", fetcher.cacheDir)
+		if err := fetcher.cleanup(); err != nil {
+			log.Warnf("Error cleaning source cache %s: %+v", fetcher.cacheDir, err)
+		}
+	}
+	sourceFetchers = nil
+}
+
+// cleanup removes all of the cached sources. The fetcher shouldn't be used after this is
+// called.
 func (s *sourceFetcher) cleanup() error {
-	return nil
+	s.Lock()
+	defer s.Unlock()
+
+	s.cacheLocks = map[string]*sync.Mutex{}
+	return os.RemoveAll(s.cacheDir)
 }
@@ -0,0 +1,298 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+)
+
+const (
+	// Default ref to fetch if none is set in the source
+	defaultGitRef = "HEAD"
+
+	// Default depth to fetch if none is set in the source
+	defaultGitDepth = 1
+)
+
+var (
+	// Matches sources with a forced getter, e.g. "git::https://example.com/repo.git"
+	forcedGetterRegexp = regexp.MustCompile(`^([a-zA-Z0-9]+)::(.+)$`)
+
+	// Matches URLs with a scheme, e.g. "https://example.com/repo.git"
+	schemeRegexp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+
+	// Matches scp-style git addresses, e.g. "git@github.com:org/repo"
+	scpGitRegexp = regexp.MustCompile(`^([a-zA-Z0-9_.-]+)@([a-zA-Z0-9_.-]+):(.+)$`)
+
+	// Matches archive checksums, e.g. "sha256:e3b0c442..."
+	checksumRegexp = regexp.MustCompile(`^sha256:[0-9a-fA-F]{64}$`)
+
+	// Hosts that can be referenced without a scheme, e.g. "github.com/org/repo"
+	shorthandGitHosts = []string{
+		"bitbucket.org/",
+		"github.com/",
+		"gitlab.com/",
+	}
+)
+
+// sourceAddress is a parsed profile source. Remote sources follow the syntax used for
+// Terraform module sources (https://www.terraform.io/docs/language/modules/sources.html).
+// Archives can be fetched over HTTP or from the local file system, and artifacts can be
+// pulled from OCI registries.
+type sourceAddress struct {
+	// localPath is set for sources in the local file system
+	localPath string
+
+	// subdir is the directory within a remote source that contains the manifests
+	subdir string
+
+	// These fields are only set for git sources
+	repoURL string
+	ref     string
+	depth   int
+
+	// These fields are only set for archive sources
+	archiveURL    string
+	archiveFormat string
+	checksum      string
+
+	// This field is only set for OCI sources
+	ociReference util.OCIReference
+}
+
+// isLocal returns whether this source is in the local file system.
+func (s sourceAddress) isLocal() bool {
+	return s.localPath != ""
+}
+
+// isArchive returns whether this source is an archive that needs to be downloaded.
+func (s sourceAddress) isArchive() bool {
+	return s.archiveURL != ""
+}
+
+// isOCI returns whether this source is an artifact in an OCI registry.
+func (s sourceAddress) isOCI() bool {
+	return s.ociReference.Registry != ""
+}
+
+// parseSourceAddress parses the argument profile source. Sources that don't look like remote
+// addresses are assumed to be local paths.
+func parseSourceAddress(source string) (sourceAddress, error) {
+	if source == "" {
+		return sourceAddress{}, fmt.Errorf("Source cannot be empty")
+	}
+
+	address := source
+	forced := ""
+
+	if matches := forcedGetterRegexp.FindStringSubmatch(address); matches != nil {
+		forced = matches[1]
+		address = matches[2]
+	}
+
+	switch forced {
+	case "":
+	case "git":
+		return parseGitSourceAddress(address)
+	default:
+		return sourceAddress{}, fmt.Errorf(
+			"Unsupported source type %s in %s; only git, archive, OCI, and local sources are supported",
+			forced,
+			source,
+		)
+	}
+
+	if matches := schemeRegexp.FindStringSubmatch(address); matches != nil {
+		switch strings.ToLower(matches[1]) {
+		case "ssh", "git":
+			return parseGitSourceAddress(address)
+		case "http", "https":
+			baseURL, _, _ := splitSourceAddress(address)
+			if strings.HasSuffix(baseURL, ".git") {
+				return parseGitSourceAddress(address)
+			} else if util.GetArchiveFormat(baseURL) != "" {
+				return parseArchiveSourceAddress(address)
+			}
+			return sourceAddress{}, fmt.Errorf(
+				"Unsupported source %s; HTTP sources must be .tar.gz, .tgz, or .zip archives, "+
+					"or have a git:: prefix to be fetched as git repos",
+				source,
+			)
+		case "oci":
+			return parseOCISourceAddress(address)
+		case "file":
+			baseURL, _, _ := splitSourceAddress(address)
+			if util.GetArchiveFormat(baseURL) != "" {
+				return parseArchiveSourceAddress(address)
+			}
+			return sourceAddress{}, fmt.Errorf(
+				"Unsupported source %s; file:// sources must be .tar.gz, .tgz, or .zip archives",
+				source,
+			)
+		default:
+			return sourceAddress{}, fmt.Errorf(
+				"Unsupported scheme %s in source %s",
+				matches[1],
+				source,
+			)
+		}
+	}
+
+	if scpGitRegexp.MatchString(address) {
+		return parseGitSourceAddress(address)
+	}
+
+	for _, host := range shorthandGitHosts {
+		if strings.HasPrefix(address, host) {
+			return parseGitSourceAddress(fmt.Sprintf("https://%s", address))
+		}
+	}
+
+	return sourceAddress{localPath: source}, nil
+}
+
+// parseGitSourceAddress parses a git address (without the git:: prefix) that can contain
+// a subdirectory after a "//" and "ref" and "depth" query parameters.
+func parseGitSourceAddress(address string) (sourceAddress, error) {
+	repoURL, subdir, rawQuery := splitSourceAddress(address)
+	if repoURL == "" {
+		return sourceAddress{}, fmt.Errorf("Source %s is missing a repo URL", address)
+	}
+
+	result := sourceAddress{
+		repoURL: repoURL,
+		subdir:  subdir,
+		ref:     defaultGitRef,
+		depth:   defaultGitDepth,
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return sourceAddress{}, fmt.Errorf(
+			"Could not parse query in source %s: %+v",
+			address,
+			err,
+		)
+	}
+
+	for key, values := range query {
+		value := values[len(values)-1]
+
+		switch key {
+		case "ref":
+			if value == "" {
+				return sourceAddress{}, fmt.Errorf("Ref in source %s cannot be empty", address)
+			}
+			result.ref = value
+		case "depth":
+			result.depth, err = strconv.Atoi(value)
+			if err != nil || result.depth < 0 {
+				return sourceAddress{}, fmt.Errorf(
+					"Depth in source %s must be a non-negative integer",
+					address,
+				)
+			}
+		default:
+			return sourceAddress{}, fmt.Errorf(
+				"Unsupported parameter %s in source %s",
+				key,
+				address,
+			)
+		}
+	}
+
+	return result, nil
+}
+
+// parseArchiveSourceAddress parses an archive URL that can contain a subdirectory after a "//"
+// and a "checksum" query parameter. Other query parameters are kept in the URL.
+func parseArchiveSourceAddress(address string) (sourceAddress, error) {
+	baseURL, subdir, rawQuery := splitSourceAddress(address)
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return sourceAddress{}, fmt.Errorf(
+			"Could not parse query in source %s: %+v",
+			address,
+			err,
+		)
+	}
+
+	result := sourceAddress{
+		subdir:        subdir,
+		archiveFormat: util.GetArchiveFormat(baseURL),
+	}
+
+	if checksum := query.Get("checksum"); checksum != "" {
+		if !checksumRegexp.MatchString(checksum) {
+			return sourceAddress{}, fmt.Errorf(
+				"Checksum in source %s must be in the format sha256:[hex digest]",
+				address,
+			)
+		}
+		result.checksum = strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+		query.Del("checksum")
+	}
+
+	if len(query) > 0 {
+		result.archiveURL = fmt.Sprintf("%s?%s", baseURL, query.Encode())
+	} else {
+		result.archiveURL = baseURL
+	}
+
+	return result, nil
+}
+
+// parseOCISourceAddress parses an OCI artifact address in the format
+// "oci://registry/repo:tag" or "oci://registry/repo@sha256:[hex digest]" that can contain a
+// subdirectory after a "//".
+func parseOCISourceAddress(address string) (sourceAddress, error) {
+	baseURL, subdir, rawQuery := splitSourceAddress(address)
+	if rawQuery != "" {
+		return sourceAddress{}, fmt.Errorf(
+			"Query parameters aren't supported in OCI source %s",
+			address,
+		)
+	}
+
+	ociReference, err := util.ParseOCIReference(strings.TrimPrefix(baseURL, "oci://"))
+	if err != nil {
+		return sourceAddress{}, err
+	}
+
+	return sourceAddress{
+		subdir:       subdir,
+		ociReference: ociReference,
+	}, nil
+}
+
+// splitSourceAddress splits the argument address into the base URL, the subdirectory, and
+// the raw query.
+func splitSourceAddress(address string) (string, string, string) {
+	var rawQuery string
+
+	if index := strings.Index(address, "?"); index >= 0 {
+		rawQuery = address[index+1:]
+		address = address[:index]
+	}
+
+	// Skip past the scheme, if any, so that its slashes aren't confused with the subdirectory
+	// separator
+	offset := 0
+	if matches := schemeRegexp.FindStringIndex(address); matches != nil {
+		offset = matches[1]
+	}
+
+	var subdir string
+
+	if index := strings.Index(address[offset:], "//"); index >= 0 {
+		subdir = strings.Trim(address[offset+index+2:], "/")
+		address = address[:offset+index]
+	}
+
+	return address, subdir, rawQuery
+}
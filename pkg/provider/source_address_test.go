@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSourceAddress(t *testing.T) {
+	type testCase struct {
+		source      string
+		expected    sourceAddress
+		expectedErr bool
+	}
+
+	testCases := []testCase{
+		{
+			source: "./manifests",
+			expected: sourceAddress{
+				localPath: "./manifests",
+			},
+		},
+		{
+			source: "/path/to/manifests",
+			expected: sourceAddress{
+				localPath: "/path/to/manifests",
+			},
+		},
+		{
+			source: "git@github.com:segmentio/terracode-modules//profiles?ref=2021-03-18",
+			expected: sourceAddress{
+				repoURL: "git@github.com:segmentio/terracode-modules",
+				subdir:  "profiles",
+				ref:     "2021-03-18",
+				depth:   1,
+			},
+		},
+		{
+			source: "git@gitlab.example.com:org/repo.git",
+			expected: sourceAddress{
+				repoURL: "git@gitlab.example.com:org/repo.git",
+				ref:     "HEAD",
+				depth:   1,
+			},
+		},
+		{
+			source: "git::https://example.com/org/repo.git//path/to/profile?ref=v1.2.0&depth=5",
+			expected: sourceAddress{
+				repoURL: "https://example.com/org/repo.git",
+				subdir:  "path/to/profile",
+				ref:     "v1.2.0",
+				depth:   5,
+			},
+		},
+		{
+			source: "git::https://example.com/org/repo//profile",
+			expected: sourceAddress{
+				repoURL: "https://example.com/org/repo",
+				subdir:  "profile",
+				ref:     "HEAD",
+				depth:   1,
+			},
+		},
+		{
+			source: "https://bitbucket.org/org/repo.git//profile?ref=main&depth=0",
+			expected: sourceAddress{
+				repoURL: "https://bitbucket.org/org/repo.git",
+				subdir:  "profile",
+				ref:     "main",
+				depth:   0,
+			},
+		},
+		{
+			source: "git::ssh://git@example.com:2222/org/repo.git//a/b/c?ref=abc123",
+			expected: sourceAddress{
+				repoURL: "ssh://git@example.com:2222/org/repo.git",
+				subdir:  "a/b/c",
+				ref:     "abc123",
+				depth:   1,
+			},
+		},
+		{
+			source: "ssh://git@example.com/org/repo.git",
+			expected: sourceAddress{
+				repoURL: "ssh://git@example.com/org/repo.git",
+				ref:     "HEAD",
+				depth:   1,
+			},
+		},
+		{
+			source: "github.com/segmentio/kubeapply//profiles?ref=main",
+			expected: sourceAddress{
+				repoURL: "https://github.com/segmentio/kubeapply",
+				subdir:  "profiles",
+				ref:     "main",
+				depth:   1,
+			},
+		},
+		{
+			source: "git::file:///tmp/repo.git//profiles",
+			expected: sourceAddress{
+				repoURL: "file:///tmp/repo.git",
+				subdir:  "profiles",
+				ref:     "HEAD",
+				depth:   1,
+			},
+		},
+		{
+			source: "https://example.com/releases/bundle.tar.gz//manifests?checksum=sha256:" +
+				"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855",
+			expected: sourceAddress{
+				subdir:        "manifests",
+				archiveURL:    "https://example.com/releases/bundle.tar.gz",
+				archiveFormat: "tar.gz",
+				checksum:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			},
+		},
+		{
+			source: "https://example.com/bundle.tgz?token=abc",
+			expected: sourceAddress{
+				archiveURL:    "https://example.com/bundle.tgz?token=abc",
+				archiveFormat: "tar.gz",
+			},
+		},
+		{
+			source: "file:///mirror/bundle.zip",
+			expected: sourceAddress{
+				archiveURL:    "file:///mirror/bundle.zip",
+				archiveFormat: "zip",
+			},
+		},
+		{
+			source: "oci://ghcr.io/org/manifests:v1.2.0//profiles",
+			expected: sourceAddress{
+				subdir: "profiles",
+				ociReference: util.OCIReference{
+					Registry:   "ghcr.io",
+					Repository: "org/manifests",
+					Reference:  "v1.2.0",
+				},
+			},
+		},
+		{
+			source: "oci://localhost:5000/manifests@sha256:" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			expected: sourceAddress{
+				ociReference: util.OCIReference{
+					Registry:   "localhost:5000",
+					Repository: "manifests",
+					Reference:  "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				},
+			},
+		},
+		{
+			source:      "oci://ghcr.io/org/manifests:v1?depth=1",
+			expectedErr: true,
+		},
+		{
+			source:      "",
+			expectedErr: true,
+		},
+		{
+			source:      "https://example.com/manifests",
+			expectedErr: true,
+		},
+		{
+			source:      "file:///mirror/manifests",
+			expectedErr: true,
+		},
+		{
+			source:      "https://example.com/bundle.zip?checksum=md5:abc",
+			expectedErr: true,
+		},
+		{
+			source:      "s3::https://s3.amazonaws.com/bucket/manifests",
+			expectedErr: true,
+		},
+		{
+			source:      "ftp://example.com/manifests",
+			expectedErr: true,
+		},
+		{
+			source:      "git::https://example.com/org/repo.git?ref=",
+			expectedErr: true,
+		},
+		{
+			source:      "git::https://example.com/org/repo.git?depth=-1",
+			expectedErr: true,
+		},
+		{
+			source:      "git::https://example.com/org/repo.git?sshkey=abc",
+			expectedErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, err := parseSourceAddress(testCase.source)
+		if testCase.expectedErr {
+			assert.Error(t, err, testCase.source)
+		} else {
+			assert.NoError(t, err, testCase.source)
+			assert.Equal(t, testCase.expected, result, testCase.source)
+		}
+	}
+}
@@ -2,9 +2,16 @@ package provider
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
@@ -17,47 +24,50 @@ func TestSourceGet(t *testing.T) {
 
 	gitClientObj := &fakeGitClient{}
 
-	sourceFetcherObj, err := newSourceFetcher(gitClientObj)
-	require.NoError(t, err)
-	defer sourceFetcherObj.cleanup()
-
 	tempDir, err := ioutil.TempDir("", "kubeapply_test_sources_")
 	require.NoError(t, err)
 	defer os.RemoveAll(tempDir)
 
-	err = sourceFetcherObj.get(
+	sourceFetcherObj, err := newSourceFetcher(gitClientObj, filepath.Join(tempDir, "sources"))
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	version, err := sourceFetcherObj.get(
 		ctx,
 		"git@github.com:segmentio/terracode-modules//profiles?ref=2021-03-18",
 		filepath.Join(tempDir, "dest1"),
 	)
 	require.NoError(t, err)
+	assert.Equal(t, "commit-2021-03-18", version)
 
-	err = sourceFetcherObj.get(
+	_, err = sourceFetcherObj.get(
 		ctx,
 		"git@github.com:segmentio/terracode-modules//profiles?ref=2021-03-18",
 		filepath.Join(tempDir, "dest2"),
 	)
 	require.NoError(t, err)
 
-	err = sourceFetcherObj.get(
+	_, err = sourceFetcherObj.get(
 		ctx,
 		"git@github.com:segmentio/terracode-modules//profiles?ref=2021-05-01",
 		filepath.Join(tempDir, "dest3"),
 	)
 	require.NoError(t, err)
 
+	require.NoError(t, sourceFetcherObj.cleanup())
+
 	contents := util.GetContents(t, tempDir)
 	assert.Equal(
 		t,
 		map[string][]string{
 			"dest1/profile.txt": {
-				"repoURL=git@github.com:segmentio/terracode-modules ref=2021-03-18",
+				"repoURL=git@github.com:segmentio/terracode-modules ref=commit-2021-03-18",
 			},
 			"dest2/profile.txt": {
-				"repoURL=git@github.com:segmentio/terracode-modules ref=2021-03-18",
+				"repoURL=git@github.com:segmentio/terracode-modules ref=commit-2021-03-18",
 			},
 			"dest3/profile.txt": {
-				"repoURL=git@github.com:segmentio/terracode-modules ref=2021-05-01",
+				"repoURL=git@github.com:segmentio/terracode-modules ref=commit-2021-05-01",
 			},
 		},
 		contents,
@@ -66,10 +76,409 @@ func TestSourceGet(t *testing.T) {
 	assert.Equal(
 		t,
 		[]string{
-			"cloneRepo repoURL=git@github.com:segmentio/terracode-modules,ref=2021-03-18",
-			"cloneRepo repoURL=git@github.com:segmentio/terracode-modules,ref=2021-03-18",
-			"cloneRepo repoURL=git@github.com:segmentio/terracode-modules,ref=2021-05-01",
+			"resolveRef repoURL=git@github.com:segmentio/terracode-modules,ref=2021-03-18",
+			"cloneRepo repoURL=git@github.com:segmentio/terracode-modules,ref=commit-2021-03-18,depth=1",
+			// The second fetch for the same ref uses the cache
+			"resolveRef repoURL=git@github.com:segmentio/terracode-modules,ref=2021-03-18",
+			"resolveRef repoURL=git@github.com:segmentio/terracode-modules,ref=2021-05-01",
+			"cloneRepo repoURL=git@github.com:segmentio/terracode-modules,ref=commit-2021-05-01,depth=1",
 		},
 		gitClientObj.calls,
 	)
 }
+
+func TestCleanupSources(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cacheDir := filepath.Join(tempDir, "sources")
+	sourceFetcherObj, err := newSourceFetcher(&fakeGitClient{}, cacheDir)
+	require.NoError(t, err)
+	registerSourceFetcher(sourceFetcherObj)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		"git@github.com:segmentio/terracode-modules//profiles?ref=main",
+		filepath.Join(tempDir, "dest"),
+	)
+	require.NoError(t, err)
+
+	exists, err := util.DirExists(cacheDir)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	CleanupSources()
+
+	exists, err = util.DirExists(cacheDir)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// The copies of the sources are kept
+	exists, err = util.DirExists(filepath.Join(tempDir, "dest"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestSourceGetConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	gitClientObj := &fakeGitClient{}
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	sourceFetcherObj, err := newSourceFetcher(gitClientObj, filepath.Join(tempDir, "sources"))
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(index int) {
+			_, err := sourceFetcherObj.get(
+				ctx,
+				"git@github.com:segmentio/terracode-modules//profiles?ref=main",
+				filepath.Join(tempDir, fmt.Sprintf("dest%d", index)),
+			)
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, <-errs)
+	}
+
+	cloneCalls := 0
+	for _, call := range gitClientObj.calls {
+		if strings.HasPrefix(call, "cloneRepo") {
+			cloneCalls++
+		}
+	}
+	assert.Equal(t, 1, cloneCalls)
+}
+
+func TestSourceGetOutsideRoot(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cacheDir := filepath.Join(tempDir, "sources")
+	sourceFetcherObj, err := newSourceFetcher(&fakeGitClient{}, cacheDir)
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	// A sibling of the clone whose name starts with the name of the clone directory
+	cacheKey := fmt.Sprintf(
+		"%x",
+		md5.Sum([]byte("git@github.com:segmentio/terracode-modules\ncommit-main")),
+	)
+	util.WriteFiles(
+		t,
+		filepath.Join(cacheDir, cacheKey+"-sibling"),
+		map[string]string{
+			"profile.yaml": "kind: Secret",
+		},
+	)
+
+	for s, subdir := range []string{"../" + cacheKey + "-sibling", "../.."} {
+		_, err = sourceFetcherObj.get(
+			ctx,
+			fmt.Sprintf("git@github.com:segmentio/terracode-modules//%s?ref=main", subdir),
+			filepath.Join(tempDir, fmt.Sprintf("dest%d", s)),
+		)
+		require.Error(t, err, subdir)
+		assert.Contains(t, err.Error(), "is outside of the source root", subdir)
+	}
+
+	// The root itself is allowed
+	_, err = sourceFetcherObj.get(
+		ctx,
+		"git@github.com:segmentio/terracode-modules?ref=main",
+		filepath.Join(tempDir, "dest-root"),
+	)
+	require.NoError(t, err)
+}
+
+func TestSourceGetGitRepo(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_git_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo.git")
+	workDir := filepath.Join(tempDir, "work")
+
+	runTestGit(t, tempDir, "init", "--bare", repoDir)
+	runTestGit(t, tempDir, "init", workDir)
+
+	util.WriteFiles(
+		t,
+		workDir,
+		map[string]string{
+			"profiles/nested/profile.yaml": "version: 1",
+		},
+	)
+	runTestGit(t, workDir, "add", ".")
+	runTestGit(t, workDir, "commit", "-m", "First commit")
+	runTestGit(t, workDir, "tag", "-a", "v1", "-m", "Version 1")
+
+	util.WriteFiles(
+		t,
+		workDir,
+		map[string]string{
+			"profiles/nested/profile.yaml": "version: 2",
+		},
+	)
+	runTestGit(t, workDir, "commit", "-am", "Second commit")
+	runTestGit(t, workDir, "push", repoDir, "HEAD:refs/heads/main", "v1")
+	runTestGit(t, repoDir, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	sourceFetcherObj, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	version, err := sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("git::file://%s//profiles/nested?ref=v1", repoDir),
+		filepath.Join(tempDir, "dest1"),
+	)
+	require.NoError(t, err)
+	assert.Regexp(t, "^[0-9a-f]{40}$", version)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("git::file://%s//profiles?depth=0", repoDir),
+		filepath.Join(tempDir, "dest2"),
+	)
+	require.NoError(t, err)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("git::file://%s//non-existent", repoDir),
+		filepath.Join(tempDir, "dest3"),
+	)
+	assert.Error(t, err)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		"hg::https://example.com/repo",
+		filepath.Join(tempDir, "dest4"),
+	)
+	assert.Error(t, err)
+
+	assert.Equal(
+		t,
+		map[string][]string{
+			"profile.yaml": {"version: 1"},
+		},
+		util.GetContents(t, filepath.Join(tempDir, "dest1")),
+	)
+	assert.Equal(
+		t,
+		map[string][]string{
+			"nested/profile.yaml": {"version: 2"},
+		},
+		util.GetContents(t, filepath.Join(tempDir, "dest2")),
+	)
+}
+
+func runTestGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command(
+		"git",
+		append(
+			[]string{
+				"-c", "user.name=kubeapply",
+				"-c", "user.email=kubeapply@example.com",
+				"-c", "init.defaultBranch=main",
+			},
+			args...,
+		)...,
+	)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestSourceGetArchive(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_archive_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	archivesDir := filepath.Join(tempDir, "archives")
+	require.NoError(t, os.MkdirAll(archivesDir, 0755))
+
+	archiveFiles := map[string]string{
+		"bundle/profiles/deployment.yaml": "kind: Deployment",
+	}
+	util.WriteArchive(
+		t,
+		filepath.Join(archivesDir, "bundle.tar.gz"),
+		util.ArchiveFormatTarGz,
+		archiveFiles,
+	)
+	util.WriteArchive(
+		t,
+		filepath.Join(archivesDir, "bundle.zip"),
+		util.ArchiveFormatZip,
+		archiveFiles,
+	)
+
+	tarContents, err := ioutil.ReadFile(filepath.Join(archivesDir, "bundle.tar.gz"))
+	require.NoError(t, err)
+	tarChecksum := fmt.Sprintf("%x", sha256.Sum256(tarContents))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(archivesDir)))
+	defer server.Close()
+
+	sourceFetcherObj, err := newSourceFetcher(&fakeGitClient{}, filepath.Join(tempDir, "sources"))
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	version, err := sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf(
+			"%s/bundle.tar.gz//bundle/profiles?checksum=sha256:%s",
+			server.URL,
+			tarChecksum,
+		),
+		filepath.Join(tempDir, "dest1"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("sha256:%s", tarChecksum), version)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("file://%s//bundle", filepath.Join(archivesDir, "bundle.zip")),
+		filepath.Join(tempDir, "dest2"),
+	)
+	require.NoError(t, err)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf(
+			"%s/bundle.tar.gz?checksum=sha256:%s",
+			server.URL,
+			strings.Repeat("0", 64),
+		),
+		filepath.Join(tempDir, "dest3"),
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Checksum mismatch")
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("%s/non-existent.zip", server.URL),
+		filepath.Join(tempDir, "dest4"),
+	)
+	assert.Error(t, err)
+
+	assert.Equal(
+		t,
+		map[string][]string{
+			"deployment.yaml": {"kind: Deployment"},
+		},
+		util.GetContents(t, filepath.Join(tempDir, "dest1")),
+	)
+	assert.Equal(
+		t,
+		map[string][]string{
+			"profiles/deployment.yaml": {"kind: Deployment"},
+		},
+		util.GetContents(t, filepath.Join(tempDir, "dest2")),
+	)
+}
+
+func TestSourceGetOCI(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_oci_sources_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	registry := util.NewTestOCIRegistry()
+	defer registry.Server.Close()
+
+	pushFiles := func(tag string, files map[string]string) string {
+		archivePath := filepath.Join(tempDir, fmt.Sprintf("%s.tar.gz", tag))
+		util.WriteArchive(t, archivePath, util.ArchiveFormatTarGz, files)
+		contents, err := ioutil.ReadFile(archivePath)
+		require.NoError(t, err)
+
+		return registry.Push(
+			t,
+			"org/bundle",
+			tag,
+			[]util.TestOCILayer{
+				{
+					MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+					Contents:  contents,
+				},
+			},
+		)
+	}
+	digest1 := pushFiles("v1", map[string]string{"profiles/deployment.yaml": "version: 1"})
+
+	sourceFetcherObj, err := newSourceFetcher(&fakeGitClient{}, filepath.Join(tempDir, "sources"))
+	require.NoError(t, err)
+	defer sourceFetcherObj.cleanup()
+
+	version, err := sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("oci://%s/org/bundle:v1//profiles", registry.Host()),
+		filepath.Join(tempDir, "dest1"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, digest1, version)
+
+	// Pinning to the digest gets the same content
+	version, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("oci://%s/org/bundle@%s//profiles", registry.Host(), digest1),
+		filepath.Join(tempDir, "dest2"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, digest1, version)
+
+	// Moving the tag picks up the new content
+	digest2 := pushFiles("v1", map[string]string{"profiles/deployment.yaml": "version: 2"})
+	assert.NotEqual(t, digest1, digest2)
+
+	version, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("oci://%s/org/bundle:v1//profiles", registry.Host()),
+		filepath.Join(tempDir, "dest3"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, digest2, version)
+
+	_, err = sourceFetcherObj.get(
+		ctx,
+		fmt.Sprintf("oci://%s/org/bundle:non-existent", registry.Host()),
+		filepath.Join(tempDir, "dest4"),
+	)
+	assert.Error(t, err)
+
+	for dest, expected := range map[string]string{
+		"dest1": "version: 1",
+		"dest2": "version: 1",
+		"dest3": "version: 2",
+	} {
+		assert.Equal(
+			t,
+			map[string][]string{
+				"deployment.yaml": {expected},
+			},
+			util.GetContents(t, filepath.Join(tempDir, dest)),
+		)
+	}
+}
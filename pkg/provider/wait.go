@@ -0,0 +1,266 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Number of recent events to include for each resource that isn't ready
+	maxWaitEvents = 5
+)
+
+var (
+	// How often to check the status of resources that we're waiting on; can be overridden
+	// in tests.
+	waitPollInterval = 5 * time.Second
+)
+
+type waitConfig struct {
+	timeout time.Duration
+	kinds   map[string]struct{}
+}
+
+type waitTarget struct {
+	id        string
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// getWaitConfig returns the wait settings for a profile or nil if waiting isn't enabled.
+func getWaitConfig(data resourceGetter) (*waitConfig, error) {
+	waitRows := data.Get("wait").([]interface{})
+	if len(waitRows) == 0 || waitRows[0] == nil {
+		return nil, nil
+	}
+	waitRow := waitRows[0].(map[string]interface{})
+
+	timeout, err := time.ParseDuration(waitRow["timeout"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse wait timeout: %+v", err)
+	}
+
+	config := &waitConfig{
+		timeout: timeout,
+		kinds:   map[string]struct{}{},
+	}
+
+	kinds := waitRow["kinds"].([]interface{})
+	if len(kinds) == 0 {
+		for _, kind := range kube.WaitKinds {
+			config.kinds[kind] = struct{}{}
+		}
+	} else {
+		for _, kind := range kinds {
+			config.kinds[kind.(string)] = struct{}{}
+		}
+	}
+
+	return config, nil
+}
+
+// wait waits for the argument manifests to be ready if the resource has a wait block. It's
+// a no-op otherwise.
+func (p *providerContext) wait(
+	ctx context.Context,
+	data resourceGetter,
+	manifests []kube.Manifest,
+) diag.Diagnostics {
+	config, err := getWaitConfig(data)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if config == nil {
+		return nil
+	}
+
+	return p.waitForReady(ctx, manifests, config, moduleName(data))
+}
+
+// waitForReady polls the cluster until all of the resources in the argument manifests that
+// match the wait config are ready. Resources that fail or aren't ready before the timeout
+// are returned as errors. Errors getting the status of a resource are retried until the
+// timeout.
+func (p *providerContext) waitForReady(
+	ctx context.Context,
+	manifests []kube.Manifest,
+	config *waitConfig,
+	moduleName string,
+) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	pending := []waitTarget{}
+	for _, manifest := range manifests {
+		if _, ok := config.kinds[manifest.Head.Kind]; !ok || manifest.Head.Metadata == nil {
+			continue
+		}
+
+		pending = append(
+			pending,
+			waitTarget{
+				id:        manifest.ID,
+				gvk:       schema.FromAPIVersionAndKind(manifest.Head.Version, manifest.Head.Kind),
+				namespace: manifest.Head.Metadata.Namespace,
+				name:      manifest.Head.Metadata.Name,
+			},
+		)
+	}
+
+	log.Infof(
+		"Waiting up to %s for %d resources to be ready in %s",
+		config.timeout,
+		len(pending),
+		moduleName,
+	)
+
+	deadline := time.Now().Add(config.timeout)
+	statuses := map[string]kube.ObjectStatus{}
+	failed := []waitTarget{}
+
+	for len(pending) > 0 {
+		notReady := []waitTarget{}
+
+		for _, target := range pending {
+			obj, err := kube.GetObject(
+				ctx,
+				p.dynamicClient,
+				p.restMapper,
+				target.gvk,
+				target.namespace,
+				target.name,
+			)
+
+			var status kube.ObjectStatus
+			if err != nil {
+				// Errors here may be transient (e.g., API server timeouts), so keep retrying
+				// until the deadline.
+				log.Warnf("Could not get status of %s: %+v", target.id, err)
+				status = kube.ObjectStatus{
+					Message: fmt.Sprintf("Could not get status: %+v", err),
+				}
+			} else if obj == nil {
+				status = kube.ObjectStatus{Message: "Resource not found in cluster"}
+			} else {
+				status = kube.GetObjectStatus(obj)
+			}
+			statuses[target.id] = status
+
+			if status.Failed {
+				failed = append(failed, target)
+			} else if !status.Ready {
+				notReady = append(notReady, target)
+			}
+		}
+
+		pending = notReady
+		if len(pending) == 0 || time.Now().After(deadline) {
+			break
+		}
+
+		log.Infof("Still waiting on %d resources in %s", len(pending), moduleName)
+
+		select {
+		case <-ctx.Done():
+			return append(diags, diag.FromErr(ctx.Err())...)
+		case <-time.After(waitPollInterval):
+		}
+	}
+
+	for _, target := range append(failed, pending...) {
+		status := statuses[target.id]
+		summary := fmt.Sprintf("%s is not ready: %s", target.id, status.Message)
+		if !status.Failed {
+			summary = fmt.Sprintf("%s (timed out after %s)", summary, config.timeout)
+		}
+
+		diags = append(
+			diags,
+			diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  summary,
+				Detail:   p.waitDetail(ctx, target, status),
+			},
+		)
+	}
+
+	return diags
+}
+
+// waitDetail returns the conditions and recent events for a resource that isn't ready.
+func (p *providerContext) waitDetail(
+	ctx context.Context,
+	target waitTarget,
+	status kube.ObjectStatus,
+) string {
+	lines := []string{}
+
+	if len(status.Conditions) > 0 {
+		lines = append(lines, "Conditions:")
+		for _, condition := range status.Conditions {
+			lines = append(lines, fmt.Sprintf("  %s", condition.String()))
+		}
+	}
+
+	events, err := p.recentEvents(ctx, target)
+	if err != nil {
+		log.Warnf("Could not get events for %s: %+v", target.id, err)
+	} else if len(events) > 0 {
+		lines = append(lines, "Recent events:")
+		for _, event := range events {
+			lines = append(
+				lines,
+				fmt.Sprintf("  %s %s: %s", event.Type, event.Reason, event.Message),
+			)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (p *providerContext) recentEvents(
+	ctx context.Context,
+	target waitTarget,
+) ([]corev1.Event, error) {
+	eventList, err := p.rawClient.CoreV1().Events(target.namespace).List(
+		ctx,
+		metav1.ListOptions{
+			FieldSelector: fields.Set{
+				"involvedObject.kind": target.gvk.Kind,
+				"involvedObject.name": target.name,
+			}.String(),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter again in case the API (or fake) doesn't support the field selectors above
+	events := []corev1.Event{}
+	for _, event := range eventList.Items {
+		if event.InvolvedObject.Kind == target.gvk.Kind &&
+			event.InvolvedObject.Name == target.name {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(a, b int) bool {
+		return events[a].LastTimestamp.Before(&events[b].LastTimestamp)
+	})
+	if len(events) > maxWaitEvents {
+		events = events[len(events)-maxWaitEvents:]
+	}
+
+	return events, nil
+}
@@ -0,0 +1,442 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/cluster/kube"
+	"github.com/segmentio/terraform-provider-kubeapply/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sschema "k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWaitForReady(t *testing.T) {
+	ctx := context.Background()
+
+	origInterval := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	defer func() {
+		waitPollInterval = origInterval
+	}()
+
+	manifestsDir, err := ioutil.TempDir("", "kubeapply_test_wait_")
+	require.NoError(t, err)
+	defer os.RemoveAll(manifestsDir)
+
+	util.WriteFiles(
+		t,
+		manifestsDir,
+		map[string]string{
+			"deployments.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ready
+  namespace: test-namespace
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: not-ready
+  namespace: test-namespace
+`,
+			"job.yaml": `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: failed
+  namespace: test-namespace
+`,
+			"configmap.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-waited-on
+  namespace: test-namespace
+`,
+		},
+	)
+	manifests, err := kube.GetManifests([]string{manifestsDir})
+	require.NoError(t, err)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		k8sschema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		k8sschema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+		meta.RESTScopeNamespace,
+	)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(
+		runtime.NewScheme(),
+		testWaitObject(
+			"apps/v1",
+			"Deployment",
+			"ready",
+			map[string]interface{}{
+				"replicas":          int64(1),
+				"updatedReplicas":   int64(1),
+				"availableReplicas": int64(1),
+			},
+		),
+		testWaitObject(
+			"apps/v1",
+			"Deployment",
+			"not-ready",
+			map[string]interface{}{
+				"replicas":          int64(1),
+				"updatedReplicas":   int64(1),
+				"availableReplicas": int64(0),
+			},
+		),
+		testWaitObject(
+			"batch/v1",
+			"Job",
+			"failed",
+			map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{
+						"type":   "Failed",
+						"status": "True",
+						"reason": "BackoffLimitExceeded",
+					},
+				},
+			},
+		),
+	)
+
+	rawClient := fake.NewSimpleClientset(
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "event1",
+				Namespace: "test-namespace",
+			},
+			InvolvedObject: corev1.ObjectReference{
+				Kind: "Deployment",
+				Name: "not-ready",
+			},
+			Type:    "Warning",
+			Reason:  "FailedCreate",
+			Message: "quota exceeded",
+		},
+	)
+
+	providerCtx := &providerContext{
+		dynamicClient: dynamicClient,
+		rawClient:     rawClient,
+		restMapper:    mapper,
+	}
+
+	diags := providerCtx.waitForReady(
+		ctx,
+		manifests,
+		&waitConfig{
+			timeout: 50 * time.Millisecond,
+			kinds: map[string]struct{}{
+				"Deployment": {},
+				"Job":        {},
+			},
+		},
+		"test-module",
+	)
+	require.Equal(t, 2, len(diags))
+
+	summaries := []string{}
+	for _, diagnostic := range diags {
+		summaries = append(summaries, diagnostic.Summary)
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"batch/v1.Job.test-namespace.failed is not ready: Job failed",
+			"apps/v1.Deployment.test-namespace.not-ready is not ready: 0 of 1 updated replicas are available (timed out after 50ms)",
+		},
+		summaries,
+	)
+	assert.Contains(t, diags[0].Detail, "Failed=True (BackoffLimitExceeded)")
+	assert.Contains(t, diags[1].Detail, "Warning FailedCreate: quota exceeded")
+}
+
+func TestGetWaitConfig(t *testing.T) {
+	config, err := getWaitConfig(
+		fakeDiffChangerSetter{
+			newValues: map[string]interface{}{
+				"wait": []interface{}{},
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	config, err = getWaitConfig(
+		fakeDiffChangerSetter{
+			newValues: map[string]interface{}{
+				"wait": []interface{}{
+					map[string]interface{}{
+						"timeout": "2m",
+						"kinds":   []interface{}{},
+					},
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, config.timeout)
+	assert.Equal(t, len(kube.WaitKinds), len(config.kinds))
+
+	_, err = getWaitConfig(
+		fakeDiffChangerSetter{
+			newValues: map[string]interface{}{
+				"wait": []interface{}{
+					map[string]interface{}{
+						"timeout": "not a duration",
+						"kinds":   []interface{}{"Deployment"},
+					},
+				},
+			},
+		},
+	)
+	assert.Error(t, err)
+}
+
+func testWaitObject(
+	apiVersion string,
+	kind string,
+	name string,
+	status map[string]interface{},
+) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "test-namespace",
+			},
+			"status": status,
+		},
+	}
+}
+
+func TestWaitForReadyRetries(t *testing.T) {
+	ctx := context.Background()
+
+	origInterval := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	defer func() {
+		waitPollInterval = origInterval
+	}()
+
+	manifestsDir, err := ioutil.TempDir("", "kubeapply_test_wait_")
+	require.NoError(t, err)
+	defer os.RemoveAll(manifestsDir)
+
+	util.WriteFiles(
+		t,
+		manifestsDir,
+		map[string]string{
+			"deployment.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ready
+  namespace: test-namespace
+`,
+		},
+	)
+	manifests, err := kube.GetManifests([]string{manifestsDir})
+	require.NoError(t, err)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		k8sschema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		meta.RESTScopeNamespace,
+	)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(
+		runtime.NewScheme(),
+		testWaitObject(
+			"apps/v1",
+			"Deployment",
+			"ready",
+			map[string]interface{}{
+				"replicas":          int64(1),
+				"updatedReplicas":   int64(1),
+				"availableReplicas": int64(1),
+			},
+		),
+	)
+
+	// Fail the first few gets
+	numGets := 0
+	dynamicClient.PrependReactor(
+		"get",
+		"deployments",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			numGets++
+			if numGets <= 2 {
+				return true, nil, errors.NewServerTimeout(
+					action.GetResource().GroupResource(),
+					"get",
+					1,
+				)
+			}
+			return false, nil, nil
+		},
+	)
+
+	providerCtx := &providerContext{
+		dynamicClient: dynamicClient,
+		rawClient:     fake.NewSimpleClientset(),
+		restMapper:    mapper,
+	}
+	config := &waitConfig{
+		timeout: time.Second,
+		kinds: map[string]struct{}{
+			"Deployment": {},
+		},
+	}
+
+	diags := providerCtx.waitForReady(ctx, manifests, config, "test-module")
+	assert.Equal(t, 0, len(diags))
+	assert.Equal(t, 3, numGets)
+
+	// Errors that persist until the timeout are reported
+	numGets = -1000
+	config.timeout = 50 * time.Millisecond
+
+	diags = providerCtx.waitForReady(ctx, manifests, config, "test-module")
+	require.Equal(t, 1, len(diags))
+	assert.Contains(
+		t,
+		diags[0].Summary,
+		"apps/v1.Deployment.test-namespace.ready is not ready: Could not get status:",
+	)
+	assert.Contains(t, diags[0].Summary, "(timed out after 50ms)")
+}
+
+func TestResourceProfileWaitFailure(t *testing.T) {
+	ctx := context.Background()
+
+	origInterval := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	defer func() {
+		waitPollInterval = origInterval
+	}()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_wait_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clusterClient, err := cluster.NewFakeClient(
+		ctx,
+		&cluster.ClientConfig{
+			Config: &cluster.Config{
+				Cluster: "testCluster",
+			},
+		},
+	)
+	require.NoError(t, err)
+	clusterClient.(*cluster.FakeClient).LiveHashes = map[string]string{
+		"v1.Service.testNamespace2.testName": "liveHash",
+	}
+
+	sourceFetcher, err := newSourceFetcher(
+		&commandLineGitClient{},
+		filepath.Join(tempDir, "sources"),
+	)
+	require.NoError(t, err)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(
+		k8sschema.GroupVersionKind{Version: "v1", Kind: "Service"},
+		meta.RESTScopeNamespace,
+	)
+
+	// The service is never found in the cluster, so it won't become ready
+	providerCtx := &providerContext{
+		allowDeletes:  true,
+		canRun:        true,
+		clusterClient: clusterClient,
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		rawClient:     fake.NewSimpleClientset(),
+		restMapper:    mapper,
+		sourceFetcher: sourceFetcher,
+		tempDir:       tempDir,
+	}
+
+	values := map[string]interface{}{
+		"live_hashes": map[string]interface{}{},
+		"no_diff":     false,
+		"parameters": map[string]interface{}{
+			"value2":         "Value2",
+			"serviceAccount": "",
+		},
+		"set":           schema.NewSet(schema.HashString, []interface{}{}),
+		"show_expanded": false,
+		"source":        "testdata/app2",
+		"wait": []interface{}{
+			map[string]interface{}{
+				"timeout": "50ms",
+				"kinds":   []interface{}{"Service"},
+			},
+		},
+	}
+
+	// The applied resources are still recorded in the state if the wait fails
+	createData := &fakeChangerSetter{
+		oldValues: map[string]interface{}{},
+		newValues: values,
+	}
+	diags := resourceProfileCreate(ctx, createData, providerCtx)
+	require.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "v1.Service.testNamespace2.testName is not ready")
+	assert.NotEqual(t, "", createData.Id())
+	assert.Contains(
+		t,
+		createData.Get("resources").(map[string]interface{}),
+		"v1.Service.testNamespace2.testName",
+	)
+	assert.Equal(
+		t,
+		map[string]interface{}{"v1.Service.testNamespace2.testName": "liveHash"},
+		createData.Get("live_hashes"),
+	)
+
+	// Same for updates
+	values["diff"] = map[string]interface{}{"result": "diff"}
+	values["live_hashes"] = map[string]interface{}{}
+	updateData := &fakeChangerSetter{
+		id: "test-profile",
+		oldValues: map[string]interface{}{
+			"resources": values["resources"],
+		},
+		newValues: values,
+	}
+	diags = resourceProfileUpdate(ctx, updateData, providerCtx)
+	require.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "v1.Service.testNamespace2.testName is not ready")
+	assert.Equal(
+		t,
+		map[string]interface{}{"v1.Service.testNamespace2.testName": "liveHash"},
+		updateData.Get("live_hashes"),
+	)
+}
@@ -0,0 +1,175 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ArchiveFormatTar is the format for uncompressed tarballs.
+	ArchiveFormatTar = "tar"
+
+	// ArchiveFormatTarGz is the format for gzipped tarballs.
+	ArchiveFormatTarGz = "tar.gz"
+
+	// ArchiveFormatZip is the format for zip files.
+	ArchiveFormatZip = "zip"
+)
+
+// GetArchiveFormat returns the format of the archive at the argument path or URL based on its
+// extension. It returns an empty string if the extension isn't for a supported format.
+func GetArchiveFormat(path string) string {
+	lowerPath := strings.ToLower(path)
+
+	switch {
+	case strings.HasSuffix(lowerPath, ".tar.gz"), strings.HasSuffix(lowerPath, ".tgz"):
+		return ArchiveFormatTarGz
+	case strings.HasSuffix(lowerPath, ".tar"):
+		return ArchiveFormatTar
+	case strings.HasSuffix(lowerPath, ".zip"):
+		return ArchiveFormatZip
+	default:
+		return ""
+	}
+}
+
+// ExtractArchive extracts the archive at the argument path into the destination directory.
+// Only regular files and directories are extracted; entries that would be written outside of
+// the destination are treated as errors.
+func ExtractArchive(archivePath string, format string, destDir string) error {
+	log.Debugf("Extracting %s archive %s to %s", format, archivePath, destDir)
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	switch format {
+	case ArchiveFormatTar, ArchiveFormatTarGz:
+		return extractTar(archivePath, format == ArchiveFormatTarGz, destDir)
+	case ArchiveFormatZip:
+		return extractZip(archivePath, destDir)
+	default:
+		return fmt.Errorf("Unsupported archive format: %s", format)
+	}
+}
+
+func extractTar(archivePath string, gzipped bool, destDir string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("Error reading gzip archive: %+v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Error reading tar archive: %+v", err)
+		}
+
+		destPath, err := archiveDestPath(destDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(destPath, tarReader); err != nil {
+				return err
+			}
+		default:
+			log.Warnf("Skipping unsupported archive entry %s", header.Name)
+		}
+	}
+}
+
+func extractZip(archivePath string, destDir string) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("Error reading zip archive: %+v", err)
+	}
+	defer zipReader.Close()
+
+	for _, zipFile := range zipReader.File {
+		destPath, err := archiveDestPath(destDir, zipFile.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := zipFile.Mode()
+
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			reader, err := zipFile.Open()
+			if err != nil {
+				return err
+			}
+			err = writeArchiveFile(destPath, reader)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			log.Warnf("Skipping unsupported archive entry %s", zipFile.Name)
+		}
+	}
+
+	return nil
+}
+
+// archiveDestPath returns the path that the argument archive entry should be extracted to.
+func archiveDestPath(destDir string, name string) (string, error) {
+	destPath := filepath.Join(destDir, name)
+
+	if destPath != filepath.Clean(destDir) &&
+		!strings.HasPrefix(destPath, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Archive entry %s is outside of the destination", name)
+	}
+
+	return destPath, nil
+}
+
+func writeArchiveFile(destPath string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return err
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArchiveFormat(t *testing.T) {
+	assert.Equal(t, ArchiveFormatTarGz, GetArchiveFormat("https://example.com/bundle.tar.gz"))
+	assert.Equal(t, ArchiveFormatTarGz, GetArchiveFormat("/path/to/bundle.TGZ"))
+	assert.Equal(t, ArchiveFormatZip, GetArchiveFormat("file:///path/to/bundle.zip"))
+	assert.Equal(t, ArchiveFormatTar, GetArchiveFormat("https://example.com/bundle.tar"))
+	assert.Equal(t, "", GetArchiveFormat("https://example.com/bundle.tar.bz2"))
+}
+
+func TestExtractArchive(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_archives_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	for _, format := range []string{ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatZip} {
+		archivePath := filepath.Join(tempDir, "bundle."+format)
+		WriteArchive(
+			t,
+			archivePath,
+			format,
+			map[string]string{
+				"manifests/deployment.yaml": "kind: Deployment",
+				"manifests/nested/cm.yaml":  "kind: ConfigMap",
+			},
+		)
+
+		destDir := filepath.Join(tempDir, "extracted-"+format)
+		err = ExtractArchive(archivePath, format, destDir)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			map[string][]string{
+				"manifests/deployment.yaml": {"kind: Deployment"},
+				"manifests/nested/cm.yaml":  {"kind: ConfigMap"},
+			},
+			GetContents(t, destDir),
+		)
+
+		badArchivePath := filepath.Join(tempDir, "bad-bundle."+format)
+		WriteArchive(
+			t,
+			badArchivePath,
+			format,
+			map[string]string{
+				"../outside.yaml": "kind: Deployment",
+			},
+		)
+		err = ExtractArchive(badArchivePath, format, filepath.Join(tempDir, "bad-"+format))
+		assert.Error(t, err)
+
+		_, err = os.Stat(filepath.Join(tempDir, "outside.yaml"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// CloneRepo makes a shallow clone of the argument repo at a single ref.
+var commitSHARegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// CloneRepo makes a clone of the argument repo at a single ref. If depth is greater than zero,
+// then the clone is shallow and only includes that many commits; otherwise, the full history
+// is fetched.
 //
 // See https://stackoverflow.com/questions/3489173/how-to-clone-git-repository-with-specific-revision-changeset
 // for a discussion of the commands run.
-func CloneRepo(ctx context.Context, url string, ref string, path string) error {
-	log.Debugf("Making clone of %s at ref=%s in %s", url, ref, path)
+func CloneRepo(ctx context.Context, url string, ref string, depth int, path string) error {
+	log.Debugf("Making clone of %s at ref=%s,depth=%d in %s", url, ref, depth, path)
 
 	err := os.MkdirAll(path, 0755)
 	if err != nil {
@@ -46,19 +52,45 @@ func CloneRepo(ctx context.Context, url string, ref string, path string) error {
 		return fmt.Errorf("Error updating git remote: %+v", err)
 	}
 
-	err = runGit(
-		ctx,
-		[]string{
-			"fetch",
-			"origin",
-			"--depth",
-			"1",
-			ref,
-		},
-		path,
-	)
+	fetchArgs := []string{
+		"fetch",
+		"origin",
+	}
+	if depth > 0 {
+		fetchArgs = append(fetchArgs, "--depth", fmt.Sprintf("%d", depth))
+	}
+	fetchArgs = append(fetchArgs, ref)
+
+	resetTarget := "FETCH_HEAD"
+
+	err = runGit(ctx, fetchArgs, path)
 	if err != nil {
-		return fmt.Errorf("Error fetching ref: %+v", err)
+		if !commitSHARegexp.MatchString(ref) {
+			return fmt.Errorf("Error fetching ref: %+v", err)
+		}
+
+		// Fetching a commit by its SHA requires the server to allow requests for unadvertised
+		// objects, which many don't, so fall back to fetching everything and checking out the
+		// commit from there.
+		log.Infof(
+			"Could not fetch commit %s from %s directly, fetching all refs instead: %+v",
+			ref,
+			url,
+			err,
+		)
+		err = runGit(
+			ctx,
+			[]string{
+				"fetch",
+				"--tags",
+				"origin",
+			},
+			path,
+		)
+		if err != nil {
+			return fmt.Errorf("Error fetching refs: %+v", err)
+		}
+		resetTarget = ref
 	}
 
 	err = runGit(
@@ -66,7 +98,7 @@ func CloneRepo(ctx context.Context, url string, ref string, path string) error {
 		[]string{
 			"reset",
 			"--hard",
-			"FETCH_HEAD",
+			resetTarget,
 		},
 		path,
 	)
@@ -77,7 +109,56 @@ func CloneRepo(ctx context.Context, url string, ref string, path string) error {
 	return nil
 }
 
+// ResolveRef returns the SHA of the commit that the argument ref points to in the argument
+// remote repo. Refs that are already full commit SHAs are returned as-is.
+func ResolveRef(ctx context.Context, url string, ref string) (string, error) {
+	if commitSHARegexp.MatchString(ref) {
+		return ref, nil
+	}
+
+	out, err := runGitOutput(
+		ctx,
+		[]string{
+			"ls-remote",
+			url,
+			ref,
+		},
+		"",
+	)
+	if err != nil {
+		return "", fmt.Errorf("Error listing remote refs: %+v", err)
+	}
+
+	remoteRefs := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			remoteRefs[fields[1]] = fields[0]
+		}
+	}
+
+	// Check for exact matches in order of precedence; annotated tags are "peeled" so that we
+	// get the commit instead of the tag object.
+	for _, name := range []string{
+		ref,
+		fmt.Sprintf("refs/heads/%s", ref),
+		fmt.Sprintf("refs/tags/%s^{}", ref),
+		fmt.Sprintf("refs/tags/%s", ref),
+	} {
+		if sha, ok := remoteRefs[name]; ok {
+			return sha, nil
+		}
+	}
+
+	return "", fmt.Errorf("Could not find ref %s in %s", ref, url)
+}
+
 func runGit(ctx context.Context, args []string, dir string) error {
+	_, err := runGitOutput(ctx, args, dir)
+	return err
+}
+
+func runGitOutput(ctx context.Context, args []string, dir string) ([]byte, error) {
 	log.Debugf("Running git with args %+v", args)
 
 	cmd := exec.CommandContext(
@@ -88,8 +169,8 @@ func runGit(ctx context.Context, args []string, dir string) error {
 	cmd.Dir = dir
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Error running git: %s", string(out))
+		return nil, fmt.Errorf("Error running git: %s", string(out))
 	}
 
-	return nil
+	return out, nil
 }
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneRepoCommit(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_git_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+	runTestGit(t, repoDir, "init")
+
+	WriteFiles(t, repoDir, map[string]string{"profile.yaml": "version: 1"})
+	runTestGit(t, repoDir, "add", "-A")
+	runTestGit(t, repoDir, "commit", "-m", "First commit")
+	firstSHA := strings.TrimSpace(runTestGit(t, repoDir, "rev-parse", "HEAD"))
+
+	WriteFiles(t, repoDir, map[string]string{"profile.yaml": "version: 2"})
+	runTestGit(t, repoDir, "commit", "-am", "Second commit")
+
+	repoURL := "file://" + repoDir
+
+	err = CloneRepo(ctx, repoURL, firstSHA, 1, filepath.Join(tempDir, "clone1"))
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"version: 1",
+		GetFileContents(t, filepath.Join(tempDir, "clone1", "profile.yaml")),
+	)
+
+	// Version 0 of the git protocol only allows fetching advertised refs, like many servers,
+	// so the commit can only be checked out after a full fetch
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.version")
+	t.Setenv("GIT_CONFIG_VALUE_0", "0")
+
+	err = CloneRepo(ctx, repoURL, firstSHA, 1, filepath.Join(tempDir, "clone2"))
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"version: 1",
+		GetFileContents(t, filepath.Join(tempDir, "clone2", "profile.yaml")),
+	)
+
+	// Other refs still need to be fetched directly
+	err = CloneRepo(ctx, repoURL, "non-existent", 1, filepath.Join(tempDir, "clone3"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Error fetching ref")
+}
+
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command(
+		"git",
+		append(
+			[]string{
+				"-c", "user.name=kubeapply",
+				"-c", "user.email=kubeapply@example.com",
+				"-c", "init.defaultBranch=main",
+			},
+			args...,
+		)...,
+	)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
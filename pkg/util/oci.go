@@ -0,0 +1,254 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotation used by ORAS and other tools to store the file name of a layer
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+var (
+	// Media types of manifests that we can pull
+	ociManifestMediaTypes = []types.MediaType{
+		types.OCIManifestSchema1,
+		types.DockerManifestSchema2,
+	}
+
+	ociDigestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	ociTagRegexp    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+)
+
+// OCIReference is a reference to an artifact in an OCI registry.
+type OCIReference struct {
+	// Registry is the host (and optional port) of the registry, e.g. "ghcr.io".
+	Registry string
+
+	// Repository is the path of the repository in the registry, e.g. "org/manifests".
+	Repository string
+
+	// Reference is either a tag or a digest in the format "sha256:[hex digest]".
+	Reference string
+}
+
+// String returns the reference in the standard "registry/repo:tag" or "registry/repo@digest"
+// format.
+func (r OCIReference) String() string {
+	if r.IsDigest() {
+		return fmt.Sprintf("%s/%s@%s", r.Registry, r.Repository, r.Reference)
+	}
+	return fmt.Sprintf("%s/%s:%s", r.Registry, r.Repository, r.Reference)
+}
+
+// IsDigest returns whether this reference is pinned to a digest.
+func (r OCIReference) IsDigest() bool {
+	return strings.HasPrefix(r.Reference, "sha256:")
+}
+
+// ParseOCIReference parses a reference in the format "registry/repo:tag" or
+// "registry/repo@sha256:[hex digest]". If there's no tag or digest, the "latest" tag is used.
+func ParseOCIReference(rawRef string) (OCIReference, error) {
+	ref := OCIReference{}
+
+	slashIndex := strings.Index(rawRef, "/")
+	if slashIndex <= 0 {
+		return ref, fmt.Errorf("OCI reference %s is missing a registry", rawRef)
+	}
+	ref.Registry = rawRef[:slashIndex]
+	path := rawRef[slashIndex+1:]
+
+	if atIndex := strings.Index(path, "@"); atIndex >= 0 {
+		ref.Repository = path[:atIndex]
+		ref.Reference = path[atIndex+1:]
+		if !ociDigestRegexp.MatchString(ref.Reference) {
+			return ref, fmt.Errorf(
+				"Digest in OCI reference %s must be in the format sha256:[hex digest]",
+				rawRef,
+			)
+		}
+	} else if colonIndex := strings.LastIndex(path, ":"); colonIndex >= 0 {
+		ref.Repository = path[:colonIndex]
+		ref.Reference = path[colonIndex+1:]
+		if !ociTagRegexp.MatchString(ref.Reference) {
+			return ref, fmt.Errorf("Invalid tag in OCI reference %s", rawRef)
+		}
+	} else {
+		ref.Repository = path
+		ref.Reference = "latest"
+	}
+
+	if ref.Repository == "" {
+		return ref, fmt.Errorf("OCI reference %s is missing a repository", rawRef)
+	}
+
+	return ref, nil
+}
+
+// OCIClient pulls artifacts from registries that implement the OCI distribution spec.
+// Credentials are read from the docker config, including any credential helpers.
+type OCIClient struct {
+	keychain authn.Keychain
+}
+
+// NewOCIClient returns a new OCIClient.
+func NewOCIClient() *OCIClient {
+	return &OCIClient{
+		keychain: authn.DefaultKeychain,
+	}
+}
+
+// ResolveManifest fetches the manifest for the argument reference. It returns the image that
+// the manifest describes along with the manifest's digest.
+func (c *OCIClient) ResolveManifest(
+	ctx context.Context,
+	ref OCIReference,
+) (v1.Image, string, error) {
+	nameRef, err := name.ParseReference(ref.String())
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Digest references are verified by the client
+	desc, err := remote.Get(nameRef, c.remoteOptions(ctx)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error fetching manifest for %s: %+v", ref, err)
+	}
+	if !containsMediaType(ociManifestMediaTypes, desc.MediaType) {
+		return nil, "", fmt.Errorf(
+			"Unsupported manifest type %s for %s",
+			desc.MediaType,
+			ref,
+		)
+	}
+
+	image, err := desc.Image()
+	if err != nil {
+		return nil, "", fmt.Errorf("Could not parse manifest for %s: %+v", ref, err)
+	}
+
+	return image, desc.Digest.String(), nil
+}
+
+// ExtractLayers downloads the layers in the argument image and unpacks them into the
+// destination directory. Tarball layers are extracted, other layers with a title annotation
+// are written as files, and everything else is skipped. The layers are fetched with the
+// context that the image was resolved with.
+func (c *OCIClient) ExtractLayers(
+	ref OCIReference,
+	image v1.Image,
+	destDir string,
+) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	manifest, err := image.Manifest()
+	if err != nil {
+		return fmt.Errorf("Could not parse manifest for %s: %+v", ref, err)
+	}
+
+	blobsDir, err := ioutil.TempDir("", "kubeapply_oci_blobs_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(blobsDir)
+
+	for l, layer := range manifest.Layers {
+		var format string
+		switch {
+		case strings.HasSuffix(string(layer.MediaType), "tar+gzip"):
+			format = ArchiveFormatTarGz
+		case strings.HasSuffix(string(layer.MediaType), ".tar"):
+			format = ArchiveFormatTar
+		}
+
+		title := layer.Annotations[ociTitleAnnotation]
+		if format == "" && title == "" {
+			log.Warnf(
+				"Skipping layer %s with media type %s in %s",
+				layer.Digest,
+				layer.MediaType,
+				ref,
+			)
+			continue
+		}
+
+		blobPath := filepath.Join(blobsDir, fmt.Sprintf("%d", l))
+		if err := downloadLayer(image, layer.Digest, blobPath); err != nil {
+			return fmt.Errorf("Error fetching blob %s in %s: %+v", layer.Digest, ref, err)
+		}
+
+		if format != "" {
+			if err := ExtractArchive(blobPath, format, destDir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		destPath, err := archiveDestPath(destDir, title)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(blobPath, destPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *OCIClient) remoteOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(c.keychain),
+	}
+}
+
+// downloadLayer writes the raw contents of the layer with the argument digest to the
+// destination path. The contents are checked against the digest as they're read.
+func downloadLayer(image v1.Image, digest v1.Hash, destPath string) error {
+	layer, err := image.LayerByDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	reader, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return err
+}
+
+func containsMediaType(values []types.MediaType, value types.MediaType) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
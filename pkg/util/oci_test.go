@@ -0,0 +1,218 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := ParseOCIReference("ghcr.io/org/manifests:v1.2.0")
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		OCIReference{
+			Registry:   "ghcr.io",
+			Repository: "org/manifests",
+			Reference:  "v1.2.0",
+		},
+		ref,
+	)
+	assert.False(t, ref.IsDigest())
+	assert.Equal(t, "ghcr.io/org/manifests:v1.2.0", ref.String())
+
+	ref, err = ParseOCIReference("localhost:5000/manifests")
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		OCIReference{
+			Registry:   "localhost:5000",
+			Repository: "manifests",
+			Reference:  "latest",
+		},
+		ref,
+	)
+
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	ref, err = ParseOCIReference("registry.example.com/org/manifests@" + digest)
+	require.NoError(t, err)
+	assert.True(t, ref.IsDigest())
+	assert.Equal(t, digest, ref.Reference)
+
+	_, err = ParseOCIReference("manifests:v1")
+	assert.Error(t, err)
+	_, err = ParseOCIReference("ghcr.io/org/manifests@sha256:abc")
+	assert.Error(t, err)
+	_, err = ParseOCIReference("ghcr.io/org/manifests:bad/tag")
+	assert.Error(t, err)
+}
+
+func TestOCIClientPull(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_oci_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	archivePath := filepath.Join(tempDir, "layer.tar.gz")
+	WriteArchive(
+		t,
+		archivePath,
+		ArchiveFormatTarGz,
+		map[string]string{
+			"manifests/deployment.yaml": "kind: Deployment",
+		},
+	)
+	archiveContents, err := ioutil.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	registry := NewTestOCIRegistry()
+	defer registry.Server.Close()
+	registry.RequireToken = true
+
+	digest := registry.Push(
+		t,
+		"org/manifests",
+		"v1",
+		[]TestOCILayer{
+			{
+				MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+				Contents:  archiveContents,
+			},
+			{
+				MediaType: "application/vnd.example.file",
+				Contents:  []byte("kind: ConfigMap"),
+				Annotations: map[string]string{
+					ociTitleAnnotation: "manifests/configmap.yaml",
+				},
+			},
+			{
+				MediaType: "application/vnd.example.unknown",
+				Contents:  []byte("unknown"),
+			},
+		},
+	)
+
+	client := NewOCIClient()
+	ref := OCIReference{
+		Registry:   registry.Host(),
+		Repository: "org/manifests",
+		Reference:  "v1",
+	}
+
+	image, resolvedDigest, err := client.ResolveManifest(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, digest, resolvedDigest)
+	manifest, err := image.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(manifest.Layers))
+
+	destDir := filepath.Join(tempDir, "dest")
+	err = client.ExtractLayers(ref, image, destDir)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string][]string{
+			"manifests/configmap.yaml":  {"kind: ConfigMap"},
+			"manifests/deployment.yaml": {"kind: Deployment"},
+		},
+		GetContents(t, destDir),
+	)
+
+	// Digest references are verified
+	ref.Reference = digest
+	_, resolvedDigest, err = client.ResolveManifest(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, digest, resolvedDigest)
+
+	ref.Reference = "non-existent"
+	_, _, err = client.ResolveManifest(ctx, ref)
+	assert.Error(t, err)
+}
+
+func TestOCIClientCredentialHelper(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir, err := ioutil.TempDir("", "kubeapply_test_oci_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	registry := NewTestOCIRegistry()
+	defer registry.Server.Close()
+	registry.RequireToken = true
+	registry.TokenCredentials = "test-user:test-password"
+
+	registry.Push(
+		t,
+		"org/manifests",
+		"v1",
+		[]TestOCILayer{
+			{
+				MediaType: "application/vnd.example.file",
+				Contents:  []byte("kind: ConfigMap"),
+				Annotations: map[string]string{
+					ociTitleAnnotation: "configmap.yaml",
+				},
+			},
+		},
+	)
+	ref := OCIReference{
+		Registry:   registry.Host(),
+		Repository: "org/manifests",
+		Reference:  "v1",
+	}
+
+	// Without credentials, the token can't be fetched
+	t.Setenv("DOCKER_CONFIG", filepath.Join(tempDir, "empty"))
+	_, _, err = NewOCIClient().ResolveManifest(ctx, ref)
+	assert.Error(t, err)
+
+	// Credentials come from the helper that's configured for the registry
+	WriteFiles(
+		t,
+		tempDir,
+		map[string]string{
+			"docker/config.json": fmt.Sprintf(
+				`{"credHelpers": {"%s": "kubeapply-test"}}`,
+				registry.Host(),
+			),
+			"bin/docker-credential-kubeapply-test": "#!/bin/sh\n" +
+				`echo '{"Username": "test-user", "Secret": "test-password"}'` + "\n",
+		},
+	)
+	require.NoError(
+		t,
+		os.Chmod(filepath.Join(tempDir, "bin", "docker-credential-kubeapply-test"), 0755),
+	)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(tempDir, "docker"))
+	t.Setenv(
+		"PATH",
+		fmt.Sprintf(
+			"%s%c%s",
+			filepath.Join(tempDir, "bin"),
+			os.PathListSeparator,
+			os.Getenv("PATH"),
+		),
+	)
+
+	client := NewOCIClient()
+	image, _, err := client.ResolveManifest(ctx, ref)
+	require.NoError(t, err)
+
+	destDir := filepath.Join(tempDir, "dest")
+	err = client.ExtractLayers(ref, image, destDir)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string][]string{
+			"configmap.yaml": {"kind: ConfigMap"},
+		},
+		GetContents(t, destDir),
+	)
+}
@@ -1,11 +1,22 @@
 package util
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -100,3 +111,194 @@ func GetFileContents(t *testing.T, path string) string {
 	require.NoError(t, err)
 	return string(contents)
 }
+
+// WriteArchive takes a map of paths to file contents and uses this to write out an archive
+// in the argument format.
+func WriteArchive(t *testing.T, archivePath string, format string, files map[string]string) {
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	switch format {
+	case ArchiveFormatTar, ArchiveFormatTarGz:
+		var writer io.Writer = file
+		var gzipWriter *gzip.Writer
+		if format == ArchiveFormatTarGz {
+			gzipWriter = gzip.NewWriter(file)
+			writer = gzipWriter
+		}
+		tarWriter := tar.NewWriter(writer)
+
+		for path, contents := range files {
+			err = tarWriter.WriteHeader(
+				&tar.Header{
+					Name:     path,
+					Mode:     0644,
+					Size:     int64(len(contents)),
+					Typeflag: tar.TypeReg,
+				},
+			)
+			require.NoError(t, err)
+			_, err = tarWriter.Write([]byte(contents))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, tarWriter.Close())
+		if gzipWriter != nil {
+			require.NoError(t, gzipWriter.Close())
+		}
+	case ArchiveFormatZip:
+		zipWriter := zip.NewWriter(file)
+
+		for path, contents := range files {
+			writer, err := zipWriter.Create(path)
+			require.NoError(t, err)
+			_, err = writer.Write([]byte(contents))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, zipWriter.Close())
+	default:
+		assert.FailNow(t, "Unsupported archive format", format)
+	}
+}
+
+var testOCIPathRegexp = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+
+// TestOCIRegistry is an in-process registry that serves artifacts via the OCI distribution
+// API. For testing purposes only.
+type TestOCIRegistry struct {
+	Server *httptest.Server
+
+	// RequireToken indicates whether requests need a bearer token from the registry's
+	// token endpoint.
+	RequireToken bool
+
+	// TokenCredentials, if set, are the "username:password" basic auth credentials that are
+	// required to get a token.
+	TokenCredentials string
+
+	sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+// TestOCILayer is a layer in an artifact pushed to a TestOCIRegistry.
+type TestOCILayer struct {
+	MediaType   string
+	Contents    []byte
+	Annotations map[string]string
+}
+
+// NewTestOCIRegistry creates and starts a new TestOCIRegistry. The caller is responsible for
+// closing the server.
+func NewTestOCIRegistry() *TestOCIRegistry {
+	registry := &TestOCIRegistry{
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+	}
+	registry.Server = httptest.NewServer(http.HandlerFunc(registry.handle))
+	return registry
+}
+
+// Host returns the host and port of the registry.
+func (r *TestOCIRegistry) Host() string {
+	return strings.TrimPrefix(r.Server.URL, "http://")
+}
+
+// Push adds an artifact with the argument layers to the registry and returns the digest of
+// its manifest.
+func (r *TestOCIRegistry) Push(
+	t *testing.T,
+	repo string,
+	tag string,
+	layers []TestOCILayer,
+) string {
+	r.Lock()
+	defer r.Unlock()
+
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest":    r.addBlob([]byte("{}")),
+			"size":      2,
+		},
+	}
+
+	layerDescriptors := []map[string]interface{}{}
+	for _, layer := range layers {
+		layerDescriptors = append(
+			layerDescriptors,
+			map[string]interface{}{
+				"mediaType":   layer.MediaType,
+				"digest":      r.addBlob(layer.Contents),
+				"size":        len(layer.Contents),
+				"annotations": layer.Annotations,
+			},
+		)
+	}
+	manifest["layers"] = layerDescriptors
+
+	contents, err := json.Marshal(manifest)
+	require.NoError(t, err)
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(contents))
+	r.manifests[fmt.Sprintf("%s:%s", repo, tag)] = contents
+	r.manifests[fmt.Sprintf("%s:%s", repo, digest)] = contents
+
+	return digest
+}
+
+func (r *TestOCIRegistry) addBlob(contents []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(contents))
+	r.blobs[digest] = contents
+	return digest
+}
+
+func (r *TestOCIRegistry) handle(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	if req.URL.Path == "/token" {
+		if r.TokenCredentials != "" {
+			username, password, _ := req.BasicAuth()
+			if fmt.Sprintf("%s:%s", username, password) != r.TokenCredentials {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Write([]byte(`{"token": "test-token"}`))
+		return
+	}
+
+	if r.RequireToken && req.Header.Get("Authorization") != "Bearer test-token" {
+		w.Header().Set(
+			"WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, r.Server.URL),
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if req.URL.Path == "/v2/" {
+		return
+	}
+
+	matches := testOCIPathRegexp.FindStringSubmatch(req.URL.Path)
+	if matches != nil && matches[2] == "manifests" {
+		if contents, ok := r.manifests[fmt.Sprintf("%s:%s", matches[1], matches[3])]; ok {
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Write(contents)
+			return
+		}
+	} else if matches != nil && matches[2] == "blobs" {
+		if contents, ok := r.blobs[matches[3]]; ok {
+			w.Write(contents)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}